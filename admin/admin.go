@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/vonaka/shreplic/master/defs"
+)
+
+var (
+	masterAddr = flag.String("maddr", "", "Master address (or comma-separated addresses of the masters)")
+	masterPort = flag.Int("mport", 7087, "Master port")
+)
+
+const usage = `Usage: %s [options] <command>
+
+Commands:
+  status             show the replicas and the leader
+  leader             show the leader
+  transfer <id>      make replica <id> the leader (not with CURP and n²Paxos)
+  snapshot [<id>]    write the state of replica <id> (of all replicas by default)
+  quorums <file>     install the active quorums of <file> (only with Paxoi)
+  stats <id>         show the statistics of replica <id>
+  replace <id>       let a new machine replace the dead replica <id>
+
+Options:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch {
+	case cmd == "status" && len(args) == 0:
+		err = status()
+	case cmd == "leader" && len(args) == 0:
+		reply := &defs.GetLeaderReply{}
+		if err = call("GetLeader", &defs.GetLeaderArgs{}, reply); err == nil {
+			fmt.Println(reply.LeaderId)
+		}
+	case cmd == "transfer" && len(args) == 1:
+		err = transfer(args[0])
+	case cmd == "snapshot" && len(args) <= 1:
+		err = snapshot(args)
+	case cmd == "quorums" && len(args) == 1:
+		err = installQuorums(args[0])
+	case cmd == "stats" && len(args) == 1:
+		err = stats(args[0])
+	case cmd == "replace" && len(args) == 1:
+		err = replace(args[0])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// call calls method on the masters in turn until
+// one of them, the primary one, answers.
+func call(method string, args, reply interface{}) error {
+	var err error
+	for _, addr := range defs.MasterAddrs(*masterAddr, *masterPort) {
+		var m *rpc.Client
+		m, err = rpc.DialHTTP("tcp", addr)
+		if err != nil {
+			continue
+		}
+		err = m.Call("Master."+method, args, reply)
+		m.Close()
+		if !defs.IsError(err, defs.ErrNotPrimary) {
+			return err
+		}
+	}
+	return err
+}
+
+func replicaId(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return -1, fmt.Errorf("invalid replica id %s", arg)
+	}
+	return id, nil
+}
+
+func status() error {
+	info := &defs.GetClusterInfoReply{}
+	if err := call("GetClusterInfo", &defs.GetClusterInfoArgs{}, info); err != nil {
+		return err
+	}
+	leader := &defs.GetLeaderReply{}
+	if err := call("GetLeader", &defs.GetLeaderArgs{}, leader); err != nil {
+		return err
+	}
+	choices := &defs.GetLeaderChoicesReply{}
+	if err := call("GetLeaderChoices", &defs.GetLeaderChoicesArgs{}, choices); err != nil {
+		return err
+	}
+
+	protocol := info.Protocol
+	if protocol == "" {
+		protocol = "mixed"
+	}
+	fmt.Printf("%d replicas running %s (ready: %v, epoch %d)\n",
+		info.N, protocol, info.Ready, info.Epoch)
+	for i, r := range info.Replicas {
+		state := "alive"
+		if !r.Alive {
+			state = "dead"
+		}
+		mark := ""
+		if i == leader.LeaderId {
+			mark = " (leader)"
+		}
+		fmt.Printf("  %d  %-21s %-5s %s%s\n", i, r.Addr, state, r.Protocol, mark)
+	}
+	fmt.Println("leader placement policy:", choices.Policy)
+	for _, c := range choices.Choices {
+		fmt.Printf("  %s  %-8s replica %d (ranking %v)\n",
+			c.Time.Format("15:04:05"), c.Reason, c.Leader, c.Ranking)
+	}
+	return nil
+}
+
+func transfer(arg string) error {
+	id, err := replicaId(arg)
+	if err != nil {
+		return err
+	}
+	err = call("TransferLeader", &defs.TransferLeaderArgs{Replica: id},
+		&defs.TransferLeaderReply{})
+	if err == nil {
+		fmt.Printf("replica %d is the leader\n", id)
+	}
+	return err
+}
+
+func snapshot(args []string) error {
+	id := -1
+	if len(args) == 1 {
+		var err error
+		if id, err = replicaId(args[0]); err != nil {
+			return err
+		}
+	}
+	reply := &defs.SnapshotReply{}
+	if err := call("Snapshot", &defs.SnapshotArgs{Replica: id}, reply); err != nil {
+		return err
+	}
+	for i, f := range reply.Files {
+		if f == "" {
+			continue
+		}
+		if p := reply.Positions[i]; p != -1 {
+			fmt.Printf("%d: %s (%d keys, position %d)\n", i, f, reply.Keys[i], p)
+		} else {
+			fmt.Printf("%d: %s (%d keys)\n", i, f, reply.Keys[i])
+		}
+	}
+	return nil
+}
+
+func installQuorums(qfile string) error {
+	content, err := ioutil.ReadFile(qfile)
+	if err != nil {
+		return err
+	}
+	reply := &defs.InstallQuorumsReply{}
+	err = call("InstallQuorums", &defs.InstallQuorumsArgs{Content: content}, reply)
+	if err == nil {
+		fmt.Printf("replica %d leads the new quorums from ballot %d\n",
+			reply.Leader, reply.Ballot)
+	}
+	return err
+}
+
+func stats(arg string) error {
+	id, err := replicaId(arg)
+	if err != nil {
+		return err
+	}
+	reply := &defs.GetStatsReply{}
+	if err := call("GetStats", &defs.GetStatsArgs{Replica: id}, reply); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(reply.Stats))
+	for k := range reply.Stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s: %d\n", k, reply.Stats[k])
+	}
+	return nil
+}
+
+func replace(arg string) error {
+	id, err := replicaId(arg)
+	if err != nil {
+		return err
+	}
+	err = call("ReplaceReplica", &defs.ReplaceReplicaArgs{Replica: id},
+		&defs.ReplaceReplicaReply{})
+	if err == nil {
+		fmt.Printf("a new machine can now register as replica %d\n", id)
+	}
+	return err
+}
@@ -0,0 +1,285 @@
+package base
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vonaka/shreplic/server/smr"
+	"github.com/vonaka/shreplic/state"
+)
+
+// Asynchronous clients.
+//
+// A Pipeline submits commands without waiting for the results of the
+// previous ones, with at most depth commands in flight. Each command
+// is given a Future that the reply with the same CommandId completes,
+// whatever the order of the replies. The pipeline reads the replies of
+// the protocols that answer with ProposeReplyTS itself. The protocols
+// that read their own messages (ReadTable) give the results to the
+// pipeline with Deliver.
+//
+// A command whose context is done before its reply completes with the
+// error of the context, and frees its place in the pipeline. Its reply,
+// if any, is ignored.
+
+var ErrInvalidCommand = errors.New("invalid command")
+
+// Future is the result of a submitted command.
+type Future struct {
+	CommandId int32
+
+	done chan struct{}
+	val  state.Value
+	err  error
+}
+
+// Done is closed once the result of the command is known.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get waits for the result of the command.
+func (f *Future) Get() (state.Value, error) {
+	<-f.done
+	return f.val, f.err
+}
+
+// Wait waits for the result of the command until ctx is done.
+func (f *Future) Wait(ctx context.Context) (state.Value, error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type request struct {
+	future  *Future
+	propose smr.Propose
+	rid     int
+	delay   time.Duration
+}
+
+type Pipeline struct {
+	c     *Client
+	slots chan struct{}
+
+	// m protects pending and listening
+	m         sync.Mutex
+	pending   map[int32]*request
+	listening map[*bufio.Reader]bool
+}
+
+// NewPipeline returns the pipeline of c, with at most depth commands
+// in flight. From then on, the results of the commands are given to
+// the pipeline instead of ResChan.
+func (c *Client) NewPipeline(depth int) *Pipeline {
+	if depth < 1 {
+		depth = 1
+	}
+	p := &Pipeline{
+		c:         c,
+		slots:     make(chan struct{}, depth),
+		pending:   make(map[int32]*request),
+		listening: make(map[*bufio.Reader]bool),
+	}
+	p.m.Lock()
+	c.pipeline.Store(p)
+	p.listen()
+	p.m.Unlock()
+	return p
+}
+
+// Submit submits cmd and returns its future. It blocks while the
+// pipeline is full.
+func (p *Pipeline) Submit(cmd state.Command) *Future {
+	return p.SubmitContext(context.Background(), cmd)
+}
+
+// SubmitContext is like Submit, but the command is abandoned once ctx
+// is done.
+func (p *Pipeline) SubmitContext(ctx context.Context, cmd state.Command) *Future {
+	f := &Future{
+		CommandId: -1,
+		done:      make(chan struct{}),
+	}
+	if err := ctx.Err(); err != nil {
+		// select picks any ready case, even a free slot
+		f.complete(nil, err)
+		return f
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		f.complete(nil, ctx.Err())
+		return f
+	}
+
+	p.m.Lock()
+	p.c.Seqnum++
+	f.CommandId = p.c.Seqnum
+	args := smr.Propose{
+		CommandId: p.c.Seqnum,
+		ClientId:  p.c.ClientId,
+		Command:   cmd,
+		Timestamp: 0,
+	}
+	p.c.Println(cmd.String())
+	p.c.send(args)
+	rid := p.c.LastSubmitter
+	if p.c.Fast {
+		rid = p.c.ClosestId
+	}
+	p.pending[args.CommandId] = &request{
+		future:  f,
+		propose: args,
+		rid:     rid,
+		delay:   RetryDelay,
+	}
+	p.m.Unlock()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				p.complete(f.CommandId, nil, ctx.Err())
+			case <-f.done:
+			}
+		}()
+	}
+	return f
+}
+
+// Deliver gives val, the result of command cmdId, to the pipeline of
+// c. It returns false if c has no pipeline.
+func (c *Client) Deliver(cmdId int32, val state.Value) bool {
+	p := c.getPipeline()
+	if p == nil {
+		return false
+	}
+	p.complete(cmdId, val, nil)
+	return true
+}
+
+func (c *Client) getPipeline() *Pipeline {
+	p, _ := c.pipeline.Load().(*Pipeline)
+	return p
+}
+
+func (p *Pipeline) complete(cmdId int32, val state.Value, err error) {
+	p.m.Lock()
+	req, exists := p.pending[cmdId]
+	if exists {
+		delete(p.pending, cmdId)
+	}
+	p.m.Unlock()
+	if !exists {
+		return
+	}
+	<-p.slots
+	req.future.complete(val, err)
+}
+
+func (f *Future) complete(val state.Value, err error) {
+	f.val = val
+	f.err = err
+	close(f.done)
+}
+
+// listen reads the replies of the replicas to which the client is
+// connected, unless the protocol reads them itself.
+func (p *Pipeline) listen() {
+	if p.c.ReadTable {
+		return
+	}
+	p.c.wlock.Lock()
+	defer p.c.wlock.Unlock()
+	for rid, r := range p.c.readers {
+		if r != nil && !p.listening[r] {
+			p.listening[r] = true
+			go p.readReplies(rid, r)
+		}
+	}
+}
+
+func (p *Pipeline) readReplies(rid int, r *bufio.Reader) {
+	for {
+		rep := &smr.ProposeReplyTS{}
+		if err := rep.Unmarshal(r); err != nil {
+			p.c.Println("Stopped reading the replies of", rid, err)
+			p.m.Lock()
+			delete(p.listening, r)
+			p.m.Unlock()
+			return
+		}
+		p.handleReply(rid, rep)
+	}
+}
+
+func (p *Pipeline) handleReply(rid int, rep *smr.ProposeReplyTS) {
+	if rep.Epoch > atomic.LoadInt32(&p.c.Epoch) {
+		// Refresh waits for the master and the replicas,
+		// the pipeline keeps going meanwhile
+		p.c.Refresh(rep.Epoch)
+		p.m.Lock()
+		p.listen()
+		p.m.Unlock()
+	}
+
+	p.m.Lock()
+	req, exists := p.pending[rep.CommandId]
+	if !exists || req.rid != rid {
+		p.m.Unlock()
+		return
+	}
+	if rep.OK == smr.TRUE {
+		p.m.Unlock()
+		p.c.Println("Returning:", rep.Value.String())
+		p.complete(rep.CommandId, rep.Value, nil)
+		return
+	}
+
+	switch rep.Status {
+	case smr.STATUS_NOT_LEADER:
+		next := p.c.Redirect(rid, int(rep.Leader))
+		p.c.Println("Replica", rid, "is not the leader, redirecting to", next)
+		req.rid = next
+		if int(rep.Leader) == next {
+			p.c.resend(next, &req.propose)
+		} else {
+			// the leader is unknown
+			p.retry(req)
+		}
+	case smr.STATUS_OVERLOADED, smr.STATUS_ABORTED:
+		p.c.Println("Replica", rid, "rejected", rep.CommandId, "retrying in", req.delay)
+		p.retry(req)
+	case smr.STATUS_INVALID:
+		p.m.Unlock()
+		p.complete(rep.CommandId, nil, ErrInvalidCommand)
+		return
+	default:
+		p.m.Unlock()
+		p.complete(rep.CommandId, nil, errors.New("failed to receive a response"))
+		return
+	}
+	p.m.Unlock()
+}
+
+// retry resends req after its delay, doubled after each new retry.
+func (p *Pipeline) retry(req *request) {
+	time.AfterFunc(req.delay, func() {
+		p.m.Lock()
+		defer p.m.Unlock()
+		if p.pending[req.propose.CommandId] == req {
+			p.c.resend(req.rid, &req.propose)
+		}
+	})
+	if req.delay *= 2; req.delay > MaxRetryDelay {
+		req.delay = MaxRetryDelay
+	}
+}
@@ -0,0 +1,242 @@
+package base
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vonaka/shreplic/server/smr"
+	"github.com/vonaka/shreplic/state"
+)
+
+// newTestClient returns a client connected to n replicas, the other
+// ends of which are returned. The leader is replica 0.
+func newTestClient(t *testing.T, n int) (*Client, []net.Conn) {
+	c := NewClientWithLog("127.0.0.1", 7087, false, false, false, false, nil)
+	c.N = n
+	c.LeaderId = 0
+	c.servers = make([]net.Conn, n)
+	c.readers = make([]*bufio.Reader, n)
+	c.writers = make([]*bufio.Writer, n)
+	replicas := make([]net.Conn, n)
+	for i := range replicas {
+		server, replica := net.Pipe()
+		t.Cleanup(func() {
+			server.Close()
+			replica.Close()
+		})
+		c.servers[i] = server
+		c.readers[i] = bufio.NewReader(server)
+		c.writers[i] = bufio.NewWriter(server)
+		replicas[i] = replica
+	}
+	return c, replicas
+}
+
+// readProposals reads the proposals received by the replica.
+func readProposals(replica net.Conn) chan *smr.Propose {
+	proposals := make(chan *smr.Propose, 16)
+	go func() {
+		reader := bufio.NewReader(replica)
+		for {
+			if code, err := reader.ReadByte(); err != nil || code != smr.PROPOSE {
+				return
+			}
+			p := &smr.Propose{}
+			if p.Unmarshal(reader) != nil {
+				return
+			}
+			proposals <- p
+		}
+	}()
+	return proposals
+}
+
+func reply(t *testing.T, w *bufio.Writer, p *smr.Propose) {
+	rep := &smr.ProposeReplyTS{
+		OK:        smr.TRUE,
+		CommandId: p.CommandId,
+		Value:     p.Command.V,
+		Leader:    -1,
+	}
+	rep.Marshal(w)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, proposals chan *smr.Propose) *smr.Propose {
+	select {
+	case p := <-proposals:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("proposal not received")
+	}
+	return nil
+}
+
+func put(v string) state.Command {
+	return state.Command{
+		Op: state.PUT,
+		K:  state.Key(1),
+		V:  state.Value(v),
+	}
+}
+
+func TestPipelineOutOfOrder(t *testing.T) {
+	c, replicas := newTestClient(t, 1)
+	proposals := readProposals(replicas[0])
+	w := bufio.NewWriter(replicas[0])
+	p := c.NewPipeline(3)
+
+	values := []string{"a", "b", "c"}
+	fs := []*Future{}
+	ps := []*smr.Propose{}
+	for _, v := range values {
+		fs = append(fs, p.Submit(put(v)))
+		ps = append(ps, receive(t, proposals))
+	}
+	if c.LastSubmitted() != fs[2].CommandId {
+		t.Errorf("last submitted %d, want %d", c.LastSubmitted(), fs[2].CommandId)
+	}
+
+	// the replies arrive in the reverse order
+	for i := len(ps) - 1; i >= 0; i-- {
+		reply(t, w, ps[i])
+	}
+	for i, f := range fs {
+		val, err := f.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != values[i] {
+			t.Errorf("command %d: result %q, want %q", f.CommandId, val, values[i])
+		}
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	c, replicas := newTestClient(t, 1)
+	proposals := readProposals(replicas[0])
+	w := bufio.NewWriter(replicas[0])
+	p := c.NewPipeline(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := p.SubmitContext(ctx, put("a"))
+	late := receive(t, proposals)
+	cancel()
+	if _, err := f.Get(); err != context.Canceled {
+		t.Fatalf("canceled command: error %v", err)
+	}
+
+	// the canceled command freed its place
+	next := make(chan *Future)
+	go func() {
+		next <- p.Submit(put("b"))
+	}()
+	var g *Future
+	select {
+	case g = <-next:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeline still full after a cancellation")
+	}
+
+	// the late reply to the canceled command is ignored
+	reply(t, w, late)
+	reply(t, w, receive(t, proposals))
+	if val, err := g.Get(); err != nil || string(val) != "b" {
+		t.Errorf("result %q (error %v), want \"b\"", val, err)
+	}
+
+	// a command whose context is done is not submitted
+	f = p.SubmitContext(ctx, put("c"))
+	if _, err := f.Get(); err != context.Canceled || f.CommandId != -1 {
+		t.Errorf("command %d submitted after a cancellation (error %v)", f.CommandId, err)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		connected []bool
+		rid       int
+		leader    int
+		want      int
+	}{
+		{"known leader", []bool{true, true, true}, 0, 2, 2},
+		{"unknown leader", []bool{true, true, true}, 0, -1, 1},
+		{"self", []bool{true, true, true}, 1, 1, 2},
+		{"leader out of range", []bool{true, true, true}, 2, 3, 0},
+		{"disconnected leader", []bool{true, true, false}, 0, 2, 1},
+		{"skip disconnected", []bool{true, false, true}, 0, -1, 2},
+		{"wrap around", []bool{true, false, false}, 2, -1, 0},
+	} {
+		c, _ := newTestClient(t, len(test.connected))
+		for i, connected := range test.connected {
+			if !connected {
+				c.writers[i] = nil
+			}
+		}
+		next := c.Redirect(test.rid, test.leader)
+		if next != test.want {
+			t.Errorf("%s: redirected to %d, want %d", test.name, next, test.want)
+		}
+		if c.LeaderId != next || c.LastSubmitter != next {
+			t.Errorf("%s: leader %d, last submitter %d, want %d",
+				test.name, c.LeaderId, c.LastSubmitter, next)
+		}
+	}
+}
+
+func TestPipelineRedirect(t *testing.T) {
+	c, replicas := newTestClient(t, 2)
+	proposals := []chan *smr.Propose{readProposals(replicas[0]), readProposals(replicas[1])}
+	p := c.NewPipeline(1)
+	f := p.Submit(put("a"))
+	propose := receive(t, proposals[0])
+
+	// replica 0 is not the leader, 1 is
+	(&smr.ProposeReplyTS{
+		OK:        smr.FALSE,
+		CommandId: propose.CommandId,
+		Value:     state.NIL(),
+		Status:    smr.STATUS_NOT_LEADER,
+		Leader:    1,
+	}).Marshal(replicas[0])
+	propose = receive(t, proposals[1])
+	if propose.CommandId != f.CommandId {
+		t.Fatalf("command %d sent to the leader, want %d", propose.CommandId, f.CommandId)
+	}
+	if c.LeaderId != 1 {
+		t.Errorf("leader is %d, want 1", c.LeaderId)
+	}
+
+	reply(t, bufio.NewWriter(replicas[1]), propose)
+	if val, err := f.Get(); err != nil || string(val) != "a" {
+		t.Errorf("result %q (error %v), want \"a\"", val, err)
+	}
+}
+
+func TestDeliverBeforePipeline(t *testing.T) {
+	c, _ := newTestClient(t, 1)
+
+	// a reader of the protocol delivers results while the pipeline
+	// is created
+	delivered := make(chan struct{})
+	go func() {
+		for !c.Deliver(-1, state.NIL()) {
+		}
+		close(delivered)
+	}()
+	p := c.NewPipeline(1)
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("result not delivered to the pipeline")
+	}
+	if c.getPipeline() != p {
+		t.Error("results delivered to another pipeline")
+	}
+}
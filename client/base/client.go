@@ -13,15 +13,15 @@ import (
 	"net/http"
 	"net/rpc"
 	"os"
-	"os/exec"
-	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/vonaka/shreplic/master/defs"
 	"github.com/vonaka/shreplic/server/smr"
 	"github.com/vonaka/shreplic/state"
+	"github.com/vonaka/shreplic/tools"
 	"github.com/vonaka/shreplic/tools/fastrpc"
 )
 
@@ -49,20 +49,46 @@ type Client struct {
 	ResChan   chan []byte
 	Waiting   chan struct{}
 	ReadTable bool
+	// Protocol is the protocol the replicas must run,
+	// any protocol is accepted if empty
+	Protocol string
 
 	servers []net.Conn
 	readers []*bufio.Reader
 	writers []*bufio.Writer
+	// wlock protects the connections and the leader of the client,
+	// which Refresh may change, and serializes the writes of the
+	// pipeline (see Pipeline) with those of the protocols
+	wlock sync.Mutex
+	// pipeline is the *Pipeline of the client, if any, which the
+	// readers of the replies load
+	pipeline atomic.Value
+	// submitted is the id of the last command sent
+	submitted int32
+
+	// Epoch is the configuration epoch of the view of the client
+	// (see Refresh), accessed atomically
+	Epoch int32
+	// refreshing serializes the calls to Refresh
+	refreshing sync.Mutex
 
 	Logger         *log.Logger
-	masterPort     int
-	masterAddr     string
+	masters        []string
+	master         int
 	replicaList    []string
+	alive          []bool
 	collocatedWith string
 }
 
 const TIMEOUT = 3 * time.Second
 
+var (
+	// delay before resending a proposal rejected by an overloaded
+	// replica, doubled after each new rejection
+	RetryDelay    = 10 * time.Millisecond
+	MaxRetryDelay = time.Second
+)
+
 func NewClient(maddr string, mport int, fast, lread, leaderLess, verbose bool) *Client {
 	return NewClientWithLog(maddr, mport, fast, lread, leaderLess, verbose, nil)
 }
@@ -95,17 +121,20 @@ func NewClientWithLog(maddr string, mport int,
 		ResChan:   make(chan []byte, 8),
 		Waiting:   make(chan struct{}, 8),
 		ReadTable: false,
+		Protocol:  "",
 
-		servers: nil,
-		readers: nil,
-		writers: nil,
+		servers:   nil,
+		readers:   nil,
+		writers:   nil,
+		submitted: -1,
 
 		Ping: []float64{},
 
 		Logger:         logger,
-		masterPort:     mport,
-		masterAddr:     maddr,
+		masters:        defs.MasterAddrs(maddr, mport),
+		master:         0,
 		replicaList:    nil,
+		alive:          nil,
 		collocatedWith: "",
 	}
 }
@@ -115,28 +144,25 @@ func (c *Client) Collocated(with string) {
 }
 
 func (c *Client) Connect() error {
-	c.Println("Dialing master...")
-	master, err := c.dialMaster()
-	if err != nil {
-		return err
-	}
-	defer master.Close()
-
 	c.Println("Getting list of replicas...")
-	rl, err := askMaster(master, "GetReplicaList", c.Logger)
+	list, alive, epoch, err := c.getReplicaList()
 	if err != nil {
 		return err
 	}
-	masterReply := rl.(*defs.GetReplicaListReply)
-	c.replicaList = masterReply.ReplicaList
+	c.replicaList = list
+	c.alive = alive
+	c.Epoch = epoch
 
 	c.Println("Searching for the closest replica...")
-	err = c.findClosestReplica(masterReply.AliveList)
+	err = c.findClosestReplica(alive)
 	if err != nil {
 		return err
 	}
 	c.Println("Node list", c.replicaList)
 	c.Println("Closest (alive)", c.ClosestId)
+	if Cluster == nil {
+		c.reportClosest()
+	}
 
 	c.N = len(c.replicaList)
 	c.servers = make([]net.Conn, c.N)
@@ -144,13 +170,11 @@ func (c *Client) Connect() error {
 	c.writers = make([]*bufio.Writer, c.N)
 
 	if !c.Leaderless {
-		c.Println("Getting leader from master...")
-		gl, err := askMaster(master, "GetLeader", c.Logger)
+		c.Println("Getting leader...")
+		c.LeaderId, err = c.getLeader()
 		if err != nil {
 			return err
 		}
-		masterReply := gl.(*defs.GetLeaderReply)
-		c.LeaderId = masterReply.LeaderId
 		c.Println("The leader is replicas", c.LeaderId)
 	}
 
@@ -158,49 +182,187 @@ func (c *Client) Connect() error {
 	// Connect to all even if !c.Fast
 	// this simplifies the connection to the new leader when the old one is down
 	for i := 0; i < c.N; i++ {
-		if masterReply.AliveList[i] {
+		if alive[i] {
 			toConnect = append(toConnect, i)
 		}
 	}
 
 	for _, i := range toConnect {
-		c.Println("Connection to", i, "->", c.replicaList[i])
-		c.servers[i], err = dial(c.replicaList[i], false, c.Logger)
-		if err != nil {
+		if err = c.connect(i); err != nil {
 			return err
 		}
-		c.readers[i] = bufio.NewReader(c.servers[i])
-		c.writers[i] = bufio.NewWriter(c.servers[i])
-		go func(reader *bufio.Reader) {
-			// track RPC-table
-			for c.ReadTable {
-				var (
-					msgType uint8
-					err     error
-				)
-				if msgType, err = reader.ReadByte(); err != nil {
-					break
-				}
-				p, exists := c.RPC.Get(msgType)
-				if !exists {
-					c.Println("Error: received unknown message:", msgType)
-					continue
-				}
-				obj := p.Obj.New()
-				if err = obj.Unmarshal(reader); err != nil {
-					break
-				}
-				go func(obj fastrpc.Serializable) {
-					p.Chan <- obj
-				}(obj)
-			}
-		}(c.readers[i])
 	}
 
 	c.Println("Connected")
 	return nil
 }
 
+func (c *Client) connect(i int) error {
+	conn, err := c.connectTo(i, c.replicaList[i])
+	if err != nil {
+		return err
+	}
+	c.servers[i] = conn.server
+	c.readers[i] = conn.reader
+	c.writers[i] = conn.writer
+	go c.readTable(i, conn.reader)
+	return nil
+}
+
+// replicaConn is a connection to a replica.
+type replicaConn struct {
+	server net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// connectTo connects to replica i, whose address is addr, and
+// introduces the client.
+func (c *Client) connectTo(i int, addr string) (*replicaConn, error) {
+	c.Println("Connection to", i, "->", addr)
+	server, err := dial(addr, false, c.Logger)
+	if err != nil {
+		return nil, err
+	}
+	conn := &replicaConn{
+		server: server,
+		reader: bufio.NewReader(server),
+		writer: bufio.NewWriter(server),
+	}
+	if err = c.handshake(i, conn); err != nil {
+		server.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// readTable tracks the RPC-table on the connection to replica rid
+// read by reader.
+func (c *Client) readTable(rid int, reader *bufio.Reader) {
+	for c.ReadTable {
+		var (
+			msgType uint8
+			err     error
+		)
+		if msgType, err = reader.ReadByte(); err != nil {
+			break
+		}
+		if msgType == smr.PROPOSE_REPLY {
+			rep := &smr.ProposeReplyTS{}
+			if err = rep.Unmarshal(reader); err != nil {
+				break
+			}
+			c.rejected(rid, rep)
+			continue
+		}
+		p, exists := c.RPC.Get(msgType)
+		if !exists {
+			c.Println("Error: received unknown message:", msgType)
+			continue
+		}
+		obj := p.Obj.New()
+		if err = obj.Unmarshal(reader); err != nil {
+			break
+		}
+		go func(obj fastrpc.Serializable) {
+			p.Chan <- obj
+		}(obj)
+	}
+}
+
+// Refresh asks the master for its view of the replicas if epoch, given
+// by a replica, is more recent than the view of the client. It connects
+// to the replicas that have been replaced or that are alive again, and
+// updates the leader. The epoch of the client only advances if all of
+// this succeeds, so that the next reply tagged with epoch retries.
+//
+// Refresh can be called concurrently with the pipeline: it talks to
+// the master and to the replicas without holding wlock, and only
+// installs the new view under it.
+func (c *Client) Refresh(epoch int32) {
+	if Cluster != nil || epoch <= atomic.LoadInt32(&c.Epoch) {
+		return
+	}
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+	if epoch <= atomic.LoadInt32(&c.Epoch) {
+		// refreshed by another call meanwhile
+		return
+	}
+	list, alive, view, err := c.getReplicaList()
+	if err != nil {
+		c.Println("Cannot refresh the view:", err)
+		return
+	}
+	c.Println("Configuration epoch", view, "list", list, "alive", alive)
+	refreshed := true
+	// the new connections, nil if the replica cannot be reached
+	conns := make(map[int]*replicaConn)
+	for i := range list {
+		if !alive[i] || (c.alive[i] && list[i] == c.replicaList[i]) {
+			continue
+		}
+		conn, err := c.connectTo(i, list[i])
+		if err != nil {
+			c.Println("Cannot connect to", i, err)
+			refreshed = false
+		}
+		conns[i] = conn
+	}
+	leader := -1
+	if !c.Leaderless {
+		if leader, err = c.getLeader(); err != nil {
+			c.Println("Cannot reach the leader", leader, err)
+			refreshed = false
+		}
+	}
+
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	c.replicaList = list
+	c.alive = alive
+	for i, conn := range conns {
+		if c.servers[i] != nil {
+			c.servers[i].Close()
+		}
+		if conn == nil {
+			c.servers[i], c.readers[i], c.writers[i] = nil, nil, nil
+			continue
+		}
+		c.servers[i] = conn.server
+		c.readers[i] = conn.reader
+		c.writers[i] = conn.writer
+		go c.readTable(i, conn.reader)
+	}
+	if leader != -1 {
+		if c.connected(leader) {
+			c.LeaderId = leader
+		} else {
+			c.Println("Cannot reach the leader", leader)
+			refreshed = false
+		}
+	}
+	if refreshed {
+		atomic.StoreInt32(&c.Epoch, view)
+	}
+}
+
+func (c *Client) handshake(i int, conn *replicaConn) error {
+	var table *fastrpc.Table
+	if c.ReadTable {
+		table = c.RPC
+	}
+	hello := smr.NewHello(smr.HELLO_CLIENT, c.ClientId, c.Protocol, table, 0)
+	reply, err := smr.Handshake(conn.reader, conn.writer, hello)
+	if err != nil {
+		return err
+	}
+	if reply.OK != smr.TRUE {
+		return fmt.Errorf("replica %d rejected the connection: %s", i, reply.Reason)
+	}
+	return nil
+}
+
 func (c *Client) Disconnect() {
 	for _, server := range c.servers {
 		if server != nil {
@@ -211,21 +373,13 @@ func (c *Client) Disconnect() {
 }
 
 func (c *Client) Reconnect() error {
-	c.Println("Dialing master...")
-	master, err := c.dialMaster()
-	if err != nil {
-		return err
-	}
-	defer master.Close()
-
+	var err error
 	if !c.Leaderless {
-		c.Println("Getting leader from master...")
-		gl, err := askMaster(master, "GetLeader", c.Logger)
+		c.Println("Getting leader...")
+		c.LeaderId, err = c.getLeader()
 		if err != nil {
 			return err
 		}
-		masterReply := gl.(*defs.GetLeaderReply)
-		c.LeaderId = masterReply.LeaderId
 		c.Println("The leader is replicas", c.LeaderId)
 	}
 
@@ -314,6 +468,8 @@ func (c *Client) Printf(format string, v ...interface{}) {
 }
 
 func (c *Client) SendMsg(rid int32, code uint8, msg fastrpc.Serializable) {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
 	w := c.writers[rid]
 	if w == nil {
 		log.Printf("%d: no associated writer", rid)
@@ -325,12 +481,24 @@ func (c *Client) SendMsg(rid int32, code uint8, msg fastrpc.Serializable) {
 }
 
 func (c *Client) execute(args smr.Propose) []byte {
+	c.send(args)
+	c.Waiting <- struct{}{}
+	return <-c.ResChan
+}
+
+// send sends args to the leader, or to the closest replica if the
+// client is leaderless, or to every replica if it is fast.
+func (c *Client) send(args smr.Propose) {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+
 	submitter := c.LeaderId
 	if c.Leaderless {
 		submitter = c.ClosestId
 	}
 	c.LastSubmitter = submitter
 	c.LastPropose = args
+	atomic.StoreInt32(&c.submitted, args.CommandId)
 
 	if !c.Fast {
 		c.Println("Sent to", submitter)
@@ -347,9 +515,80 @@ func (c *Client) execute(args smr.Propose) []byte {
 			}
 		}
 	}
+}
 
-	c.Waiting <- struct{}{}
-	return <-c.ResChan
+// Redirect is called when replica rid refuses a proposal because
+// it is not the leader. It returns the replica to which the proposals
+// must be sent from now on: leader if it is known, the next
+// connected replica otherwise.
+func (c *Client) Redirect(rid, leader int) int {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	if leader == rid || !c.connected(leader) {
+		for i := 1; i <= c.N; i++ {
+			if next := (rid + i) % c.N; c.writers[next] != nil {
+				leader = next
+				break
+			}
+		}
+	}
+	c.LeaderId = leader
+	c.LastSubmitter = leader
+	return leader
+}
+
+// LastSubmitted returns the id of the last command sent by the client.
+// Unlike Seqnum, it can be read while a pipeline submits commands.
+func (c *Client) LastSubmitted() int32 {
+	return atomic.LoadInt32(&c.submitted)
+}
+
+// connected returns true if the client is connected to replica rid.
+func (c *Client) connected(rid int) bool {
+	return rid >= 0 && rid < c.N && c.writers[rid] != nil
+}
+
+// Resend sends the last proposal again to replica rid.
+func (c *Client) Resend(rid int) {
+	c.resend(rid, &c.LastPropose)
+}
+
+func (c *Client) resend(rid int, args *smr.Propose) {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	c.writePropose(rid, args)
+}
+
+// rejected handles rep, the rejection by replica rid of a proposal of
+// a client that reads with the RPC table. Overloaded replicas receive
+// the proposal again after RetryDelay.
+func (c *Client) rejected(rid int, rep *smr.ProposeReplyTS) {
+	if p := c.getPipeline(); p != nil {
+		p.handleReply(rid, rep)
+		return
+	}
+	if rep.Status != smr.STATUS_OVERLOADED && rep.Status != smr.STATUS_ABORTED {
+		c.Println("Replica", rid, "rejected", rep.CommandId, "status", rep.Status)
+		return
+	}
+	time.AfterFunc(RetryDelay, func() {
+		c.wlock.Lock()
+		defer c.wlock.Unlock()
+		if c.LastPropose.CommandId == rep.CommandId {
+			c.writePropose(rid, &c.LastPropose)
+		}
+	})
+}
+
+// writePropose must be called with wlock locked.
+func (c *Client) writePropose(rid int, args *smr.Propose) {
+	w := c.writers[rid]
+	if w == nil {
+		return
+	}
+	w.WriteByte(smr.PROPOSE)
+	args.Marshal(w)
+	w.Flush()
 }
 
 func (c *Client) findClosestReplica(alive []bool) error {
@@ -362,7 +601,7 @@ func (c *Client) findClosestReplica(alive []bool) error {
 		if !alive[i] {
 			continue
 		}
-		addr := strings.Split(string(c.replicaList[i]), ":")[0]
+		addr, port, _ := net.SplitHostPort(c.replicaList[i])
 		if addr == "" {
 			addr = "127.0.0.1"
 		}
@@ -373,9 +612,8 @@ func (c *Client) findClosestReplica(alive []bool) error {
 			c.ClosestId = i
 		}
 
-		out, err := exec.Command("ping", addr, "-c 3", "-q").Output()
+		latency, err := tools.Ping(net.JoinHostPort(addr, port), 3)
 		if err == nil {
-			latency, _ := strconv.ParseFloat(strings.Split(string(out), "/")[4], 64)
 			c.Logger.Println(i, "->", latency)
 			c.Ping = append(c.Ping, latency)
 
@@ -399,13 +637,73 @@ func (c *Client) findClosestReplica(alive []bool) error {
 	return nil
 }
 
-func (c *Client) dialMaster() (*rpc.Client, error) {
-	addr := fmt.Sprintf("%s:%d", c.masterAddr, c.masterPort)
-	conn, err := dial(addr, true, c.Logger)
+// getReplicaList returns the list of replicas, the replicas that are
+// alive, and the epoch of this view.
+func (c *Client) getReplicaList() ([]string, []bool, int32, error) {
+	if Cluster != nil {
+		// pingReplicas pings the replicas of c.replicaList
+		c.replicaList = Cluster.Addrs()
+		return c.replicaList, c.pingReplicas(), 0, nil
+	}
+	rl, err := c.askMasters("GetReplicaList")
 	if err != nil {
-		return nil, err
+		return nil, nil, 0, err
+	}
+	masterReply := rl.(*defs.GetReplicaListReply)
+	return masterReply.ReplicaList, masterReply.AliveList, masterReply.Epoch, nil
+}
+
+func (c *Client) getLeader() (int, error) {
+	if Cluster != nil {
+		return c.askReplicasLeader(c.pingReplicas()), nil
 	}
-	return rpc.NewClient(conn), nil
+	gl, err := c.askMasters("GetLeader")
+	if err != nil {
+		return -1, err
+	}
+	reply := gl.(*defs.GetLeaderReply)
+	return reply.LeaderId, nil
+}
+
+// reportClosest tells the master which replica is the closest
+// one, which the leader placement policies may take into account.
+func (c *Client) reportClosest() {
+	conn, err := dial(c.masters[c.master], true, c.Logger)
+	if err != nil {
+		return
+	}
+	master := rpc.NewClient(conn)
+	defer master.Close()
+
+	args := &defs.ReportClientArgs{
+		ClientId: c.ClientId,
+		Closest:  c.ClosestId,
+	}
+	call(master, "Master.ReportClient", args, &defs.ReportClientReply{}, c.Logger)
+}
+
+// askMasters asks the masters in turn, starting from the last one
+// that answered, until one of them answers.
+func (c *Client) askMasters(method string) (interface{}, error) {
+	var err error
+	for i := range c.masters {
+		m := (c.master + i) % len(c.masters)
+		c.Println("Dialing master", c.masters[m], "...")
+		conn, e := dial(c.masters[m], true, c.Logger)
+		if e != nil {
+			err = e
+			continue
+		}
+		master := rpc.NewClient(conn)
+		reply, e := askMaster(master, method, c.Logger)
+		master.Close()
+		if e == nil {
+			c.master = m
+			return reply, nil
+		}
+		err = e
+	}
+	return nil, err
 }
 
 func dial(addr string, connect bool, logger *log.Logger) (net.Conn, error) {
@@ -441,6 +739,30 @@ func dial(addr string, connect bool, logger *log.Logger) (net.Conn, error) {
 	return nil, errors.New("cannot connect")
 }
 
+// GetClusterInfo asks the masters at maddr (see defs.MasterAddrs)
+// what the replicas are.
+func GetClusterInfo(maddr string, mport int, l *log.Logger) (*defs.GetClusterInfoReply, error) {
+	if l == nil {
+		l = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	var err error
+	for _, addr := range defs.MasterAddrs(maddr, mport) {
+		conn, e := dial(addr, true, l)
+		if e != nil {
+			err = e
+			continue
+		}
+		master := rpc.NewClient(conn)
+		info := &defs.GetClusterInfoReply{}
+		err = call(master, "Master.GetClusterInfo", &defs.GetClusterInfoArgs{}, info, l)
+		master.Close()
+		if err == nil {
+			return info, nil
+		}
+	}
+	return nil, err
+}
+
 func askMaster(master *rpc.Client, method string, l *log.Logger) (interface{}, error) {
 	var (
 		gl     *defs.GetLeaderReply
@@ -0,0 +1,64 @@
+package base
+
+import (
+	"net/rpc"
+
+	"github.com/vonaka/shreplic/server/smr"
+)
+
+// Cluster is non-nil if the clients get the replicas from a cluster
+// file instead of the master (see smr.Cluster).
+var Cluster *smr.Cluster
+
+// pingReplicas returns the replicas of Cluster that answer.
+func (c *Client) pingReplicas() []bool {
+	alive := make([]bool, len(c.replicaList))
+	for i, addr := range c.replicaList {
+		rep, err := c.dialReplica(addr)
+		if err != nil {
+			continue
+		}
+		err = call(rep, "Replica.Ping", &smr.PingArgs{}, &smr.PingReply{}, c.Logger)
+		alive[i] = err == nil
+		rep.Close()
+	}
+	return alive
+}
+
+// askReplicasLeader returns the first leader known by an alive
+// replica. If none knows it, it returns the initial leader of Cluster
+// if it is alive, otherwise the first alive replica.
+func (c *Client) askReplicasLeader(alive []bool) int {
+	for i, addr := range c.replicaList {
+		if !alive[i] {
+			continue
+		}
+		rep, err := c.dialReplica(addr)
+		if err != nil {
+			continue
+		}
+		reply := &smr.GetLeaderReply{}
+		err = call(rep, "Replica.GetLeader", &smr.GetLeaderArgs{}, reply, c.Logger)
+		rep.Close()
+		if err == nil && reply.Leader >= 0 {
+			return int(reply.Leader)
+		}
+	}
+	if alive[Cluster.Leader] {
+		return Cluster.Leader
+	}
+	for i := range alive {
+		if alive[i] {
+			return i
+		}
+	}
+	return Cluster.Leader
+}
+
+func (c *Client) dialReplica(addr string) (*rpc.Client, error) {
+	conn, err := dial(smr.RPCAddr(addr), true, c.Logger)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
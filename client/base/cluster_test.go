@@ -0,0 +1,107 @@
+package base
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"testing"
+
+	"github.com/vonaka/shreplic/server/smr"
+)
+
+// testReplica answers the RPCs that the clients send to the replicas of
+// a cluster file.
+type testReplica struct {
+	leader int32
+}
+
+func (r *testReplica) Ping(args *smr.PingArgs, reply *smr.PingReply) error {
+	return nil
+}
+
+func (r *testReplica) GetLeader(args *smr.GetLeaderArgs, reply *smr.GetLeaderReply) error {
+	reply.Leader = r.leader
+	return nil
+}
+
+// startReplica returns the address of a replica that knows leader
+// (see smr.RPCAddr).
+func startReplica(t *testing.T, leader int32) string {
+	s := rpc.NewServer()
+	if err := s.RegisterName("Replica", &testReplica{leader}); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+	})
+	go http.Serve(l, s)
+
+	port := l.Addr().(*net.TCPAddr).Port
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port-1000))
+}
+
+// deadReplica returns the address of a replica that does not answer.
+func deadReplica(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port-1000))
+}
+
+func TestClusterLeader(t *testing.T) {
+	cluster := Cluster
+	defer func() {
+		Cluster = cluster
+	}()
+
+	logger := log.New(ioutil.Discard, "", 0)
+	for _, test := range []struct {
+		// leader known by each replica, -2 if the replica is dead
+		replicas []int32
+		// initial leader of the cluster file
+		leader int
+		want   int
+	}{
+		// the leader known by the replicas
+		{[]int32{-1, -2, 2}, 0, 2},
+		{[]int32{1, 1, -2}, 0, 1},
+		// the initial leader
+		{[]int32{-1, -1, -1}, 1, 1},
+		// the first alive replica
+		{[]int32{-2, -1, -1}, 0, 1},
+		// no replica is alive
+		{[]int32{-2, -2}, 1, 1},
+	} {
+		c := NewClientWithLog("127.0.0.1", 7087, false, false, false, false, logger)
+		c.replicaList = make([]string, len(test.replicas))
+		for i, leader := range test.replicas {
+			if leader == -2 {
+				c.replicaList[i] = deadReplica(t)
+			} else {
+				c.replicaList[i] = startReplica(t, leader)
+			}
+		}
+		Cluster = &smr.Cluster{Leader: test.leader}
+
+		alive := c.pingReplicas()
+		for i, leader := range test.replicas {
+			if alive[i] != (leader != -2) {
+				t.Errorf("%v: replica %d alive: %v", test.replicas, i, alive[i])
+			}
+		}
+		if leader := c.askReplicasLeader(alive); leader != test.want {
+			t.Errorf("%v with initial leader %d: leader %d, want %d",
+				test.replicas, test.leader, leader, test.want)
+		}
+	}
+}
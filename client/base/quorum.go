@@ -0,0 +1,42 @@
+package base
+
+import (
+	"io/ioutil"
+	"log"
+
+	"github.com/vonaka/shreplic/server/smr"
+)
+
+// GetQuorum returns the weighted or hierarchical quorum system that the
+// replicas use instead of majorities (see smr.QuorumFromFile), or nil if
+// they use majorities. The quorum files of the replicas are given by the
+// masters at maddr, unless the client gets the replicas from Cluster, in
+// which case the quorum file is qfile (none if empty).
+func GetQuorum(maddr string, mport int, qfile string, l *log.Logger) (smr.QuorumI, error) {
+	if Cluster != nil {
+		if qfile == "" {
+			return nil, nil
+		}
+		content, err := ioutil.ReadFile(qfile)
+		if err != nil {
+			return nil, err
+		}
+		return smr.ParseQuorumOf(Cluster.Addrs(), -1, qfile, content)
+	}
+
+	info, err := GetClusterInfo(maddr, mport, l)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(info.Replicas))
+	for i, rep := range info.Replicas {
+		addrs[i] = rep.Addr
+	}
+	for _, rep := range info.Replicas {
+		if len(rep.Quorums) != 0 {
+			return smr.ParseQuorumOf(addrs, rep.MaxFailures,
+				"quorums of "+rep.Addr, rep.Quorums)
+		}
+	}
+	return nil, nil
+}
@@ -1,6 +1,7 @@
 package base
 
 import (
+	"context"
 	"errors"
 	"log"
 	"math/rand"
@@ -11,6 +12,10 @@ import (
 	"github.com/vonaka/shreplic/state"
 )
 
+// PipelineDepth is the number of requests a SimpleClient keeps in
+// flight, 1 for a closed loop (see Pipeline).
+var PipelineDepth = 1
+
 type SimpleClient struct {
 	*Client
 
@@ -134,6 +139,9 @@ func (c *SimpleClient) run(connect bool) error {
 		c.Disconnect()
 	}
 	c.Println("Client", c.ClientId, "is up")
+	if PipelineDepth > 1 {
+		return c.runPipeline()
+	}
 
 	var (
 		before      time.Time
@@ -195,12 +203,76 @@ func (c *SimpleClient) run(connect bool) error {
 	return nil
 }
 
+func (c *SimpleClient) runPipeline() error {
+	type submitted struct {
+		f      *Future
+		before time.Time
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := c.NewPipeline(PipelineDepth)
+	reqs := make(chan submitted, PipelineDepth)
+	go func() {
+		defer close(reqs)
+		clientKey := int64(uuid.New().Time())
+		for i := 0; i < c.reqNum; i++ {
+			key := clientKey
+			if c.GetClientKey != nil {
+				key = int64(c.GetClientKey())
+			}
+			if randomTrue(c.conflict) {
+				key = 42
+			}
+			cmd := state.Command{
+				Op: state.GET,
+				K:  state.Key(key),
+				V:  state.NIL(),
+			}
+			if randomTrue(c.writes) {
+				value := make([]byte, c.psize)
+				rand.Read(value)
+				cmd.Op = state.PUT
+				cmd.V = value
+			}
+			before := time.Now()
+			select {
+			case reqs <- submitted{p.SubmitContext(ctx, cmd), before}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	beforeTotal := time.Now()
+	for req := range reqs {
+		if _, err := req.f.Get(); err != nil {
+			return err
+		}
+		after := time.Now()
+		c.Printf("latency %v\n", to_ms(after.Sub(req.before).Nanoseconds()))
+		c.Printf("chain %d-1\n", int64(to_ms(after.UnixNano())))
+	}
+	c.Printf("Test took %v\n", time.Now().Sub(beforeTotal))
+	c.Disconnect()
+	return nil
+}
+
 func (c *SimpleClient) waitReplies(rid int, cmdId int32) error {
+	delay := RetryDelay
+	backoff := func() {
+		time.Sleep(delay)
+		if delay *= 2; delay > MaxRetryDelay {
+			delay = MaxRetryDelay
+		}
+	}
+
 	for {
 		rep, err := c.ProposeReplyFrom(rid)
 		if err != nil {
 			return err
 		}
+		c.Refresh(rep.Epoch)
 		if rep.CommandId != cmdId {
 			continue
 		}
@@ -208,7 +280,25 @@ func (c *SimpleClient) waitReplies(rid int, cmdId int32) error {
 			c.Println("Returning:", rep.Value.String())
 			c.ResChan <- rep.Value
 			break
-		} else {
+		}
+
+		switch rep.Status {
+		case smr.STATUS_NOT_LEADER:
+			next := c.Redirect(rid, int(rep.Leader))
+			c.Println("Replica", rid, "is not the leader, redirecting to", next)
+			if int(rep.Leader) != next {
+				// the leader is unknown
+				backoff()
+			}
+			rid = next
+			c.Resend(rid)
+		case smr.STATUS_OVERLOADED, smr.STATUS_ABORTED:
+			c.Println("Replica", rid, "rejected", cmdId, "retrying in", delay)
+			backoff()
+			c.Resend(rid)
+		case smr.STATUS_INVALID:
+			return errors.New("Invalid command.")
+		default:
 			return errors.New("Failed to receive a response.")
 		}
 	}
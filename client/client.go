@@ -7,12 +7,13 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/vonaka/shreplic/client/base"
-	"github.com/vonaka/shreplic/curp"
-	"github.com/vonaka/shreplic/paxoi"
+	"github.com/vonaka/shreplic/master/defs"
+	"github.com/vonaka/shreplic/server/smr"
 	"github.com/vonaka/shreplic/tools/dlog"
 )
 
@@ -20,9 +21,11 @@ var (
 	clientId       = flag.String("id", "", "The id of the client. Default is RFC 4122 nodeID")
 	maddr          = flag.String("maddr", "", "Master address")
 	mport          = flag.Int("mport", 7087, "Master port")
+	clusterFile    = flag.String("cluster", "", "Cluster file listing the replicas (the master is not used)")
 	reqNum         = flag.Int("q", 1000, "Total number of requests")
 	writes         = flag.Int("w", 50, "Percentage of updates (writes)")
 	psize          = flag.Int("psize", 100, "Payload size for writes")
+	depth          = flag.Int("depth", 1, "Number of requests in flight (pipelining)")
 	noLeader       = flag.Bool("e", false, "Egalitarian (no leader)")
 	fast           = flag.Bool("f", false, "Send message directly to all replicas")
 	lread          = flag.Bool("l", false, "Execute reads at the closest (local) replica")
@@ -32,13 +35,50 @@ var (
 	myAddr         = flag.String("addr", "", "Client address (this machine)")
 	cloneNb        = flag.Int("clone", 0, "Number of clones (unique clients acting like this one)")
 	logFile        = flag.String("logf", "", "Path to the log file")
-	paxoiClient    = flag.Bool("paxoi", false, "Run Paxoi external client")
-	curpClient     = flag.Bool("curp", false, "Run CURP external client")
+	proto          = flag.String("proto", "", "Run the client of this protocol (the one of the replicas by default)")
 	args           = flag.String("args", "", "Custom arguments")
 )
 
+// number of replicas, 0 if unknown
+var replicas int
+
 func main() {
 	flag.Parse()
+	base.PipelineDepth = *depth
+
+	// the master, or the cluster file, knows the protocol run by the replicas
+	var (
+		info *defs.GetClusterInfoReply
+		err  error
+	)
+	if *clusterFile != "" {
+		base.Cluster, err = smr.ReadCluster(*clusterFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		info = &defs.GetClusterInfoReply{
+			Protocol: base.Cluster.Protocol,
+			N:        len(base.Cluster.Replicas),
+		}
+	} else {
+		info, err = base.GetClusterInfo(*maddr, *mport, nil)
+	}
+	switch {
+	case err != nil:
+		log.Println("Cannot get the cluster information:", err)
+		info = &defs.GetClusterInfoReply{}
+	case *proto == "":
+		*proto = info.Protocol
+	case info.Protocol != "" && info.Protocol != *proto:
+		log.Fatalf("The replicas run %s, not %s", info.Protocol, *proto)
+	}
+	if *proto != "" {
+		if _, exists := smr.GetProtocol(*proto); !exists {
+			log.Fatalf("unknown protocol %s (protocols: %s)",
+				*proto, strings.Join(smr.Protocols(), ", "))
+		}
+	}
+	replicas = info.N
 
 	var wg sync.WaitGroup
 	for i := 0; i < *cloneNb+1; i++ {
@@ -58,19 +98,24 @@ func runSimpleClient(i int) {
 	} else {
 		l = newLogger(*logFile + strconv.Itoa(i))
 	}
-	if *paxoiClient {
-		c := paxoi.NewClient(*maddr, *collocatedWith, *mport, *reqNum, *writes,
-			*psize, *conflicts, *fast, *lread, *noLeader, *verbose, l, *args)
-		if c == nil {
-			return
-		}
-		err := c.Run()
-		if err != nil {
-			fmt.Println(err)
-		}
-	} else if *curpClient {
-		c := curp.NewClient(*maddr, *collocatedWith, *mport, *reqNum, *writes,
-			*psize, *conflicts, *fast, *lread, *noLeader, *verbose, l, *args)
+	p, _ := smr.GetProtocol(*proto)
+	if p != nil && p.NewClient != nil {
+		c := p.NewClient(&smr.ClientOptions{
+			MAddr:      *maddr,
+			Collocated: *collocatedWith,
+			MPort:      *mport,
+			Requests:   *reqNum,
+			Writes:     *writes,
+			PSize:      *psize,
+			Conflicts:  *conflicts,
+			Fast:       *fast,
+			LRead:      *lread,
+			Leaderless: *noLeader,
+			Verbose:    *verbose,
+			Logger:     l,
+			Args:       *args,
+			N:          replicas,
+		})
 		if c == nil {
 			return
 		}
@@ -16,28 +16,35 @@ import (
 type Client struct {
 	*base.SimpleClient
 
-	acks  *smr.MsgSet
-	macks *smr.MsgSet
+	// the acks of the commands in flight,
+	// more than one if the client is pipelined
+	cmds map[int32]*cmdAcks
 
 	N         int
 	t         *Timer
-	Q         smr.ThreeQuarters
-	M         smr.Majority
+	Q         smr.QuorumI
+	M         smr.QuorumI
 	cs        CommunicationSupply
 	num       int
-	val       state.Value
 	ready     chan struct{}
 	leader    int32
 	ballot    int32
 	waitTime  time.Duration
 	delivered map[int32]struct{}
 
+	// the oldest command that is not delivered
 	lastCmdId CommandId
 
 	slowPaths   int
 	alreadySlow map[CommandId]struct{}
 }
 
+type cmdAcks struct {
+	acks  *smr.MsgSet
+	macks *smr.MsgSet
+	val   state.Value
+}
+
 var (
 	m         sync.Mutex
 	clientNum int
@@ -50,6 +57,7 @@ func NewClient(maddr, collocated string, mport, reqNum, writes, psize, conflict
 	f := flag.NewFlagSet("custom CURP arguments", flag.ExitOnError)
 	repNum := f.Int("N", -1, "Number of replicas")
 	pclients := f.Int("pclients", -1, "Number of clients already running on other machines")
+	qfile := f.String("qfile", "", "Quorum file of the replicas (only with a cluster file)")
 
 	f.Parse(strings.Fields(args))
 	if *repNum == -1 {
@@ -57,6 +65,14 @@ func NewClient(maddr, collocated string, mport, reqNum, writes, psize, conflict
 		return nil
 	}
 
+	// the ORDERED acks of a quorum of the replicas (see Replica.Q)
+	// mean that the command is accepted
+	q, err := base.GetQuorum(maddr, mport, *qfile, logger)
+	if err != nil {
+		log.Println("Cannot get the quorums of the replicas:", err)
+		return nil
+	}
+
 	m.Lock()
 	num := clientNum
 	clientNum++
@@ -70,8 +86,8 @@ func NewClient(maddr, collocated string, mport, reqNum, writes, psize, conflict
 		t:         NewTimer(),
 		Q:         smr.NewThreeQuartersOf(*repNum),
 		M:         smr.NewMajorityOf(*repNum),
+		cmds:      make(map[int32]*cmdAcks),
 		num:       num,
-		val:       nil,
 		ready:     make(chan struct{}, 1),
 		leader:    -1,
 		ballot:    -1,
@@ -81,12 +97,18 @@ func NewClient(maddr, collocated string, mport, reqNum, writes, psize, conflict
 		alreadySlow: make(map[CommandId]struct{}),
 	}
 
+	if q != nil {
+		c.M = q
+		c.Q = smr.FastQuorumOf(q, *repNum)
+	}
+
 	c.lastCmdId = CommandId{
 		ClientId: c.ClientId,
 		SeqNum:   0,
 	}
 
 	c.ReadTable = true
+	c.Protocol = "curp"
 	// Do not generate new key for each new request for fair (?) comparison
 	if *pclients != -1 {
 		i := 0
@@ -97,31 +119,45 @@ func NewClient(maddr, collocated string, mport, reqNum, writes, psize, conflict
 		}
 	}
 
-	first := true
 	c.WaitResponse = func() error {
-		if first {
-			sort.Float64Slice(c.Ping).Sort()
-			waitTime := time.Duration(c.Ping[c.Q.Size()-1]*2.05+25) * time.Millisecond
-			if waitTime < 100*time.Millisecond {
-				waitTime = 100 * time.Millisecond
-			}
-			c.waitTime = waitTime
-			c.t.Start(waitTime)
-			first = false
-		}
 		<-c.ready
 		return nil
 	}
 
 	initCs(&c.cs, c.RPC)
-	c.reinitAcks()
 
 	go c.handleMsgs()
 
 	return c
 }
 
-func (c *Client) reinitAcks() {
+// startTimer starts, once the leader is known, the timer after which
+// the client asks the leader to sync the commands that are not delivered.
+func (c *Client) startTimer() {
+	if c.waitTime != 0 {
+		return
+	}
+	sort.Float64Slice(c.Ping).Sort()
+	waitTime := time.Duration(c.Ping[c.Q.Size()-1]*2.05+25) * time.Millisecond
+	if waitTime < 100*time.Millisecond {
+		waitTime = 100 * time.Millisecond
+	}
+	c.waitTime = waitTime
+	c.t.Start(waitTime)
+}
+
+// getAcks returns the acks of command seq.
+func (c *Client) getAcks(seq int32) *cmdAcks {
+	a, exists := c.cmds[seq]
+	if !exists {
+		a = &cmdAcks{}
+		c.reinitAcks(a)
+		c.cmds[seq] = a
+	}
+	return a
+}
+
+func (c *Client) reinitAcks(a *cmdAcks) {
 	accept := func(msg, _ interface{}) bool {
 		ack := msg.(*MRecordAck)
 		if _, exists := c.alreadySlow[ack.CmdId]; !exists && ack.Ok == FALSE {
@@ -131,115 +167,119 @@ func (c *Client) reinitAcks() {
 		return msg.(*MRecordAck).Ok == TRUE
 	}
 
-	c.acks.Free()
-	c.acks = c.acks.ReinitMsgSet(c.Q, accept, func(interface{}) {}, c.handleAcks)
+	a.acks.Free()
+	a.acks = a.acks.ReinitMsgSet(c.Q, accept, func(interface{}) {}, c.handleAcks)
 
-	c.macks.Free()
-	c.macks = c.macks.ReinitMsgSet(c.M, func(_, _ interface{}) bool {
+	a.macks.Free()
+	a.macks = a.macks.ReinitMsgSet(c.M, func(_, _ interface{}) bool {
 		return true
 	}, func(interface{}) {}, c.handleAcks)
 }
 
+func (c *Client) newBallot(ballot int32) {
+	c.ballot = ballot
+	for _, a := range c.cmds {
+		c.reinitAcks(a)
+	}
+}
+
+// inFlight returns true if the command cmdId of the client is not
+// delivered yet.
+func (c *Client) inFlight(cmdId CommandId) bool {
+	_, delivered := c.delivered[cmdId.SeqNum]
+	return cmdId.ClientId == c.lastCmdId.ClientId &&
+		cmdId.SeqNum >= c.lastCmdId.SeqNum && !delivered
+}
+
 func (c *Client) handleMsgs() {
 	for {
 		select {
 		case m := <-c.cs.replyChan:
 			rep := m.(*MReply)
-			if rep.CmdId == c.lastCmdId {
+			c.Refresh(rep.Epoch)
+			if c.inFlight(rep.CmdId) {
 				c.handleReply(rep)
 			}
 
 		case m := <-c.cs.recordAckChan:
 			recAck := m.(*MRecordAck)
-			if recAck.CmdId == c.lastCmdId {
+			c.Refresh(recAck.Epoch)
+			if c.inFlight(recAck.CmdId) {
 				c.handleRecordAck(recAck, false)
 			}
 
 		case m := <-c.cs.syncReplyChan:
 			rep := m.(*MSyncReply)
-			if rep.CmdId == c.lastCmdId {
+			c.Refresh(rep.Epoch)
+			if c.inFlight(rep.CmdId) {
 				c.handleSyncReply(rep)
 			}
 
 		case needSync := <-c.t.c:
-			if needSync && c.leader != -1 {
-				if _, exists := c.delivered[c.lastCmdId.SeqNum]; exists {
-					return
-				}
-				sync := &MSync{
-					CmdId: c.lastCmdId,
+			if !needSync || c.leader == -1 {
+				break
+			}
+			// sync all the commands submitted so far
+			// that are not delivered
+			cmdId := c.lastCmdId
+			for ; cmdId.SeqNum <= c.LastSubmitted(); cmdId.SeqNum++ {
+				if c.inFlight(cmdId) {
+					sync := &MSync{
+						CmdId: cmdId,
+					}
+					c.SendMsg(c.leader, c.cs.syncRPC, sync)
 				}
-				c.SendMsg(c.leader, c.cs.syncRPC, sync)
 			}
 		}
 	}
 }
 
 func (c *Client) handleReply(r *MReply) {
-	if _, exists := c.delivered[r.CmdId.SeqNum]; exists {
-		return
-	}
-
 	ack := &MRecordAck{
 		Replica: r.Replica,
 		Ballot:  r.Ballot,
 		CmdId:   r.CmdId,
 		Ok:      r.Ok,
 	}
-	c.val = state.Value(r.Rep)
+	c.getAcks(r.CmdId.SeqNum).val = state.Value(r.Rep)
 	c.handleRecordAck(ack, true)
 }
 
 func (c *Client) handleRecordAck(r *MRecordAck, fromLeader bool) {
-	if _, exists := c.delivered[r.CmdId.SeqNum]; exists {
-		return
-	}
-
 	if c.ballot == -1 {
 		c.ballot = r.Ballot
 	} else if c.ballot < r.Ballot {
-		c.ballot = r.Ballot
-		c.reinitAcks()
+		c.newBallot(r.Ballot)
 	} else if c.ballot > r.Ballot {
 		return
 	}
 
+	a := c.getAcks(r.CmdId.SeqNum)
 	if fromLeader {
 		c.leader = r.Replica
-		c.macks.Add(r.Replica, true, r)
+		c.startTimer()
+		a.macks.Add(r.Replica, true, r)
 	}
 
 	if r.Ok == ORDERED {
-		c.macks.Add(r.Replica, false, r)
+		a.macks.Add(r.Replica, false, r)
 	} else {
-		c.acks.Add(r.Replica, fromLeader, r)
+		a.acks.Add(r.Replica, fromLeader, r)
 	}
 }
 
 func (c *Client) handleSyncReply(rep *MSyncReply) {
-	if _, exists := c.delivered[rep.CmdId.SeqNum]; exists {
-		return
-	}
-
 	if c.ballot == -1 {
 		c.ballot = rep.Ballot
 	} else if c.ballot < rep.Ballot {
-		c.ballot = rep.Ballot
-		c.reinitAcks()
+		c.newBallot(rep.Ballot)
 	} else if c.ballot > rep.Ballot {
 		return
 	}
 	c.leader = rep.Replica
+	c.startTimer()
 
-	c.val = state.Value(rep.Rep)
-	c.delivered[rep.CmdId.SeqNum] = struct{}{}
-	c.lastCmdId.SeqNum++
-	c.Println("Slow Paths:", c.slowPaths)
-	c.Println("Returning:", c.val.String())
-	c.ResChan <- c.val
-	c.ready <- struct{}{}
-	c.reinitAcks()
-	c.t.Reset(c.waitTime)
+	c.deliver(rep.CmdId.SeqNum, state.Value(rep.Rep))
 }
 
 func (c *Client) handleAcks(leaderMsg interface{}, msgs []interface{}) {
@@ -247,16 +287,31 @@ func (c *Client) handleAcks(leaderMsg interface{}, msgs []interface{}) {
 		return
 	}
 
-	if _, exists := c.delivered[leaderMsg.(*MRecordAck).CmdId.SeqNum]; exists {
+	seq := leaderMsg.(*MRecordAck).CmdId.SeqNum
+	if _, exists := c.delivered[seq]; exists {
 		return
 	}
+	c.deliver(seq, c.cmds[seq].val)
+}
 
-	c.delivered[leaderMsg.(*MRecordAck).CmdId.SeqNum] = struct{}{}
-	c.lastCmdId.SeqNum++
+func (c *Client) deliver(seq int32, val state.Value) {
+	c.delivered[seq] = struct{}{}
+	for {
+		if _, exists := c.delivered[c.lastCmdId.SeqNum]; !exists {
+			break
+		}
+		c.lastCmdId.SeqNum++
+	}
+	if a, exists := c.cmds[seq]; exists {
+		a.acks.Free()
+		a.macks.Free()
+		delete(c.cmds, seq)
+	}
 	c.Println("Slow Paths:", c.slowPaths)
-	c.Println("Returning:", c.val.String())
-	c.ResChan <- c.val
-	c.ready <- struct{}{}
-	c.reinitAcks()
+	c.Println("Returning:", val.String())
+	if !c.Deliver(seq, val) {
+		c.ResChan <- val
+		c.ready <- struct{}{}
+	}
 	c.t.Reset(c.waitTime)
 }
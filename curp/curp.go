@@ -23,7 +23,7 @@ type Replica struct {
 
 	optimized bool
 
-	Q smr.Majority
+	Q smr.QuorumI
 
 	isLeader    bool
 	lastCmdSlot int
@@ -117,6 +117,11 @@ func NewReplica(rid int, addrs []string, exec, dr bool,
 		},
 	}
 
+	r.Protocol = "curp"
+	if opt {
+		// optimized and regular replicas cannot be mixed
+		r.AddToConfig([]byte("optimized"))
+	}
 	r.Q = smr.NewMajorityOf(r.N)
 	r.sender = smr.NewSender(r.Replica)
 	r.batcher = NewBatcher(r, 16)
@@ -126,11 +131,18 @@ func NewReplica(rid int, addrs []string, exec, dr bool,
 		r.ballot = leaderIds[0]
 		r.cballot = leaderIds[0]
 		r.isLeader = (leaderIds[0] == r.Id)
-	} else if err == smr.NO_QUORUM_FILE {
+	} else if err == nil || err == smr.NO_QUORUM_FILE {
 		r.isLeader = (r.ballot == r.Id)
 	} else {
 		log.Fatal(err)
 	}
+	if q, err := smr.QuorumFromFile(qfile, r.Replica); err != nil &&
+		err != smr.NO_QUORUM_FILE {
+		log.Fatal(err)
+	} else if q != nil {
+		r.Q = q
+		r.SetQuorum(q)
+	}
 
 	initCs(&r.cs, r.RPC)
 
@@ -151,6 +163,11 @@ func NewReplica(rid int, addrs []string, exec, dr bool,
 	return r
 }
 
+func (r *Replica) GetLeader(_ *smr.GetLeaderArgs, reply *smr.GetLeaderReply) error {
+	reply.Leader = smr.Leader(r.ballot, r.N)
+	return nil
+}
+
 func (r *Replica) run() {
 	r.ConnectToPeers()
 	latencies := r.ComputeClosestPeers()
@@ -188,6 +205,7 @@ func (r *Replica) run() {
 					Ballot:  r.ballot,
 					CmdId:   cmdId,
 					Ok:      r.ok(propose.Command),
+					Epoch:   r.Epoch(),
 				}
 				r.sender.SendToClient(propose.ClientId, recAck, r.cs.recordAckRPC)
 				r.unsync(propose.Command)
@@ -231,6 +249,7 @@ func (r *Replica) run() {
 					Ballot:  r.ballot,
 					CmdId:   sync.CmdId,
 					Rep:     val.([]byte),
+					Epoch:   r.Epoch(),
 				}
 				r.sender.SendToClient(sync.CmdId.ClientId, rep, r.cs.syncReplyRPC)
 			}
@@ -301,6 +320,7 @@ func (r *Replica) handleAccept(msg *MAccept, desc *commandDesc) {
 				Ballot:  r.ballot,
 				CmdId:   desc.cmdId,
 				Ok:      ORDERED,
+				Epoch:   r.Epoch(),
 			}
 			r.sender.SendToClient(propose.ClientId, recAck, r.cs.recordAckRPC)
 		}
@@ -457,6 +477,7 @@ func (r *Replica) deliver(desc *commandDesc, slot int) {
 					Ballot:  r.ballot,
 					CmdId:   desc.cmdId,
 					Rep:     desc.val,
+					Epoch:   r.Epoch(),
 				}
 				r.sender.SendToClient(desc.propose.ClientId, rep, r.cs.syncReplyRPC)
 			} else {
@@ -465,6 +486,7 @@ func (r *Replica) deliver(desc *commandDesc, slot int) {
 					Ballot:  r.ballot,
 					CmdId:   desc.cmdId,
 					Rep:     desc.val,
+					Epoch:   r.Epoch(),
 				}
 				if desc.dep != -1 && !r.committed.Has(strconv.Itoa(desc.dep)) {
 					rep.Ok = FALSE
@@ -49,6 +49,7 @@ type MReply struct {
 	CmdId   CommandId
 	Rep     []byte
 	Ok      uint8
+	Epoch   int32
 }
 
 type MAccept struct {
@@ -75,6 +76,7 @@ type MRecordAck struct {
 	Ballot  int32
 	CmdId   CommandId
 	Ok      uint8
+	Epoch   int32
 }
 
 type MCommit struct {
@@ -92,6 +94,7 @@ type MSyncReply struct {
 	Ballot  int32
 	CmdId   CommandId
 	Rep     []byte
+	Epoch   int32
 }
 
 func (m *MReply) New() fastrpc.Serializable {
@@ -126,6 +129,20 @@ func (m *MSyncReply) New() fastrpc.Serializable {
 	return new(MSyncReply)
 }
 
+// the answers to the clients (see smr.Answer)
+
+func (m *MReply) AnsweredCommand() int32 {
+	return m.CmdId.SeqNum
+}
+
+func (m *MRecordAck) AnsweredCommand() int32 {
+	return m.CmdId.SeqNum
+}
+
+func (m *MSyncReply) AnsweredCommand() int32 {
+	return m.CmdId.SeqNum
+}
+
 type CommunicationSupply struct {
 	maxLatency time.Duration
 
@@ -315,6 +332,13 @@ func (t *MSyncReply) Marshal(wire io.Writer) {
 		bs[0] = byte(t.Rep[i])
 		wire.Write(bs)
 	}
+	bs = b[:4]
+	tmp32 = t.Epoch
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
 }
 
 func (t *MSyncReply) Unmarshal(rr io.Reader) error {
@@ -345,6 +369,11 @@ func (t *MSyncReply) Unmarshal(rr io.Reader) error {
 		}
 		t.Rep[i] = byte(bs[0])
 	}
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.Epoch = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	return nil
 }
 
@@ -508,7 +537,13 @@ func (t *MReply) Marshal(wire io.Writer) {
 		bs[0] = byte(t.Rep[i])
 		wire.Write(bs)
 	}
+	bs = b[:5]
 	bs[0] = byte(t.Ok)
+	tmp32 = t.Epoch
+	bs[1] = byte(tmp32)
+	bs[2] = byte(tmp32 >> 8)
+	bs[3] = byte(tmp32 >> 16)
+	bs[4] = byte(tmp32 >> 24)
 	wire.Write(bs)
 }
 
@@ -540,10 +575,12 @@ func (t *MReply) Unmarshal(rr io.Reader) error {
 		}
 		t.Rep[i] = byte(bs[0])
 	}
-	if _, err := io.ReadAtLeast(wire, bs, 1); err != nil {
+	bs = b[:5]
+	if _, err := io.ReadAtLeast(wire, bs, 5); err != nil {
 		return err
 	}
 	t.Ok = uint8(bs[0])
+	t.Epoch = int32((uint32(bs[1]) | (uint32(bs[2]) << 8) | (uint32(bs[3]) << 16) | (uint32(bs[4]) << 24)))
 	return nil
 }
 
@@ -737,7 +774,7 @@ func (t *MAAcks) Unmarshal(rr io.Reader) error {
 }
 
 func (t *MRecordAck) BinarySize() (nbytes int, sizeKnown bool) {
-	return 17, true
+	return 21, true
 }
 
 type MRecordAckCache struct {
@@ -770,9 +807,9 @@ func (p *MRecordAckCache) Put(t *MRecordAck) {
 	p.mu.Unlock()
 }
 func (t *MRecordAck) Marshal(wire io.Writer) {
-	var b [17]byte
+	var b [21]byte
 	var bs []byte
-	bs = b[:17]
+	bs = b[:21]
 	tmp32 := t.Replica
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -794,14 +831,19 @@ func (t *MRecordAck) Marshal(wire io.Writer) {
 	bs[14] = byte(tmp32 >> 16)
 	bs[15] = byte(tmp32 >> 24)
 	bs[16] = byte(t.Ok)
+	tmp32 = t.Epoch
+	bs[17] = byte(tmp32)
+	bs[18] = byte(tmp32 >> 8)
+	bs[19] = byte(tmp32 >> 16)
+	bs[20] = byte(tmp32 >> 24)
 	wire.Write(bs)
 }
 
 func (t *MRecordAck) Unmarshal(wire io.Reader) error {
-	var b [17]byte
+	var b [21]byte
 	var bs []byte
-	bs = b[:17]
-	if _, err := io.ReadAtLeast(wire, bs, 17); err != nil {
+	bs = b[:21]
+	if _, err := io.ReadAtLeast(wire, bs, 21); err != nil {
 		return err
 	}
 	t.Replica = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
@@ -809,6 +851,7 @@ func (t *MRecordAck) Unmarshal(wire io.Reader) error {
 	t.CmdId.ClientId = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
 	t.CmdId.SeqNum = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
 	t.Ok = uint8(bs[16])
+	t.Epoch = int32((uint32(bs[17]) | (uint32(bs[18]) << 8) | (uint32(bs[19]) << 16) | (uint32(bs[20]) << 24)))
 	return nil
 }
 
@@ -0,0 +1,31 @@
+package curp
+
+import "github.com/vonaka/shreplic/server/smr"
+
+func init() {
+	for _, opt := range []bool{false, true} {
+		opt := opt
+		name := "curp"
+		if opt {
+			name = "curpOpt"
+		}
+		smr.RegisterProtocol(&smr.Protocol{
+			Name:  name,
+			Flags: []string{"qfile", "desc", "pool"},
+			NewReplica: func(o *smr.Options) interface{} {
+				MaxDescRoutines = o.Desc
+				return NewReplica(o.Id, o.Addrs, o.Exec, o.Dreply,
+					o.Pool, o.F, o.QFile, opt, o.Proxies)
+			},
+			NewClient: func(o *smr.ClientOptions) smr.ProtocolClient {
+				c := NewClient(o.MAddr, o.Collocated, o.MPort, o.Requests, o.Writes,
+					o.PSize, o.Conflicts, o.Fast, o.LRead, o.Leaderless, o.Verbose,
+					o.Logger, smr.ClientArgs(o.Args, o.N))
+				if c == nil {
+					return nil
+				}
+				return c
+			},
+		})
+	}
+}
@@ -76,6 +76,9 @@ type Replica struct {
 	batchWait             int
 	transconf             bool
 	ignoreSeq             bool
+	fastQ                 smr.QuorumI // quorum of the PreAccept phase
+	slowQ                 smr.QuorumI // quorum of the Accept and TryPreAccept phases
+	prepareQ              smr.QuorumI // quorum of the Prepare phase
 }
 
 type InstPair struct {
@@ -105,8 +108,8 @@ type LeaderBookkeeping struct {
 	clientProposals   []*smr.GPropose
 	ballot            int32
 	allEqual          bool
-	preAcceptOKs      int
-	acceptOKs         int
+	preAcceptOKs      *smr.MsgSet
+	acceptOKs         *smr.MsgSet
 	nacks             int
 	originalDeps      []int32
 	committedDeps     []int32
@@ -124,7 +127,7 @@ type LeaderBookkeeping struct {
 	leaderResponded   bool
 }
 
-func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, lread bool, dreply bool, beacon bool, durable bool, batchWait int, transconf bool, failures int, ps map[string]struct{}) *Replica {
+func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, lread bool, dreply bool, beacon bool, durable bool, batchWait int, transconf bool, failures int, qfile string, ps map[string]struct{}) *Replica {
 	r := &Replica{
 		smr.NewReplica(id, failures, peerAddrList, thrifty, exec, lread, dreply, ps),
 		make(chan fastrpc.Serializable, smr.CHAN_BUFFER_SIZE),
@@ -155,15 +158,32 @@ func NewReplica(id int, peerAddrList []string, thrifty bool, exec bool, lread bo
 		batchWait,
 		transconf,
 		true,
+		nil, nil, nil,
 	}
 
+	r.Protocol = "epaxos"
 	r.Beacon = beacon
+	// stopAdapting orders the peers once the adaptation is over
+	r.FixedPeerOrder = beacon
 	r.Durable = durable
 
 	if !thrifty {
 		panic("must run with thriftiness on")
 	}
 
+	r.fastQ = smr.Majority(r.FastQuorumSize())
+	r.slowQ = smr.NewMajorityOf(r.N)
+	r.prepareQ = smr.Majority(r.SlowQuorumSize())
+	if q, err := smr.QuorumFromFile(qfile, r.Replica); err != nil &&
+		err != smr.NO_QUORUM_FILE {
+		log.Fatal(err)
+	} else if q != nil {
+		r.fastQ = smr.FastQuorumOf(q, r.N)
+		r.slowQ = q
+		r.prepareQ = q
+		r.SetQuorum(q)
+	}
+
 	for i := 0; i < r.N; i++ {
 		r.InstanceSpace[i] = make([]*Instance, MAX_INSTANCE) // FIXME
 		r.crtInstance[i] = -1
@@ -259,19 +279,23 @@ func (r *Replica) stopAdapting() {
 	r.Beacon = false
 	time.Sleep(1000 * 1000 * 1000)
 
+	order := append([]int32(nil), r.PeerOrder()...)
+	r.M.Lock()
 	for i := 0; i < r.N-1; i++ {
 		min := i
 		for j := i + 1; j < r.N-1; j++ {
-			if r.Ewma[r.PreferredPeerOrder[j]] < r.Ewma[r.PreferredPeerOrder[min]] {
+			if r.Ewma[order[j]] < r.Ewma[order[min]] {
 				min = j
 			}
 		}
-		aux := r.PreferredPeerOrder[i]
-		r.PreferredPeerOrder[i] = r.PreferredPeerOrder[min]
-		r.PreferredPeerOrder[min] = aux
+		aux := order[i]
+		order[i] = order[min]
+		order[min] = aux
 	}
+	r.M.Unlock()
+	r.UpdatePreferredPeerOrder(order)
 
-	log.Println(r.PreferredPeerOrder)
+	log.Println(order)
 }
 
 func (r *Replica) BatchingEnabled() bool {
@@ -307,6 +331,7 @@ func (r *Replica) run() {
 	}
 
 	onOffProposeChan := r.ProposeChan
+	failures := r.SubscribeFailures()
 
 	go r.WaitForClientConnections()
 
@@ -409,6 +434,15 @@ func (r *Replica) run() {
 
 		case iid := <-r.instancesToRecover:
 			r.startRecoveryForInstance(iid.replica, iid.instance)
+
+		case e := <-failures:
+			// only one replica recovers the instances of e.Peer
+			if e.Suspected && r.Successor(e.Peer) == r.Id {
+				log.Printf("Replica %d is suspected (phi %.1f)\n", e.Peer, e.Phi)
+				for k := r.CommittedUpTo[e.Peer] + 1; k <= r.crtInstance[e.Peer]; k++ {
+					r.startRecoveryForInstance(e.Peer, k)
+				}
+			}
 		}
 	}
 }
@@ -556,25 +590,33 @@ func (r *Replica) bcastPreAccept(replica int32, instance int32) {
 	pa.Seq = lb.seq
 	pa.Deps = lb.deps
 
-	n := r.N - 1
-	if r.Thrifty {
-		n = r.Replica.FastQuorumSize() - 1
-	}
-
-	sent := 0
+	lb.preAcceptOKs = r.acks(lb.preAcceptOKs, r.fastQ)
+	// thrifty replicas send to the closest replicas that form a quorum
+	ids := []int32{r.Id}
+	order := r.PeerOrder()
 	for q := 0; q < r.N-1; q++ {
-		if !r.Alive[r.PreferredPeerOrder[q]] {
+		if !r.Alive[order[q]] {
 			continue
 		}
 		dlog.Printf("Sending PreAccept %d.%d w. ballot %d and deps %d to %d \n", replica, instance, lb.lastTriedBallot, lb.deps, q)
-		r.SendMsg(r.PreferredPeerOrder[q], r.preAcceptRPC, pa)
-		sent++
-		if sent >= n {
+		r.SendMsg(order[q], r.preAcceptRPC, pa)
+		ids = append(ids, order[q])
+		if r.Thrifty && smr.IsQuorum(r.fastQ, ids) {
 			break
 		}
 	}
 }
 
+// acks returns ms reinitialized to collect the acks of a quorum of q,
+// the acks of this replica included.
+func (r *Replica) acks(ms *smr.MsgSet, q smr.QuorumI) *smr.MsgSet {
+	ms = ms.ReinitMsgSet(q, func(_, _ interface{}) bool {
+		return true
+	}, func(interface{}) {}, nil)
+	ms.Add(r.Id, true, r)
+	return ms
+}
+
 func (r *Replica) bcastTryPreAccept(replica int32, instance int32) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -591,6 +633,7 @@ func (r *Replica) bcastTryPreAccept(replica int32, instance int32) {
 	tpa.Seq = lb.seq
 	tpa.Deps = lb.deps
 
+	lb.preAcceptOKs = r.acks(lb.preAcceptOKs, r.slowQ)
 	for q := int32(0); q < int32(r.N); q++ {
 		if q == r.Id {
 			continue
@@ -619,20 +662,17 @@ func (r *Replica) bcastAccept(replica int32, instance int32) {
 	ea.Seq = lb.seq
 	ea.Deps = lb.deps
 
-	n := r.N - 1
-	if r.Thrifty {
-		n = r.N / 2
-	}
-
-	sent := 0
+	lb.acceptOKs = r.acks(lb.acceptOKs, r.slowQ)
+	ids := []int32{r.Id}
+	order := r.PeerOrder()
 	for q := 0; q < r.N-1; q++ {
-		if !r.Alive[r.PreferredPeerOrder[q]] {
+		if !r.Alive[order[q]] {
 			continue
 		}
 		dlog.Printf("Sending Accept %d.%d w. ballot %d to %d\n", replica, instance, lb.lastTriedBallot, q)
-		r.SendMsg(r.PreferredPeerOrder[q], r.acceptRPC, ea)
-		sent++
-		if sent >= n {
+		r.SendMsg(order[q], r.acceptRPC, ea)
+		ids = append(ids, order[q])
+		if r.Thrifty && smr.IsQuorum(r.slowQ, ids) {
 			break
 		}
 	}
@@ -654,12 +694,13 @@ func (r *Replica) bcastCommit(replica int32, instance int32) {
 	ec.Deps = lb.deps
 	ec.Ballot = lb.ballot
 
+	order := r.PeerOrder()
 	for q := 0; q < r.N-1; q++ {
-		if !r.Alive[r.PreferredPeerOrder[q]] {
+		if !r.Alive[order[q]] {
 			continue
 		}
-		dlog.Printf("Sending Commit %d.%d to %d\n", replica, instance, r.PreferredPeerOrder[q])
-		r.SendMsg(r.PreferredPeerOrder[q], r.commitRPC, ec)
+		dlog.Printf("Sending Commit %d.%d to %d\n", replica, instance, order[q])
+		r.SendMsg(order[q], r.commitRPC, ec)
 	}
 }
 
@@ -907,6 +948,7 @@ func (r *Replica) handlePreAccept(preAccept *PreAccept) {
 	}
 
 	reply := &PreAcceptReply{
+		r.Id,
 		preAccept.Replica,
 		preAccept.Instance,
 		inst.bal,
@@ -949,7 +991,7 @@ func (r *Replica) handlePreAcceptReply(pareply *PreAcceptReply) {
 		return
 	}
 
-	inst.lb.preAcceptOKs++
+	inst.lb.preAcceptOKs.Add(pareply.AcceptorId, false, pareply)
 
 	if pareply.VBallot > lb.ballot {
 		lb.ballot = pareply.VBallot
@@ -997,7 +1039,7 @@ func (r *Replica) handlePreAcceptReply(pareply *PreAcceptReply) {
 
 	precondition := inst.lb.allEqual && allCommitted && isInitialBallot
 
-	if inst.lb.preAcceptOKs >= (r.Replica.FastQuorumSize()-1) && precondition {
+	if inst.lb.preAcceptOKs.Complete() && precondition {
 		dlog.Printf("Fast path %d.%d, w. deps %d\n", pareply.Replica, pareply.Instance, pareply.Deps)
 		lb.status = COMMITTED
 
@@ -1015,10 +1057,11 @@ func (r *Replica) handlePreAcceptReply(pareply *PreAcceptReply) {
 			for i := 0; i < len(inst.lb.clientProposals); i++ {
 				r.ReplyProposeTS(
 					&smr.ProposeReplyTS{
-						TRUE,
-						inst.lb.clientProposals[i].CommandId,
-						state.NIL(),
-						inst.lb.clientProposals[i].Timestamp},
+						OK:        TRUE,
+						CommandId: inst.lb.clientProposals[i].CommandId,
+						Value:     state.NIL(),
+						Timestamp: inst.lb.clientProposals[i].Timestamp,
+					},
 					inst.lb.clientProposals[i].Reply,
 					inst.lb.clientProposals[i].Mutex)
 			}
@@ -1032,7 +1075,7 @@ func (r *Replica) handlePreAcceptReply(pareply *PreAcceptReply) {
 			r.Stats.M["totalCommitTime"] += int(time.Now().UnixNano() - inst.proposeTime)
 		}
 		r.M.Unlock()
-	} else if inst.lb.preAcceptOKs >= r.Replica.FastQuorumSize()-1 {
+	} else if inst.lb.preAcceptOKs.Complete() {
 		// } else if inst.lb.preAcceptOKs >= r.N/2 && !precondition {
 		dlog.Printf("Slow path %d.%d (inst.lb.allEqual=%t, allCommitted=%t, isInitialBallot=%t)\n", pareply.Replica, pareply.Instance, allEqual, allCommitted, isInitialBallot)
 		lb.status = ACCEPTED
@@ -1054,7 +1097,7 @@ func (r *Replica) handlePreAcceptReply(pareply *PreAcceptReply) {
 		}
 		r.M.Unlock()
 	} else {
-		dlog.Printf("Not enough pre-accept replies in %d.%d (preAcceptOk=%d, slowQuorumSize=%d, precondition=%t)\n", pareply.Replica, pareply.Instance, lb.preAcceptOKs, r.Replica.SlowQuorumSize(), precondition)
+		dlog.Printf("Not enough pre-accept replies in %d.%d (fastQuorum=%v, precondition=%t)\n", pareply.Replica, pareply.Instance, r.fastQ, precondition)
 	}
 }
 
@@ -1094,7 +1137,7 @@ func (r *Replica) handleAccept(accept *Accept) {
 		r.sync()
 	}
 
-	reply := &AcceptReply{accept.Replica, accept.Instance, inst.bal}
+	reply := &AcceptReply{r.Id, accept.Replica, accept.Instance, inst.bal}
 	r.replyAccept(accept.LeaderId, reply)
 
 }
@@ -1131,9 +1174,9 @@ func (r *Replica) handleAcceptReply(areply *AcceptReply) {
 		return
 	}
 
-	inst.lb.acceptOKs++
+	inst.lb.acceptOKs.Add(areply.AcceptorId, false, areply)
 
-	if inst.lb.acceptOKs+1 > r.N/2 {
+	if inst.lb.acceptOKs.Complete() {
 		lb.status = COMMITTED
 		inst.Status = COMMITTED
 		r.updateCommitted(areply.Replica)
@@ -1145,10 +1188,11 @@ func (r *Replica) handleAcceptReply(areply *AcceptReply) {
 			for i := 0; i < len(inst.lb.clientProposals); i++ {
 				r.ReplyProposeTS(
 					&smr.ProposeReplyTS{
-						TRUE,
-						inst.lb.clientProposals[i].CommandId,
-						state.NIL(),
-						inst.lb.clientProposals[i].Timestamp},
+						OK:        TRUE,
+						CommandId: inst.lb.clientProposals[i].CommandId,
+						Value:     state.NIL(),
+						Timestamp: inst.lb.clientProposals[i].Timestamp,
+					},
 					inst.lb.clientProposals[i].Reply,
 					inst.lb.clientProposals[i].Mutex)
 			}
@@ -1334,7 +1378,11 @@ func (r *Replica) handlePrepareReply(preply *PrepareReply) {
 	}
 
 	lb.prepareReplies = append(lb.prepareReplies, preply)
-	if len(lb.prepareReplies) < r.Replica.SlowQuorumSize() {
+	ids := make([]int32, len(lb.prepareReplies))
+	for i, preply := range lb.prepareReplies {
+		ids[i] = preply.AcceptorId
+	}
+	if !smr.IsQuorum(r.prepareQ, ids) {
 		dlog.Println("Not enough")
 		return
 	}
@@ -1544,12 +1592,11 @@ func (r *Replica) handleTryPreAcceptReply(tpar *TryPreAcceptReply) {
 	lb.tpaReps++
 
 	if tpar.VBallot == lb.lastTriedBallot {
-		lb.preAcceptOKs++
-		if lb.preAcceptOKs >= r.N/2 {
+		lb.preAcceptOKs.Add(tpar.AcceptorId, false, tpar)
+		if lb.preAcceptOKs.Complete() {
 			//it's safe to start Accept phase
 			lb.status = ACCEPTED
 			lb.tryingToPreAccept = false
-			lb.acceptOKs = 0
 
 			inst.Cmds = lb.cmds
 			inst.Seq = lb.seq
@@ -1642,7 +1689,7 @@ func (r *Replica) newLeaderBookkeepingDefault() *LeaderBookkeeping {
 }
 
 func (r *Replica) newLeaderBookkeeping(p []*smr.GPropose, originalDeps []int32, committedDeps []int32, deps []int32, lastTriedBallot int32, cmds []state.Command, status int8, seq int32) *LeaderBookkeeping {
-	return &LeaderBookkeeping{p, -1, true, 0, 0, 0, originalDeps, committedDeps, nil, true, false, make([]bool, r.N), 0, false, lastTriedBallot, cmds, status, seq, deps, false}
+	return &LeaderBookkeeping{p, -1, true, nil, nil, 0, originalDeps, committedDeps, nil, true, false, make([]bool, r.N), 0, false, lastTriedBallot, cmds, status, seq, deps, false}
 }
 
 func (r *Replica) newNilDeps() []int32 {
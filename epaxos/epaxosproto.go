@@ -40,6 +40,7 @@ type PreAccept struct {
 }
 
 type PreAcceptReply struct {
+	AcceptorId    int32
 	Replica       int32
 	Instance      int32
 	Ballot        int32
@@ -64,9 +65,10 @@ type Accept struct {
 }
 
 type AcceptReply struct {
-	Replica  int32
-	Instance int32
-	Ballot   int32
+	AcceptorId int32
+	Replica    int32
+	Instance   int32
+	Ballot     int32
 }
 
 type Commit struct {
@@ -504,7 +506,7 @@ func (t *AcceptReply) New() fastrpc.Serializable {
 	return new(AcceptReply)
 }
 func (t *AcceptReply) BinarySize() (nbytes int, sizeKnown bool) {
-	return 13, true
+	return 16, true
 }
 
 type AcceptReplyCache struct {
@@ -537,37 +539,43 @@ func (p *AcceptReplyCache) Put(t *AcceptReply) {
 	p.mu.Unlock()
 }
 func (t *AcceptReply) Marshal(wire io.Writer) {
-	var b [12]byte
+	var b [16]byte
 	var bs []byte
-	bs = b[:12]
-	tmp32 := t.Replica
+	bs = b[:16]
+	tmp32 := t.AcceptorId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
-	tmp32 = t.Instance
+	tmp32 = t.Replica
 	bs[4] = byte(tmp32)
 	bs[5] = byte(tmp32 >> 8)
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
+	tmp32 = t.Instance
 	bs[8] = byte(tmp32)
 	bs[9] = byte(tmp32 >> 8)
 	bs[10] = byte(tmp32 >> 16)
 	bs[11] = byte(tmp32 >> 24)
+	tmp32 = t.Ballot
+	bs[12] = byte(tmp32)
+	bs[13] = byte(tmp32 >> 8)
+	bs[14] = byte(tmp32 >> 16)
+	bs[15] = byte(tmp32 >> 24)
 	wire.Write(bs)
 }
 
 func (t *AcceptReply) Unmarshal(wire io.Reader) error {
-	var b [12]byte
+	var b [16]byte
 	var bs []byte
-	bs = b[:12]
-	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+	bs = b[:16]
+	if _, err := io.ReadAtLeast(wire, bs, 16); err != nil {
 		return err
 	}
-	t.Replica = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
-	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Ballot = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
+	t.AcceptorId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Replica = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
+	t.Ballot = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
 	return nil
 }
 
@@ -820,35 +828,40 @@ func (p *PreAcceptReplyCache) Put(t *PreAcceptReply) {
 	p.mu.Unlock()
 }
 func (t *PreAcceptReply) Marshal(wire io.Writer) {
-	var b [21]byte
+	var b [25]byte
 	var bs []byte
-	bs = b[:21]
-	tmp32 := t.Replica
+	bs = b[:25]
+	tmp32 := t.AcceptorId
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
 	bs[2] = byte(tmp32 >> 16)
 	bs[3] = byte(tmp32 >> 24)
-	tmp32 = t.Instance
+	tmp32 = t.Replica
 	bs[4] = byte(tmp32)
 	bs[5] = byte(tmp32 >> 8)
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
-	tmp32 = t.Ballot
+	tmp32 = t.Instance
 	bs[8] = byte(tmp32)
 	bs[9] = byte(tmp32 >> 8)
 	bs[10] = byte(tmp32 >> 16)
 	bs[11] = byte(tmp32 >> 24)
-	tmp32 = t.VBallot
+	tmp32 = t.Ballot
 	bs[12] = byte(tmp32)
 	bs[13] = byte(tmp32 >> 8)
 	bs[14] = byte(tmp32 >> 16)
 	bs[15] = byte(tmp32 >> 24)
-	tmp32 = t.Seq
+	tmp32 = t.VBallot
 	bs[16] = byte(tmp32)
 	bs[17] = byte(tmp32 >> 8)
 	bs[18] = byte(tmp32 >> 16)
 	bs[19] = byte(tmp32 >> 24)
-	bs[20] = byte(t.Status)
+	tmp32 = t.Seq
+	bs[20] = byte(tmp32)
+	bs[21] = byte(tmp32 >> 8)
+	bs[22] = byte(tmp32 >> 16)
+	bs[23] = byte(tmp32 >> 24)
+	bs[24] = byte(t.Status)
 	wire.Write(bs)
 	bs = b[:]
 	alen1 := int64(len(t.Deps))
@@ -886,18 +899,19 @@ func (t *PreAcceptReply) Unmarshal(rr io.Reader) error {
 	if wire, ok = rr.(byteReader); !ok {
 		wire = bufio.NewReader(rr)
 	}
-	var b [21]byte
+	var b [25]byte
 	var bs []byte
-	bs = b[:21]
-	if _, err := io.ReadAtLeast(wire, bs, 21); err != nil {
+	bs = b[:25]
+	if _, err := io.ReadAtLeast(wire, bs, 25); err != nil {
 		return err
 	}
-	t.Replica = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
-	t.Instance = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
-	t.Ballot = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
-	t.VBallot = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
-	t.Seq = int32((uint32(bs[16]) | (uint32(bs[17]) << 8) | (uint32(bs[18]) << 16) | (uint32(bs[19]) << 24)))
-	t.Status = int8(bs[20])
+	t.AcceptorId = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Replica = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	t.Instance = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
+	t.Ballot = int32((uint32(bs[12]) | (uint32(bs[13]) << 8) | (uint32(bs[14]) << 16) | (uint32(bs[15]) << 24)))
+	t.VBallot = int32((uint32(bs[16]) | (uint32(bs[17]) << 8) | (uint32(bs[18]) << 16) | (uint32(bs[19]) << 24)))
+	t.Seq = int32((uint32(bs[20]) | (uint32(bs[21]) << 8) | (uint32(bs[22]) << 16) | (uint32(bs[23]) << 24)))
+	t.Status = int8(bs[24])
 	alen1, err := binary.ReadVarint(wire)
 	if err != nil {
 		return err
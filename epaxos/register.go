@@ -0,0 +1,15 @@
+package epaxos
+
+import "github.com/vonaka/shreplic/server/smr"
+
+func init() {
+	smr.RegisterProtocol(&smr.Protocol{
+		Name: "epaxos",
+		Flags: []string{"thrifty", "lread", "beacon", "durable", "batchwait",
+			"tconf", "qfile", "fd", "heartbeat", "phi"},
+		NewReplica: func(o *smr.Options) interface{} {
+			return NewReplica(o.Id, o.Addrs, o.Thrifty, o.Exec, o.LRead, o.Dreply,
+				o.Beacon, o.Durable, o.BatchWait, o.TConf, o.F, o.QFile, o.Proxies)
+		},
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vonaka/shreplic/master/defs"
+	"github.com/vonaka/shreplic/server/smr"
+)
+
+// Administration RPCs, used by shr-admin.
+
+var TransferTimeout = 2 * time.Second
+
+// callReplica calls method on replica i if it is alive.
+func (master *Master) callReplica(i int, method string, args, reply interface{}) error {
+	master.lock.Lock()
+	if i < 0 || i >= master.N || !master.finishInit {
+		master.lock.Unlock()
+		return fmt.Errorf("no replica %d", i)
+	}
+	node, alive := master.nodes[i], master.alive[i]
+	master.lock.Unlock()
+
+	if !alive || node == nil {
+		return fmt.Errorf("replica %d is dead", i)
+	}
+	return node.Call("Replica."+method, args, reply)
+}
+
+// TransferLeader makes args.Replica the leader, and then the other
+// replicas step down. It fails with smr.ErrNoTransfer if the protocol
+// cannot change its leader.
+func (master *Master) TransferLeader(args *defs.TransferLeaderArgs, reply *defs.TransferLeaderReply) error {
+	if !master.group.isPrimary() {
+		return defs.ErrNotPrimary
+	}
+
+	btlReply := smr.NewBeTheLeaderReply()
+	err := master.callReplica(args.Replica, "BeTheLeader", new(smr.BeTheLeaderArgs), btlReply)
+	if err != nil {
+		return err
+	}
+	smr.UpdateBeTheLeaderReply(btlReply)
+
+	// the new leader may first need to start a ballot
+	leader := int32(-1)
+	for start := time.Now(); time.Since(start) < TransferTimeout; {
+		glReply := &smr.GetLeaderReply{}
+		err := master.callReplica(args.Replica, "GetLeader", new(smr.GetLeaderArgs), glReply)
+		if err != nil {
+			return err
+		}
+		leader = glReply.Leader
+		if leader == -1 || leader == int32(args.Replica) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if leader != -1 && leader != int32(args.Replica) {
+		return fmt.Errorf("replica %d cannot become the leader (the leader is %d)",
+			args.Replica, leader)
+	}
+
+	master.lock.Lock()
+	old := []int{}
+	for i, l := range master.leader {
+		if l && i != args.Replica {
+			old = append(old, i)
+		}
+		master.leader[i] = i == args.Replica
+	}
+	master.lock.Unlock()
+	for _, i := range old {
+		err := master.callReplica(i, "StepDown", new(smr.StepDownArgs), new(smr.StepDownReply))
+		if err != nil {
+			log.Printf("Replica %d cannot step down: %v", i, err)
+		}
+	}
+
+	master.lock.Lock()
+	master.nextLeader = int(btlReply.NextLeader)
+	log.Printf("Replica %d is the new leader", args.Replica)
+	master.chose("transfer", []int{args.Replica}, args.Replica)
+	version := master.replicate()
+	master.lock.Unlock()
+	return master.synced(version)
+}
+
+// InstallQuorums installs new active quorums on every alive replica.
+func (master *Master) InstallQuorums(args *defs.InstallQuorumsArgs, reply *defs.InstallQuorumsReply) error {
+	if !master.group.isPrimary() {
+		return defs.ErrNotPrimary
+	}
+
+	reply.Ballot = -1
+	var err error
+	for i := 0; i < master.N; i++ {
+		iqReply := &smr.InstallQuorumsReply{}
+		e := master.callReplica(i, "InstallQuorums", &smr.InstallQuorumsArgs{
+			Content: args.Content,
+		}, iqReply)
+		if defs.IsError(e, smr.ErrFixedQuorums) {
+			// the other replicas run the same protocol
+			return e
+		}
+		if e != nil {
+			log.Printf("Replica %d cannot install the quorums: %v", i, e)
+			if err == nil {
+				err = fmt.Errorf("replica %d: %v", i, e)
+			}
+			continue
+		}
+		reply.Leader = iqReply.Leader
+		if iqReply.Ballot != -1 {
+			reply.Ballot = iqReply.Ballot
+		}
+	}
+	return err
+}
+
+func (master *Master) Snapshot(args *defs.SnapshotArgs, reply *defs.SnapshotReply) error {
+	if !master.group.isPrimary() {
+		return defs.ErrNotPrimary
+	}
+
+	reply.Files = make([]string, master.N)
+	reply.Keys = make([]int, master.N)
+	reply.Positions = make([]int32, master.N)
+	taken := false
+	for i := 0; i < master.N; i++ {
+		if args.Replica != -1 && i != args.Replica {
+			continue
+		}
+		sReply := &smr.SnapshotReply{}
+		err := master.callReplica(i, "Snapshot", new(smr.SnapshotArgs), sReply)
+		if err != nil {
+			if args.Replica != -1 {
+				return err
+			}
+			log.Printf("Replica %d cannot take a snapshot: %v", i, err)
+			continue
+		}
+		reply.Files[i] = sReply.File
+		reply.Keys[i] = sReply.Keys
+		reply.Positions[i] = sReply.Position
+		taken = true
+	}
+	if !taken {
+		return errors.New("no snapshot was taken")
+	}
+	return nil
+}
+
+func (master *Master) GetStats(args *defs.GetStatsArgs, reply *defs.GetStatsReply) error {
+	if !master.group.isPrimary() {
+		return defs.ErrNotPrimary
+	}
+
+	sReply := &smr.StatsReply{}
+	err := master.callReplica(args.Replica, "GetStats", new(smr.StatsArgs), sReply)
+	reply.Stats = sReply.Stats
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+// dashboard polls /api/status and /api/metrics every second. The
+// throughput of a replica is the number of proposals it receives
+// per second.
+const dashboard = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>shr-master</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+.dead { color: #b00; }
+.leader { font-weight: bold; }
+#error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>shr-master</h1>
+<p id="summary"></p>
+<p id="error"></p>
+<table>
+<thead><tr><th>id</th><th>address</th><th>protocol</th><th>state</th>
+<th>latency (ms)</th><th>proposals/s</th><th>rejected</th></tr></thead>
+<tbody id="replicas"></tbody>
+</table>
+<h2>Throughput</h2>
+<canvas id="throughput" width="600" height="120"></canvas>
+<h2>Leaders</h2>
+<table>
+<thead><tr><th>time</th><th>reason</th><th>leader</th><th>ranking</th></tr></thead>
+<tbody id="choices"></tbody>
+</table>
+<script>
+var last = null, history = [];
+
+function get(url) {
+	return fetch(url).then(function(r) { return r.json(); });
+}
+
+function text(s) {
+	var d = document.createElement("div");
+	d.textContent = s;
+	return d.innerHTML;
+}
+
+function rates(m) {
+	var r = {};
+	if (last) {
+		var dt = (new Date(m.time) - new Date(last.time)) / 1000;
+		m.replicas.forEach(function(x, i) {
+			var prev = last.replicas[i];
+			if (x.stats && prev && prev.stats && dt > 0) {
+				r[x.id] = Math.max(0, ((x.stats.proposals || 0) - (prev.stats.proposals || 0)) / dt);
+			}
+		});
+	}
+	last = m;
+	return r;
+}
+
+function draw() {
+	var c = document.getElementById("throughput"), ctx = c.getContext("2d");
+	var max = Math.max.apply(null, history.concat([1]));
+	ctx.clearRect(0, 0, c.width, c.height);
+	ctx.fillText(Math.round(max) + "/s", 2, 10);
+	ctx.beginPath();
+	history.forEach(function(v, i) {
+		var x = i * c.width / 60, y = c.height - v * (c.height - 12) / max;
+		if (i == 0) ctx.moveTo(x, y); else ctx.lineTo(x, y);
+	});
+	ctx.stroke();
+}
+
+function update() {
+	Promise.all([get("/api/status"), get("/api/metrics").catch(function() { return null; })])
+	.then(function(res) {
+		var s = res[0], m = res[1], r = {}, stats = {};
+		if (m && m.replicas) {
+			r = rates(m);
+			m.replicas.forEach(function(x) { stats[x.id] = x.stats || {}; });
+			var total = Object.keys(r).reduce(function(t, k) { return t + r[k]; }, 0);
+			history.push(total);
+			if (history.length > 60) history.shift();
+			draw();
+		}
+		document.getElementById("summary").textContent = s.n + " replicas running " +
+			(s.protocol || "mixed protocols") + ", leader " + (s.leader < 0 ? "unknown" : s.leader) +
+			, epoch " + s.epoch + ", policy " + s.policy + (s.primary ? "" : " (not the primary master)");
+		document.getElementById("replicas").innerHTML = s.replicas.map(function(x) {
+			var st = stats[x.id] || {};
+			return "<tr class='" + (x.alive ? "" : "dead ") + (x.leader ? "leader" : "") + "'>" +
+				"<td>" + x.id + "</td><td>" + text(x.addr) + "</td><td>" + text(x.protocol) + "</td>" +
+				"<td>" + (x.alive ? "alive" : "dead") + (x.leader ? ", leader" : "") + "</td>" +
+				"<td>" + (x.latency > 1e300 ? "-" : x.latency) + "</td>" +
+				"<td>" + (x.id in r ? Math.round(r[x.id]) : "-") + "</td>" +
+				"<td>" + (x.id in stats ? st.rejected || 0 : "-") + "</td></tr>";
+		}).join("");
+		document.getElementById("choices").innerHTML = (s.choices || []).slice().reverse().map(function(c) {
+			return "<tr><td>" + new Date(c.time).toLocaleTimeString() + "</td><td>" + text(c.reason) +
+				"</td><td>" + c.leader + "</td><td>" + text(JSON.stringify(c.ranking)) + "</td></tr>";
+		}).join("");
+		document.getElementById("error").textContent = "";
+	}).catch(function(e) {
+		document.getElementById("error").textContent = "cannot reach the master: " + e;
+	});
+}
+
+update();
+setInterval(update, 1000);
+</script>
+</body>
+</html>
+`
@@ -1,8 +1,55 @@
 package defs
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotPrimary is returned by the masters that cannot
+// register replicas (see MasterAddrs).
+var ErrNotPrimary = errors.New("not the primary master")
+
+// The master rejects the registration of a replica with ErrClusterFull
+// if every replica is registered and none can be replaced, and with
+// ErrDuplicate if a live replica has the same address.
+var (
+	ErrClusterFull = errors.New("all the replicas are registered")
+	ErrDuplicate   = errors.New("a live replica is registered at this address")
+)
+
+// IsError returns true if err, returned by an RPC, is target.
+func IsError(err, target error) bool {
+	return err != nil && err.Error() == target.Error()
+}
+
+// MasterAddrs returns the addresses of the masters given as a
+// comma-separated list, port being the port of those without one.
+// The clients and the servers try the masters in this order.
+func MasterAddrs(addrs string, port int) []string {
+	as := []string{}
+	for _, a := range strings.Split(addrs, ",") {
+		a = strings.TrimSpace(a)
+		if !strings.Contains(a, ":") {
+			a = fmt.Sprintf("%s:%d", a, port)
+		}
+		as = append(as, a)
+	}
+	return as
+}
+
 type RegisterArgs struct {
 	Addr string
 	Port int
+	// Protocol, MaxFailures (-1 if not given) and Quorums
+	// (the content of its quorum file) describe the replica
+	Protocol    string
+	MaxFailures int
+	Quorums     []byte
+	// Token identifies the process of the replica, so that the master
+	// can tell a restarted replica from another one at the same address
+	Token string
 }
 
 type RegisterReply struct {
@@ -10,12 +57,19 @@ type RegisterReply struct {
 	NodeList  []string
 	Ready     bool
 	IsLeader  bool
+	// Join is true if the other replicas are already running
+	// (e.g., the replica replaces a dead one)
+	Join  bool
+	Epoch int32
 }
 
 type GetLeaderArgs struct{}
 
 type GetLeaderReply struct {
 	LeaderId int
+	// Epoch is the configuration epoch of the master, which changes
+	// with the replicas, their liveness and the leader
+	Epoch int32
 }
 
 type GetReplicaListArgs struct{}
@@ -24,4 +78,101 @@ type GetReplicaListReply struct {
 	ReplicaList []string
 	AliveList   []bool
 	Ready       bool
+	Epoch       int32
 }
+
+type GetLatencyMatrixArgs struct{}
+
+type GetLatencyMatrixReply struct {
+	// Matrix[i][j] is the round-trip time in milliseconds from replica
+	// i to replica j, -1 if unknown (Matrix[i] is nil if i is dead)
+	Matrix [][]float64
+}
+
+type GetClusterInfoArgs struct{}
+
+type ReplicaInfo struct {
+	Addr        string
+	Alive       bool
+	Protocol    string
+	MaxFailures int
+	Quorums     []byte
+}
+
+type GetClusterInfoReply struct {
+	Ready bool
+	// Protocol is empty if the replicas do not run the same protocol
+	Protocol string
+	N        int
+	Replicas []ReplicaInfo
+	Epoch    int32
+}
+
+type GetLeaderChoicesArgs struct{}
+
+// LeaderChoice is a leader chosen by the master.
+type LeaderChoice struct {
+	Time time.Time `json:"time"`
+	// Reason is "initial", "failure" or "transfer"
+	Reason string `json:"reason"`
+	// Ranking is the order in which the replicas were tried
+	Ranking []int `json:"ranking"`
+	Leader  int   `json:"leader"`
+}
+
+type GetLeaderChoicesReply struct {
+	Policy  string
+	Choices []LeaderChoice
+}
+
+type ReportClientArgs struct {
+	ClientId int32
+	Closest  int
+}
+
+type ReportClientReply struct{}
+
+type TransferLeaderArgs struct {
+	Replica int
+}
+
+type TransferLeaderReply struct{}
+
+type InstallQuorumsArgs struct {
+	// Content has the format of a quorum file
+	Content []byte
+}
+
+type InstallQuorumsReply struct {
+	Leader int32
+	// Ballot is the ballot at which the new active quorums are used
+	Ballot int32
+}
+
+type SnapshotArgs struct {
+	// Replica is -1 for all the replicas
+	Replica int
+}
+
+type SnapshotReply struct {
+	// Files[i] is the snapshot of replica i, empty if none was taken
+	Files []string
+	Keys  []int
+	// Positions[i] is the position of the snapshot of replica i in
+	// the log of the protocol, -1 if unknown
+	Positions []int32
+}
+
+type GetStatsArgs struct {
+	Replica int
+}
+
+type GetStatsReply struct {
+	Stats map[string]int
+}
+
+type ReplaceReplicaArgs struct {
+	Replica int
+}
+
+type ReplaceReplicaReply struct{}
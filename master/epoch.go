@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/rpc"
+
+	"github.com/vonaka/shreplic/server/smr"
+)
+
+// Configuration epochs.
+//
+// The epoch of the master increases each time its view of the replicas
+// changes: the list of replicas, which ones are alive or which one is
+// the leader. It is given with the replies of the master and sent to
+// the replicas, which give it to the clients (see server/smr/epoch.go).
+
+// updateEpoch increases the epoch if the view has changed. It must be
+// called with master.lock held.
+func (master *Master) updateEpoch() {
+	view := fmt.Sprint(master.nodeList, master.alive, master.leader)
+	if view == master.view {
+		return
+	}
+	master.view = view
+	master.epoch++
+	log.Printf("Configuration epoch %d", master.epoch)
+}
+
+// pushEpoch sends the epoch to the alive replicas that do not know it.
+func (master *Master) pushEpoch() {
+	master.lock.Lock()
+	epoch := master.epoch
+	nodes := []*rpc.Client{}
+	ids := []int{}
+	for i, node := range master.nodes {
+		if master.alive[i] && node != nil && master.pushed[i] != epoch {
+			nodes = append(nodes, node)
+			ids = append(ids, i)
+		}
+	}
+	master.lock.Unlock()
+
+	for j, node := range nodes {
+		err := node.Call("Replica.SetEpoch", &smr.SetEpochArgs{
+			Epoch: epoch,
+		}, new(smr.SetEpochReply))
+		if err != nil {
+			continue
+		}
+		master.lock.Lock()
+		if master.pushed[ids[j]] < epoch {
+			master.pushed[ids[j]] = epoch
+		}
+		master.lock.Unlock()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/vonaka/shreplic/master/defs"
+	"github.com/vonaka/shreplic/server/smr"
+)
+
+// HTTP API.
+//
+// Besides the RPCs, the master answers on its port:
+//
+//	/api/status   the replicas, the leader, the latencies and the
+//	              choices of leaders, as JSON
+//	/api/metrics  the statistics of each replica (see Replica.GetStats),
+//	              as JSON, only on the primary master
+//	/             a dashboard that shows both
+
+type replicaStatus struct {
+	Id          int     `json:"id"`
+	Addr        string  `json:"addr"`
+	Protocol    string  `json:"protocol"`
+	MaxFailures int     `json:"maxFailures"`
+	Alive       bool    `json:"alive"`
+	Leader      bool    `json:"leader"`
+	Latency     float64 `json:"latency"`
+}
+
+type status struct {
+	N        int                 `json:"n"`
+	Ready    bool                `json:"ready"`
+	Protocol string              `json:"protocol"`
+	Leader   int                 `json:"leader"`
+	Epoch    int32               `json:"epoch"`
+	Primary  bool                `json:"primary"`
+	Policy   string              `json:"policy"`
+	Replicas []replicaStatus     `json:"replicas"`
+	Matrix   [][]float64         `json:"matrix"`
+	Choices  []defs.LeaderChoice `json:"choices"`
+}
+
+type replicaMetrics struct {
+	Id    int            `json:"id"`
+	Stats map[string]int `json:"stats"`
+	Error string         `json:"error,omitempty"`
+}
+
+type metrics struct {
+	Time     time.Time        `json:"time"`
+	Replicas []replicaMetrics `json:"replicas"`
+}
+
+func (master *Master) handleHTTP() {
+	http.HandleFunc("/api/status", master.serveStatus)
+	http.HandleFunc("/api/metrics", master.serveMetrics)
+	http.HandleFunc("/", serveDashboard)
+}
+
+func (master *Master) serveStatus(w http.ResponseWriter, req *http.Request) {
+	master.load()
+	master.lock.Lock()
+	s := status{
+		N:        master.N,
+		Ready:    len(master.nodeList) == master.N,
+		Leader:   -1,
+		Epoch:    master.epoch,
+		Primary:  master.group.isPrimary(),
+		Policy:   *policyName,
+		Replicas: make([]replicaStatus, len(master.nodeList)),
+		Matrix:   master.copyMatrix(),
+		Choices:  make([]defs.LeaderChoice, len(master.choices)),
+	}
+	for i := range master.nodeList {
+		s.Replicas[i] = replicaStatus{
+			Id:          i,
+			Addr:        master.info[i].Addr,
+			Protocol:    master.info[i].Protocol,
+			MaxFailures: master.info[i].MaxFailures,
+			Alive:       master.alive[i],
+			Leader:      master.leader[i],
+			Latency:     master.latencies[i],
+		}
+		if master.leader[i] {
+			s.Leader = i
+		}
+		if i == 0 || s.Protocol == master.info[i].Protocol {
+			s.Protocol = master.info[i].Protocol
+		} else {
+			s.Protocol = ""
+		}
+	}
+	copy(s.Choices, master.choices)
+	master.lock.Unlock()
+
+	writeJSON(w, http.StatusOK, &s)
+}
+
+func (master *Master) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	if !master.group.isPrimary() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"error": defs.ErrNotPrimary.Error(),
+		})
+		return
+	}
+
+	m := metrics{
+		Time:     time.Now(),
+		Replicas: make([]replicaMetrics, master.N),
+	}
+	for i := range m.Replicas {
+		reply := &smr.StatsReply{}
+		m.Replicas[i].Id = i
+		err := master.callReplica(i, "GetStats", new(smr.StatsArgs), reply)
+		if err != nil {
+			m.Replicas[i].Error = err.Error()
+		} else {
+			m.Replicas[i].Stats = reply.Stats
+		}
+	}
+	writeJSON(w, http.StatusOK, &m)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func serveDashboard(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboard))
+}
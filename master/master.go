@@ -9,14 +9,13 @@ import (
 	"net"
 	"net/http"
 	"net/rpc"
-	"os/exec"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/vonaka/shreplic/master/defs"
 	"github.com/vonaka/shreplic/server/smr"
+	"github.com/vonaka/shreplic/tools"
 )
 
 var (
@@ -24,6 +23,9 @@ var (
 	numNodes = flag.Int("N", 3, "Number of replicas")
 )
 
+// RegisterTimeout is how long Register waits for the other replicas.
+var RegisterTimeout = 10 * time.Second
+
 type Master struct {
 	N          int
 	nodeList   []string
@@ -34,34 +36,70 @@ type Master struct {
 	leader     []bool
 	alive      []bool
 	latencies  []float64
+	matrix     [][]float64
+	info       []defs.ReplicaInfo
 	finishInit bool
 	initCond   *sync.Cond
+	// signaled once every replica is registered
+	regCond *sync.Cond
+	// process of each replica (see defs.RegisterArgs)
+	tokens []string
+	// number of calls to Register waiting, by token
+	registering map[string]int
 	nextLeader int
+	// initial leader, -1 until all the replicas are registered
+	firstLeader int
+	// closest replica of each client
+	clients  map[int32]int
+	choices  []defs.LeaderChoice
+	nextTurn int
+	// dead replicas that a new machine can replace
+	replaceable []bool
+	deadSince   []time.Time
+	// configuration epoch, and the last one sent to each replica
+	epoch  int32
+	view   string
+	pushed []int32
+	// last state written to -store
+	stored []byte
+	// group is nil if the master is not replicated
+	group *group
+	// incremented each time the state to replicate changes
+	version int64
+	// last state to replicate, and the last version proposed to the
+	// group with the error of its proposal (see replicator)
+	pending        []byte
+	replicated     int64
+	replicateErr   error
+	replicating    chan struct{}
+	replicatedCond *sync.Cond
 }
 
 func main() {
 	flag.Parse()
 
+	if _, exists := policies[*policyName]; !exists {
+		log.Fatalf("unknown policy %s (policies: %s)", *policyName, policyNames())
+	}
+
 	log.Printf("Master starting on port %d", *portnum)
 	log.Printf("...waiting for %d replicas", *numNodes)
 
-	master := &Master{
-		N:          *numNodes,
-		nodeList:   make([]string, 0, *numNodes),
-		addrList:   make([]string, 0, *numNodes),
-		portList:   make([]int, 0, *numNodes),
-		lock:       new(sync.Mutex),
-		nodes:      make([]*rpc.Client, *numNodes),
-		leader:     make([]bool, *numNodes),
-		alive:      make([]bool, *numNodes),
-		latencies:  make([]float64, *numNodes),
-		finishInit: false,
-		nextLeader: -1,
+	master := newMaster(*numNodes)
+	if *masters != "" {
+		if addrs := defs.MasterAddrs(*masters, *portnum); len(addrs) > 1 {
+			master.group = newGroup(addrs, *masterId)
+			go master.replicator()
+		}
+	}
+
+	if err := master.restore(); err != nil {
+		log.Fatalf("Cannot restore %s: %v", *storeFile, err)
 	}
-	master.initCond = sync.NewCond(master.lock)
 
 	rpc.Register(master)
 	rpc.HandleHTTP()
+	master.handleHTTP()
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", *portnum))
 	if err != nil {
 		log.Fatal("Master listen error:", err)
@@ -72,120 +110,275 @@ func main() {
 	http.Serve(l, nil)
 }
 
+func newMaster(n int) *Master {
+	master := &Master{
+		N:           n,
+		nodeList:    make([]string, 0, n),
+		addrList:    make([]string, 0, n),
+		portList:    make([]int, 0, n),
+		tokens:      make([]string, 0, n),
+		registering: make(map[string]int),
+		lock:        new(sync.Mutex),
+		nodes:       make([]*rpc.Client, n),
+		leader:      make([]bool, n),
+		alive:       make([]bool, n),
+		latencies:   make([]float64, n),
+		matrix:      make([][]float64, n),
+		info:        make([]defs.ReplicaInfo, n),
+		finishInit:  false,
+		nextLeader:  -1,
+
+		firstLeader: -1,
+		clients:     make(map[int32]int),
+		replaceable: make([]bool, n),
+		deadSince:   make([]time.Time, n),
+		pushed:      make([]int32, n),
+		replicating: make(chan struct{}, 1),
+	}
+	master.initCond = sync.NewCond(master.lock)
+	master.regCond = sync.NewCond(master.lock)
+	master.replicatedCond = sync.NewCond(master.lock)
+	return master
+}
+
 func (master *Master) run() {
-	for {
-		master.lock.Lock()
-		if len(master.nodeList) == master.N {
-			master.lock.Unlock()
-			break
-		}
-		master.lock.Unlock()
-		time.Sleep(100000000)
+	master.follow()
+
+	master.lock.Lock()
+	for len(master.nodeList) < master.N {
+		master.regCond.Wait()
 	}
+	master.lock.Unlock()
 	time.Sleep(2000000000)
 
 	for i := 0; i < master.N; {
-		var err error
+		master.lock.Lock()
 		addr := fmt.Sprintf("%s:%d", master.addrList[i], master.portList[i]+1000)
-		master.nodes[i], err = rpc.DialHTTP("tcp", addr)
-		if err != nil {
+		finishInit, leader := master.finishInit, master.leader[i]
+		master.lock.Unlock()
+
+		node, err := rpc.DialHTTP("tcp", addr)
+		if err != nil && finishInit {
+			// the replica has failed before this master took over
+			log.Printf("Error connecting to replica %d (%v)", i, addr)
+			i++
+		} else if err != nil {
 			log.Printf("Error connecting to replica %d (%v), retrying...", i, addr)
 			time.Sleep(1000000000)
 		} else {
-			btlReply := smr.NewBeTheLeaderReply()
-			if master.leader[i] {
-				err = master.nodes[i].Call("Replica.BeTheLeader",
+			master.lock.Lock()
+			master.nodes[i] = node
+			master.lock.Unlock()
+			// the leader is already known if this master
+			// replaces the primary one
+			if leader && !finishInit {
+				btlReply := smr.NewBeTheLeaderReply()
+				err = node.Call("Replica.BeTheLeader",
 					new(smr.BeTheLeaderArgs), btlReply)
+				if defs.IsError(err, smr.ErrNoTransfer) {
+					// the leader of the protocol is fixed
+					glReply := &smr.GetLeaderReply{}
+					err = node.Call("Replica.GetLeader",
+						new(smr.GetLeaderArgs), glReply)
+					btlReply.Leader = glReply.Leader
+				}
 				if err != nil {
 					log.Fatal("Not today Zurg!")
 				}
 				smr.UpdateBeTheLeaderReply(btlReply)
+				master.lock.Lock()
 				if btlReply.Leader != -1 && btlReply.Leader != int32(i) {
 					master.leader[i] = false
 					master.leader[int(btlReply.Leader)] = true
 				}
 				master.nextLeader = int(btlReply.NextLeader)
+				master.lock.Unlock()
 			}
 			i++
 		}
 	}
 
-	var new_leader bool
-	pingNode := func(i int, node *rpc.Client) {
-		err := node.Call("Replica.Ping", new(smr.PingArgs), new(smr.PingReply))
-		if err != nil {
-			master.alive[i] = false
-			if master.leader[i] {
-				new_leader = true
-				master.leader[i] = false
-			}
-		} else {
-			master.alive[i] = true
-		}
-	}
+	master.pingNodes()
 	master.lock.Lock()
-	for i, node := range master.nodes {
-		pingNode(i, node)
-	}
 	// initialization is finished
 	// (i.e., slice `alive` has been computed)
 	master.finishInit = true
 	master.initCond.Broadcast()
+	version := master.replicate()
 	master.lock.Unlock()
+	master.synced(version)
+	master.pushEpoch()
 
 	beTheLeader := func(i int) error {
-		if master.alive[i] {
-			btlReply := smr.NewBeTheLeaderReply()
-			err := master.nodes[i].Call("Replica.BeTheLeader",
-				new(smr.BeTheLeaderArgs), btlReply)
-			if err == nil {
-				smr.UpdateBeTheLeaderReply(btlReply)
-				leaderI := i
-				if btlReply.Leader != -1 {
-					leaderI = int(btlReply.Leader)
-				}
-				master.leader[leaderI] = true
-				master.nextLeader = int(btlReply.NextLeader)
-				log.Printf("Replica %d is the new leader", leaderI)
-				return nil
-			}
+		btlReply := smr.NewBeTheLeaderReply()
+		err := master.callReplica(i, "BeTheLeader", new(smr.BeTheLeaderArgs), btlReply)
+		// the leader of the protocol is fixed,
+		// the master only records its choice
+		if defs.IsError(err, smr.ErrNoTransfer) {
+			err = nil
+		}
+		if err != nil {
 			return err
 		}
-		return errors.New("dead")
+		smr.UpdateBeTheLeaderReply(btlReply)
+		leaderI := i
+		if btlReply.Leader != -1 {
+			leaderI = int(btlReply.Leader)
+		}
+		master.lock.Lock()
+		master.leader[leaderI] = true
+		master.nextLeader = int(btlReply.NextLeader)
+		master.lock.Unlock()
+		log.Printf("Replica %d is the new leader", leaderI)
+		return nil
 	}
 
 	for {
 		time.Sleep(1000 * 1000 * 1000 * 3)
-		new_leader = false
-		for i, node := range master.nodes {
-			pingNode(i, node)
+		if !master.group.isPrimary() {
+			master.load()
+			continue
 		}
+		newLeader := master.pingNodes()
+		master.updateMatrix()
 
-		if !new_leader {
-			continue
+		if newLeader {
+			master.lock.Lock()
+			ranking := master.rankLeaders()
+			master.lock.Unlock()
+			for _, i := range ranking {
+				if beTheLeader(i) == nil {
+					master.lock.Lock()
+					master.chose("failure", ranking, i)
+					master.lock.Unlock()
+					break
+				}
+			}
 		}
-		if master.nextLeader != -1 {
-			if beTheLeader(master.nextLeader) == nil {
-				continue
+		master.lock.Lock()
+		version := master.replicate()
+		master.lock.Unlock()
+		master.synced(version)
+		master.pushEpoch()
+	}
+}
+
+// pingNodes pings the replicas, redialing those that have been
+// restarted, and returns true if the leader is dead. The calls are made
+// without master.lock, which is only taken to apply their results.
+func (master *Master) pingNodes() bool {
+	master.lock.Lock()
+	nodes := append([]*rpc.Client(nil), master.nodes...)
+	addrs := make([]string, len(nodes))
+	for i := range addrs {
+		addrs[i] = net.JoinHostPort(master.addrList[i], strconv.Itoa(master.portList[i]+1000))
+	}
+	finishInit := master.finishInit
+	master.lock.Unlock()
+
+	dialed := make([]*rpc.Client, len(nodes))
+	errs := make([]error, len(nodes))
+	for i, node := range nodes {
+		if node == nil && finishInit {
+			// the replica has been restarted, replaced
+			// or was dead when this master took over
+			node = master.dial(i, addrs[i])
+			dialed[i] = node
+		}
+		errs[i] = errors.New("not connected")
+		if node != nil {
+			errs[i] = node.Call("Replica.Ping", new(smr.PingArgs), new(smr.PingReply))
+			if errs[i] != nil {
+				// redial the replica once it restarts
+				node.Close()
 			}
 		}
-		for i := range master.nodes {
-			if beTheLeader(i) == nil {
-				break
+	}
+
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	newLeader := false
+	for i, node := range nodes {
+		if master.nodes[i] != node {
+			// the replica has been replaced in the meantime
+			if dialed[i] != nil {
+				dialed[i].Close()
 			}
+			continue
+		}
+		if node == nil && finishInit {
+			master.nodes[i] = dialed[i]
+			master.pushed[i] = 0
+		}
+		if errs[i] != nil {
+			master.nodes[i] = nil
+			if master.alive[i] || master.deadSince[i].IsZero() {
+				master.deadSince[i] = time.Now()
+			}
+			master.alive[i] = false
+			if master.leader[i] {
+				newLeader = true
+				master.leader[i] = false
+			}
+		} else {
+			master.alive[i] = true
+			master.deadSince[i] = time.Time{}
 		}
 	}
+	return newLeader
 }
 
+// updateMatrix gathers the latencies measured by the replicas.
+func (master *Master) updateMatrix() {
+	master.lock.Lock()
+	nodes := append([]*rpc.Client(nil), master.nodes...)
+	alive := append([]bool(nil), master.alive...)
+	master.lock.Unlock()
+
+	matrix := make([][]float64, len(nodes))
+	for i, node := range nodes {
+		reply := &smr.LatenciesReply{}
+		if alive[i] && node != nil &&
+			node.Call("Replica.GetLatencies", new(smr.LatenciesArgs), reply) == nil {
+			matrix[i] = reply.RTTs
+		}
+	}
+
+	master.lock.Lock()
+	copy(master.matrix, matrix)
+	master.lock.Unlock()
+}
+
+// Register registers a replica and waits until every replica is
+// registered or RegisterTimeout has passed, in which case reply.Ready is
+// false and the replica must call Register again.
 func (master *Master) Register(args *defs.RegisterArgs, reply *defs.RegisterReply) error {
+	if !master.group.isPrimary() {
+		return defs.ErrNotPrimary
+	}
+
+	addrPort := fmt.Sprintf("%s:%d", args.Addr, args.Port)
+	running := master.running(addrPort, args.Token)
+
+	version, err := master.register(addrPort, running, args, reply)
+	if err != nil {
+		return err
+	}
+	// the other masters must know the replica before it starts
+	return master.synced(version)
+}
+
+// register is the part of Register made with master.lock held. It
+// returns the version of the state to replicate.
+func (master *Master) register(addrPort string, running bool,
+	args *defs.RegisterArgs, reply *defs.RegisterReply) (int64, error) {
 	master.lock.Lock()
 	defer master.lock.Unlock()
 
 	nlen := len(master.nodeList)
 	index := nlen
 
-	addrPort := fmt.Sprintf("%s:%d", args.Addr, args.Port)
-
 	for i, ap := range master.nodeList {
 		if addrPort == ap {
 			index = i
@@ -193,48 +386,97 @@ func (master *Master) Register(args *defs.RegisterArgs, reply *defs.RegisterRepl
 		}
 	}
 
-	if index == nlen {
+	fresh := index == nlen
+	if fresh && nlen == master.N {
+		if index = master.replacement(); index == -1 {
+			log.Printf("Registration of %s rejected: %v", addrPort, defs.ErrClusterFull)
+			return 0, defs.ErrClusterFull
+		}
+		master.replace(index, args.Addr, args.Port)
+		master.leader[index] = false
+	} else if fresh {
 		master.nodeList = master.nodeList[0 : nlen+1]
 		master.nodeList[nlen] = addrPort
 		master.addrList = master.addrList[0 : nlen+1]
 		master.addrList[nlen] = args.Addr
 		master.portList = master.portList[0 : nlen+1]
 		master.portList[nlen] = args.Port
+		master.tokens = master.tokens[0 : nlen+1]
 		master.leader[index] = false
 		nlen++
+		if nlen == master.N {
+			master.regCond.Broadcast()
+		}
+	} else if args.Token != master.tokens[index] &&
+		(running || master.registering[master.tokens[index]] > 0) {
+		log.Printf("Registration of %s rejected: %v", addrPort, defs.ErrDuplicate)
+		return 0, defs.ErrDuplicate
+	}
+	master.tokens[index] = args.Token
 
+	if fresh {
 		addr := args.Addr
 		if addr == "" {
 			addr = "127.0.0.1"
 		}
-		out, err := exec.Command("ping", addr, "-c 2", "-q").Output()
+		// the replica listens once every replica is registered
+		latency, err := tools.PingHost(fmt.Sprintf("%s:%d", addr, args.Port), 2)
 		if err == nil {
-			master.latencies[index], _ =
-				strconv.ParseFloat(strings.Split(string(out), "/")[4], 64)
+			master.latencies[index] = latency
 			log.Printf("node %v [%v] -> %v", index,
 				master.nodeList[index], master.latencies[index])
 		} else {
-			log.Fatal("cannot connect to" + addr)
+			// the replica cannot be the first leader
+			master.latencies[index] = math.MaxFloat64
+			log.Printf("cannot ping node %v [%v]: %v", index,
+				master.nodeList[index], err)
 		}
 	}
 
-	if nlen == master.N {
+	master.info[index] = defs.ReplicaInfo{
+		Addr:        addrPort,
+		Protocol:    args.Protocol,
+		MaxFailures: args.MaxFailures,
+		Quorums:     args.Quorums,
+	}
+	if master.info[index].MaxFailures == -1 {
+		master.info[index].MaxFailures = (master.N - 1) / 2
+	}
+	master.replicate()
+
+	master.registering[args.Token]++
+	defer func() {
+		if master.registering[args.Token]--; master.registering[args.Token] == 0 {
+			delete(master.registering, args.Token)
+		}
+	}()
+	timeout := false
+	timer := time.AfterFunc(RegisterTimeout, func() {
+		master.lock.Lock()
+		timeout = true
+		master.regCond.Broadcast()
+		master.lock.Unlock()
+	})
+	defer timer.Stop()
+	for len(master.nodeList) < master.N && !timeout {
+		master.regCond.Wait()
+	}
+
+	if len(master.nodeList) == master.N {
 		reply.Ready = true
 		reply.ReplicaId = index
+		reply.Join = master.finishInit
+		reply.Epoch = master.epoch
 		reply.NodeList = master.nodeList
 		reply.IsLeader = false
 
-		minLatency := math.MaxFloat64
-		leader := 0
-
-		for i := 0; i < len(master.leader); i++ {
-			if master.latencies[i] < minLatency {
-				minLatency = master.latencies[i]
-				leader = i
-			}
+		if master.firstLeader == -1 {
+			ranking := master.rankLeaders()
+			master.firstLeader = ranking[0]
+			master.chose("initial", ranking, ranking[0])
 		}
 
-		if leader == index {
+		if master.firstLeader == index && !master.finishInit {
 			log.Printf("Replica %d is the new leader", index)
 			master.leader[index] = true
 			reply.IsLeader = true
@@ -244,25 +486,47 @@ func (master *Master) Register(args *defs.RegisterArgs, reply *defs.RegisterRepl
 		reply.Ready = false
 	}
 
-	return nil
+	return master.replicate(), nil
+}
+
+// running returns true if the replica registered at addrPort with
+// another token than token answers a ping. The master is only
+// connected to the replicas once they are all registered. Before, a
+// replica that is running waits in Register (see master.registering),
+// so a replica restarted before the registration of its previous
+// process times out is rejected as well.
+func (master *Master) running(addrPort, token string) bool {
+	var node *rpc.Client
+	master.lock.Lock()
+	for i, ap := range master.nodeList {
+		if ap == addrPort && master.tokens[i] != token {
+			node = master.nodes[i]
+		}
+	}
+	master.lock.Unlock()
+	if node == nil {
+		return false
+	}
+	return node.Call("Replica.Ping", new(smr.PingArgs), new(smr.PingReply)) == nil
 }
 
 func (master *Master) GetLeader(args *defs.GetLeaderArgs, reply *defs.GetLeaderReply) error {
+	master.load()
 	master.lock.Lock()
 	defer master.lock.Unlock()
 
 	for i, l := range master.leader {
 		if l {
-			*reply = defs.GetLeaderReply{
-				LeaderId: i,
-			}
+			reply.LeaderId = i
 			break
 		}
 	}
+	reply.Epoch = master.epoch
 	return nil
 }
 
 func (master *Master) GetReplicaList(args *defs.GetReplicaListArgs, reply *defs.GetReplicaListReply) error {
+	master.load()
 	master.lock.Lock()
 
 	for !master.finishInit {
@@ -275,6 +539,7 @@ func (master *Master) GetReplicaList(args *defs.GetReplicaListArgs, reply *defs.
 		reply.Ready = false
 	}
 
+	reply.Epoch = master.epoch
 	reply.ReplicaList = make([]string, 0)
 	reply.AliveList = make([]bool, 0)
 	for i, node := range master.nodeList {
@@ -286,3 +551,50 @@ func (master *Master) GetReplicaList(args *defs.GetReplicaListArgs, reply *defs.
 	master.lock.Unlock()
 	return nil
 }
+
+func (master *Master) GetLatencyMatrix(args *defs.GetLatencyMatrixArgs, reply *defs.GetLatencyMatrixReply) error {
+	master.load()
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	reply.Matrix = master.copyMatrix()
+	return nil
+}
+
+// copyMatrix returns a copy of the latency matrix, rows included. It
+// must be called with master.lock held.
+func (master *Master) copyMatrix() [][]float64 {
+	m := make([][]float64, len(master.matrix))
+	for i, row := range master.matrix {
+		m[i] = append([]float64(nil), row...)
+	}
+	return m
+}
+
+func (master *Master) GetClusterInfo(args *defs.GetClusterInfoArgs, reply *defs.GetClusterInfoReply) error {
+	master.load()
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	for !master.finishInit {
+		master.initCond.Wait()
+	}
+
+	reply.Ready = len(master.nodeList) == master.N
+	reply.N = master.N
+	reply.Epoch = master.epoch
+	reply.Replicas = make([]defs.ReplicaInfo, len(master.nodeList))
+	for i := range master.nodeList {
+		reply.Replicas[i] = master.info[i]
+		reply.Replicas[i].Alive = master.alive[i]
+	}
+	if len(master.nodeList) > 0 {
+		reply.Protocol = master.info[0].Protocol
+	}
+	for _, info := range reply.Replicas {
+		if info.Protocol != reply.Protocol {
+			reply.Protocol = ""
+		}
+	}
+	return nil
+}
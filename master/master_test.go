@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vonaka/shreplic/master/defs"
+)
+
+type registration struct {
+	reply *defs.RegisterReply
+	err   error
+}
+
+// register calls Register in the background.
+func register(master *Master, port int, token string) chan registration {
+	return registerProtocol(master, port, token, "paxos")
+}
+
+func registerProtocol(master *Master, port int, token, proto string) chan registration {
+	done := make(chan registration, 1)
+	go func() {
+		args := &defs.RegisterArgs{
+			Addr:        "127.0.0.1",
+			Port:        port,
+			Protocol:    proto,
+			MaxFailures: -1,
+			Token:       token,
+		}
+		reply := &defs.RegisterReply{}
+		err := master.Register(args, reply)
+		done <- registration{reply, err}
+	}()
+	return done
+}
+
+func wait(t *testing.T, done chan registration) registration {
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("Register did not return")
+	}
+	return registration{}
+}
+
+// waiting returns once a call to Register with token waits.
+func waiting(t *testing.T, master *Master, token string) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		master.lock.Lock()
+		n := master.registering[token]
+		master.lock.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s does not wait in Register", token)
+}
+
+func TestRegisterLongPoll(t *testing.T) {
+	timeout := RegisterTimeout
+	RegisterTimeout = 50 * time.Millisecond
+	defer func() {
+		RegisterTimeout = timeout
+	}()
+
+	master := newMaster(2)
+
+	// Register returns after RegisterTimeout until
+	// every replica is registered
+	r := wait(t, register(master, 1, "a"))
+	if r.err != nil || r.reply.Ready {
+		t.Fatalf("first replica ready alone (error %v)", r.err)
+	}
+
+	RegisterTimeout = 5 * time.Second
+	a := register(master, 1, "a")
+	waiting(t, master, "a")
+	select {
+	case <-a:
+		t.Fatal("Register returned before the other replica registered")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// another process cannot register at the same address
+	// while the replica waits
+	if r := wait(t, register(master, 1, "c")); r.err != defs.ErrDuplicate {
+		t.Fatalf("duplicate registration: error %v", r.err)
+	}
+
+	b := wait(t, register(master, 2, "b"))
+	ra := wait(t, a)
+	if ra.err != nil || b.err != nil {
+		t.Fatal(ra.err, b.err)
+	}
+	if !ra.reply.Ready || !b.reply.Ready {
+		t.Fatal("replicas not ready once both are registered")
+	}
+	if ra.reply.ReplicaId != 0 || b.reply.ReplicaId != 1 {
+		t.Errorf("replica ids are %d and %d", ra.reply.ReplicaId, b.reply.ReplicaId)
+	}
+	if ra.reply.IsLeader == b.reply.IsLeader {
+		t.Error("not exactly one leader")
+	}
+	if len(master.registering) != 0 {
+		t.Errorf("%d tokens still registering", len(master.registering))
+	}
+
+	// a restarted replica, which does not wait
+	// in Register anymore, registers again
+	if r := wait(t, register(master, 1, "a2")); r.err != nil || r.reply.ReplicaId != 0 {
+		t.Fatalf("restarted replica: id %d, error %v", r.reply.ReplicaId, r.err)
+	}
+}
+
+func TestRegisterClusterFull(t *testing.T) {
+	master := newMaster(1)
+	if r := wait(t, register(master, 1, "a")); r.err != nil || !r.reply.Ready {
+		t.Fatalf("replica not registered (error %v)", r.err)
+	}
+	if r := wait(t, register(master, 2, "b")); r.err != defs.ErrClusterFull {
+		t.Fatalf("registration in a full cluster: error %v", r.err)
+	}
+}
+
+func TestApplyInconsistentState(t *testing.T) {
+	master := newMaster(2)
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	err := master.apply([]byte(`{"NodeList": ["127.0.0.1:1", "127.0.0.1:2"],
+		"AddrList": ["127.0.0.1", "127.0.0.1"], "PortList": [1, 2],
+		"Tokens": ["a"], "Leader": [true, false]}`))
+	if err == nil {
+		t.Error("state with a missing token applied")
+	}
+	err = master.apply([]byte(`{"NodeList": ["127.0.0.1:1", "127.0.0.1:2"],
+		"AddrList": ["127.0.0.1", "127.0.0.1"], "PortList": [1, 2],
+		"Tokens": ["a", "b"], "Leader": [true, false]}`))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetClusterInfo(t *testing.T) {
+	timeout := RegisterTimeout
+	RegisterTimeout = 10 * time.Millisecond
+	defer func() {
+		RegisterTimeout = timeout
+	}()
+
+	master := newMaster(3)
+	info := func() *defs.GetClusterInfoReply {
+		done := make(chan *defs.GetClusterInfoReply, 1)
+		go func() {
+			reply := &defs.GetClusterInfoReply{}
+			master.GetClusterInfo(&defs.GetClusterInfoArgs{}, reply)
+			done <- reply
+		}()
+		select {
+		case reply := <-done:
+			return reply
+		case <-time.After(5 * time.Second):
+			t.Fatal("GetClusterInfo did not return")
+		}
+		return nil
+	}
+
+	// GetClusterInfo waits for the master to be initialized
+	done := make(chan struct{})
+	go func() {
+		master.GetClusterInfo(&defs.GetClusterInfoArgs{}, &defs.GetClusterInfoReply{})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("GetClusterInfo returned before the initialization")
+	case <-time.After(20 * time.Millisecond):
+	}
+	master.lock.Lock()
+	master.finishInit = true
+	master.initCond.Broadcast()
+	master.lock.Unlock()
+	<-done
+
+	wait(t, registerProtocol(master, 1, "a", "paxos"))
+	wait(t, registerProtocol(master, 2, "b", "paxos"))
+	reply := info()
+	if reply.Ready || reply.N != 3 || len(reply.Replicas) != 2 {
+		t.Fatalf("ready %v, N %d, %d replicas with two replicas registered",
+			reply.Ready, reply.N, len(reply.Replicas))
+	}
+	if reply.Protocol != "paxos" {
+		t.Errorf("protocol %q, want paxos", reply.Protocol)
+	}
+	for i, r := range reply.Replicas {
+		if r.Addr != fmt.Sprintf("127.0.0.1:%d", i+1) || r.MaxFailures != 1 || r.Alive {
+			t.Errorf("replica %d: %+v", i, r)
+		}
+	}
+
+	master.lock.Lock()
+	master.alive[1] = true
+	master.lock.Unlock()
+	wait(t, registerProtocol(master, 3, "c", "epaxos"))
+	reply = info()
+	if !reply.Ready || len(reply.Replicas) != 3 {
+		t.Fatalf("ready %v, %d replicas once every replica is registered",
+			reply.Ready, len(reply.Replicas))
+	}
+	if reply.Protocol != "" {
+		t.Errorf("protocol %q with replicas running paxos and epaxos", reply.Protocol)
+	}
+	if reply.Replicas[2].Protocol != "epaxos" || !reply.Replicas[1].Alive {
+		t.Errorf("replicas %+v", reply.Replicas)
+	}
+}
+
+func TestPingNodes(t *testing.T) {
+	master := newMaster(2)
+	master.lock.Lock()
+	for i := 0; i < 2; i++ {
+		master.nodeList = append(master.nodeList, fmt.Sprintf("127.0.0.1:%d", i+1))
+		master.addrList = append(master.addrList, "127.0.0.1")
+		master.portList = append(master.portList, i+1)
+		master.tokens = append(master.tokens, "")
+		master.alive[i] = true
+	}
+	master.leader[0] = true
+	master.lock.Unlock()
+
+	// the replicas are not connected
+	if !master.pingNodes() {
+		t.Error("dead leader not reported")
+	}
+	master.lock.Lock()
+	for i := 0; i < 2; i++ {
+		if master.alive[i] || master.leader[i] || master.deadSince[i].IsZero() {
+			t.Errorf("replica %d: alive %v, leader %v, dead since %v", i,
+				master.alive[i], master.leader[i], master.deadSince[i])
+		}
+	}
+	deadSince := master.deadSince[1]
+	master.finishInit = true
+	master.lock.Unlock()
+
+	// the replicas cannot be dialed
+	if master.pingNodes() {
+		t.Error("dead leader reported twice")
+	}
+	master.lock.Lock()
+	defer master.lock.Unlock()
+	if master.nodes[1] != nil || master.deadSince[1] != deadSince {
+		t.Errorf("replica 1: node %v, dead since %v instead of %v",
+			master.nodes[1], master.deadSince[1], deadSince)
+	}
+}
+
+func TestReplicateAlone(t *testing.T) {
+	master := newMaster(1)
+	master.lock.Lock()
+	version := master.replicate()
+	master.lock.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- master.synced(version)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("synced waits for a master that is not replicated")
+	}
+}
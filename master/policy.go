@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vonaka/shreplic/master/defs"
+)
+
+// Leader placement.
+//
+// A policy (-policy) ranks the replicas each time the master chooses a
+// leader: first once all the replicas are registered, then each time
+// the leader fails. The master tries the replicas in this order until
+// one of them becomes the leader. The policies are:
+//
+//	latency:    lowest latency to the master, after the successor
+//	            proposed by the last leader if any (the default)
+//	fixed:      replica -leader, then as latency
+//	median:     lowest median latency to the other replicas, according
+//	            to the latency matrix
+//	clients:    closest replica of most clients
+//	roundrobin: next replica each time (for testing)
+//
+// Without the latency matrix or the clients, median and clients rank
+// the replicas as latency. Each choice is logged and given by
+// GetLeaderChoices.
+
+var (
+	policyName  = flag.String("policy", "latency", "Leader placement policy (latency, fixed, median, clients or roundrobin)")
+	fixedLeader = flag.Int("leader", 0, "Leader chosen by the fixed policy")
+)
+
+type leaderPolicy func(master *Master) []int
+
+var policies = map[string]leaderPolicy{
+	"latency":    byLatency,
+	"fixed":      fixed,
+	"median":     byMedianLatency,
+	"clients":    byClients,
+	"roundrobin": roundRobin,
+}
+
+func policyNames() string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// rankBy sorts ids by key, preserving the order of equal ids.
+func rankBy(ids []int, key func(int) float64) []int {
+	sort.SliceStable(ids, func(i, j int) bool {
+		return key(ids[i]) < key(ids[j])
+	})
+	return ids
+}
+
+func byLatency(master *Master) []int {
+	ids := make([]int, master.N)
+	for i := range ids {
+		ids[i] = i
+	}
+	rankBy(ids, func(i int) float64 {
+		if i == master.nextLeader {
+			return math.Inf(-1)
+		}
+		return master.latencies[i]
+	})
+	return ids
+}
+
+func fixed(master *Master) []int {
+	return rankBy(byLatency(master), func(i int) float64 {
+		if i == *fixedLeader {
+			return 0
+		}
+		return 1
+	})
+}
+
+func byMedianLatency(master *Master) []int {
+	medians := make([]float64, master.N)
+	for i, row := range master.matrix {
+		rtts := []float64{}
+		for j, rtt := range row {
+			if j != i && rtt >= 0 {
+				rtts = append(rtts, rtt)
+			}
+		}
+		if len(rtts) == 0 {
+			medians[i] = math.Inf(1)
+			continue
+		}
+		sort.Float64s(rtts)
+		medians[i] = rtts[len(rtts)/2]
+		if len(rtts)%2 == 0 {
+			medians[i] = (medians[i] + rtts[len(rtts)/2-1]) / 2
+		}
+	}
+	return rankBy(byLatency(master), func(i int) float64 {
+		return medians[i]
+	})
+}
+
+func byClients(master *Master) []int {
+	clients := make([]float64, master.N)
+	for _, closest := range master.clients {
+		if closest >= 0 && closest < master.N {
+			clients[closest]++
+		}
+	}
+	return rankBy(byLatency(master), func(i int) float64 {
+		return -clients[i]
+	})
+}
+
+func roundRobin(master *Master) []int {
+	ids := make([]int, master.N)
+	for i := range ids {
+		ids[i] = (master.nextTurn + i) % master.N
+	}
+	master.nextTurn = (master.nextTurn + 1) % master.N
+	return ids
+}
+
+// rankLeaders must be called with master.lock held.
+func (master *Master) rankLeaders() []int {
+	return policies[*policyName](master)
+}
+
+// chose records that leader was chosen from ranking. It must be
+// called with master.lock held.
+func (master *Master) chose(reason string, ranking []int, leader int) {
+	log.Printf("Policy %s chose replica %d (%s, ranking %v)",
+		*policyName, leader, reason, ranking)
+	master.choices = append(master.choices, defs.LeaderChoice{
+		Time:    time.Now(),
+		Reason:  reason,
+		Ranking: ranking,
+		Leader:  leader,
+	})
+}
+
+func (master *Master) GetLeaderChoices(args *defs.GetLeaderChoicesArgs, reply *defs.GetLeaderChoicesReply) error {
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	reply.Policy = *policyName
+	reply.Choices = make([]defs.LeaderChoice, len(master.choices))
+	copy(reply.Choices, master.choices)
+	return nil
+}
+
+// ReportClient records the closest replica of a client.
+func (master *Master) ReportClient(args *defs.ReportClientArgs, reply *defs.ReportClientReply) error {
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	master.clients[args.ClientId] = args.Closest
+	return nil
+}
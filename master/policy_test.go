@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vonaka/shreplic/master/defs"
+)
+
+func TestPolicies(t *testing.T) {
+	leader := *fixedLeader
+	*fixedLeader = 2
+	defer func() {
+		*fixedLeader = leader
+	}()
+
+	newTestMaster := func() *Master {
+		master := newMaster(4)
+		copy(master.latencies, []float64{3, 1, 4, 2})
+		return master
+	}
+	for _, test := range []struct {
+		policy string
+		setup  func(master *Master)
+		want   []int
+	}{
+		{"latency", nil, []int{1, 3, 0, 2}},
+		{"latency", func(master *Master) {
+			master.nextLeader = 2
+		}, []int{2, 1, 3, 0}},
+		{"fixed", nil, []int{2, 1, 3, 0}},
+		// replica 0 is dead, the medians of 1, 2 and 3 are 3, 4 and 1
+		{"median", func(master *Master) {
+			master.matrix = [][]float64{
+				nil,
+				{-1, 0, 5, 1},
+				{-1, 5, 0, 3},
+				{-1, 1, -1, 0},
+			}
+		}, []int{3, 1, 2, 0}},
+		// without the matrix
+		{"median", nil, []int{1, 3, 0, 2}},
+		{"clients", func(master *Master) {
+			master.clients = map[int32]int{1: 0, 2: 0, 3: 2, 4: -1, 5: 9}
+		}, []int{0, 2, 1, 3}},
+		{"clients", nil, []int{1, 3, 0, 2}},
+	} {
+		master := newTestMaster()
+		if test.setup != nil {
+			test.setup(master)
+		}
+		if ranking := policies[test.policy](master); !reflect.DeepEqual(ranking, test.want) {
+			t.Errorf("%s: ranking %v, want %v", test.policy, ranking, test.want)
+		}
+	}
+
+	master := newTestMaster()
+	for _, want := range [][]int{{0, 1, 2, 3}, {1, 2, 3, 0}, {2, 3, 0, 1}} {
+		if ranking := roundRobin(master); !reflect.DeepEqual(ranking, want) {
+			t.Errorf("roundrobin: ranking %v, want %v", ranking, want)
+		}
+	}
+}
+
+func TestGetLeaderChoices(t *testing.T) {
+	master := newMaster(3)
+	master.chose("initial", []int{1, 0, 2}, 1)
+	master.chose("failure", []int{0, 2}, 2)
+
+	reply := &defs.GetLeaderChoicesReply{}
+	if err := master.GetLeaderChoices(&defs.GetLeaderChoicesArgs{}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Policy != *policyName {
+		t.Errorf("policy %s, want %s", reply.Policy, *policyName)
+	}
+	if len(reply.Choices) != 2 {
+		t.Fatalf("%d choices, want 2", len(reply.Choices))
+	}
+	for i, want := range []defs.LeaderChoice{
+		{Reason: "initial", Ranking: []int{1, 0, 2}, Leader: 1},
+		{Reason: "failure", Ranking: []int{0, 2}, Leader: 2},
+	} {
+		c := reply.Choices[i]
+		if c.Reason != want.Reason || c.Leader != want.Leader ||
+			!reflect.DeepEqual(c.Ranking, want.Ranking) || c.Time.IsZero() {
+			t.Errorf("choice %d: %+v, want %+v", i, c, want)
+		}
+	}
+
+	// the reply does not change with the later choices
+	master.chose("transfer", []int{0}, 0)
+	if len(reply.Choices) != 2 {
+		t.Errorf("%d choices in the reply", len(reply.Choices))
+	}
+}
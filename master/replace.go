@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"time"
+
+	"github.com/vonaka/shreplic/master/defs"
+	"github.com/vonaka/shreplic/server/smr"
+	"github.com/vonaka/shreplic/tools"
+)
+
+// Replacement of dead replicas.
+//
+// Once every replica is registered, a machine whose address is unknown
+// can only register in place of a dead replica, either one that the
+// operator allows to be replaced (ReplaceReplica, see shr-admin) or one
+// that has been dead for longer than -replacetimeout. The master sends
+// the new list of replicas to the live ones before answering the new
+// replica, which then joins them.
+
+var replaceTimeout = flag.Int("replacetimeout", 0,
+	"Seconds after which a dead replica can be replaced by a new machine (0 means only on request)")
+
+// replacement returns a dead replica that a new machine can
+// replace, -1 if there is none.
+func (master *Master) replacement() int {
+	if !master.finishInit {
+		return -1
+	}
+	timeout := time.Duration(*replaceTimeout) * time.Second
+	for i := range master.nodeList {
+		if master.alive[i] {
+			continue
+		}
+		if master.replaceable[i] || (timeout > 0 &&
+			!master.deadSince[i].IsZero() && time.Since(master.deadSince[i]) > timeout) {
+			return i
+		}
+	}
+	return -1
+}
+
+// replace gives the id of dead replica i to the machine
+// at addr:port. It must be called with master.lock held.
+func (master *Master) replace(i int, addr string, port int) {
+	addrPort := fmt.Sprintf("%s:%d", addr, port)
+	log.Printf("%s replaces replica %d [%s]", addrPort, i, master.nodeList[i])
+
+	master.nodeList[i] = addrPort
+	master.addrList[i] = addr
+	master.portList[i] = port
+	master.replaceable[i] = false
+	master.deadSince[i] = time.Time{}
+	if master.nodes[i] != nil {
+		master.nodes[i].Close()
+		master.nodes[i] = nil
+	}
+
+	args := &smr.SetPeersArgs{
+		Addrs: master.nodeList,
+	}
+	for j, node := range master.nodes {
+		if j == i || !master.alive[j] || node == nil {
+			continue
+		}
+		if err := node.Call("Replica.SetPeers", args, new(smr.SetPeersReply)); err != nil {
+			log.Printf("Replica %d cannot update its peers: %v", j, err)
+		}
+	}
+}
+
+// dial connects the master to replica i at addr, and returns nil if
+// the replica cannot be reached.
+func (master *Master) dial(i int, addr string) *rpc.Client {
+	// rpc.DialHTTP could wait for a long time
+	conn, err := net.DialTimeout("tcp", addr, tools.PingTimeout)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+	node, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		return nil
+	}
+	log.Printf("Connected to replica %d (%v)", i, addr)
+	return node
+}
+
+// ReplaceReplica allows a new machine to replace
+// the dead replica args.Replica.
+func (master *Master) ReplaceReplica(args *defs.ReplaceReplicaArgs, reply *defs.ReplaceReplicaReply) error {
+	if !master.group.isPrimary() {
+		return defs.ErrNotPrimary
+	}
+
+	master.lock.Lock()
+	i := args.Replica
+	if i < 0 || i >= master.N || !master.finishInit {
+		master.lock.Unlock()
+		return fmt.Errorf("no replica %d", i)
+	}
+	if master.alive[i] {
+		master.lock.Unlock()
+		return errors.New("only a dead replica can be replaced")
+	}
+	master.replaceable[i] = true
+	log.Printf("Replica %d [%s] can be replaced", i, master.nodeList[i])
+	version := master.replicate()
+	master.lock.Unlock()
+	return master.synced(version)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vonaka/shreplic/master/defs"
+	"github.com/vonaka/shreplic/paxos"
+	"github.com/vonaka/shreplic/server/smr"
+	"github.com/vonaka/shreplic/state"
+)
+
+// Replicated masters.
+//
+// Several masters can share the role (-masters). They form a Paxos
+// group (see package paxos) whose leader is the primary master: only
+// the primary registers the replicas and watches them. After each
+// change, the primary proposes its whole state to the group, and the
+// other masters answer the requests of the clients with the last state
+// committed. The states are proposed one at a time, the changes made in
+// the meantime being proposed together, and the Paxos log forgets the
+// states that have been overwritten (see paxos.CompactLog). If the primary fails, the failure detector of its Paxos
+// replica elects the next master, which takes over the watch. The Paxos
+// replicas of the masters listen on the port of the master + 1000.
+
+var (
+	masters  = flag.String("masters", "", "Comma-separated addresses of all the masters, this one included")
+	masterId = flag.Int("mid", 0, "Index of this master in the list of masters")
+)
+
+var ProposeTimeout = 5 * time.Second
+
+var errTimeout = errors.New("proposal timed out")
+
+// the key under which the state of the master is stored
+const stateKey = state.Key(0)
+
+// masterState is the part of the state of the master that is
+// replicated.
+type masterState struct {
+	NodeList    []string
+	AddrList    []string
+	PortList    []int
+	Tokens      []string
+	Leader      []bool
+	Alive       []bool
+	Latencies   []float64
+	Info        []defs.ReplicaInfo
+	NextLeader  int
+	FirstLeader int
+	FinishInit  bool
+	Replaceable []bool
+	Epoch       int32
+}
+
+type group struct {
+	rep     *paxos.Replica
+	m       sync.Mutex
+	seq     int32
+	w       *bufio.Writer
+	replies chan *smr.ProposeReplyTS
+	// last state committed by this master
+	last []byte
+	// version of the last state proposed
+	version int64
+}
+
+// newGroup starts the Paxos replica of master id.
+func newGroup(addrs []string, id int) *group {
+	paxosAddrs := make([]string, len(addrs))
+	for i, a := range addrs {
+		host, port, err := net.SplitHostPort(a)
+		if err != nil {
+			log.Fatal("Master: ", err)
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			log.Fatal("Master: ", err)
+		}
+		paxosAddrs[i] = fmt.Sprintf("%s:%d", host, p+1000)
+	}
+
+	smr.StoreFilname = "master_store"
+	smr.FailureDetection = true
+	// only the last state proposed matters
+	paxos.CompactLog = true
+	g := &group{
+		rep: paxos.NewReplica(id, paxosAddrs, id == 0, false, true,
+			false, true, false, 0, (len(addrs)-1)/2, "", nil),
+		replies: make(chan *smr.ProposeReplyTS, 8),
+	}
+
+	// the replies to the proposals of the master go through a pipe
+	pr, pw := io.Pipe()
+	g.w = bufio.NewWriter(pw)
+	g.rep.AddClient(g.w, pw)
+	go func() {
+		r := bufio.NewReader(pr)
+		for {
+			reply := &smr.ProposeReplyTS{}
+			if err := reply.Unmarshal(r); err != nil {
+				log.Fatal("Master: ", err)
+			}
+			g.replies <- reply
+		}
+	}()
+	return g
+}
+
+// isPrimary returns true if the master is alone or is the
+// leader of the group.
+func (g *group) isPrimary() bool {
+	return g == nil || g.rep.Leading()
+}
+
+// propose proposes the state value to the group. It does nothing if
+// value is already committed or if a later version has been proposed.
+func (g *group) propose(value []byte, version int64) error {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if version <= g.version || bytes.Equal(value, g.last) {
+		return nil
+	}
+	g.version = version
+	g.seq++
+	g.rep.ProposeChan <- &smr.GPropose{
+		Propose: &smr.Propose{
+			CommandId: g.seq,
+			ClientId:  -1 - g.rep.Id,
+			Command: state.Command{
+				Op: state.PUT,
+				K:  stateKey,
+				V:  value,
+			},
+			Timestamp: time.Now().UnixNano(),
+		},
+		Reply: g.w,
+		Mutex: new(sync.Mutex),
+	}
+
+	timeout := time.After(ProposeTimeout)
+	for {
+		select {
+		case reply := <-g.replies:
+			if reply.CommandId != g.seq {
+				continue
+			}
+			if reply.OK != smr.TRUE {
+				return defs.ErrNotPrimary
+			}
+			g.last = value
+			return nil
+		case <-timeout:
+			return errTimeout
+		}
+	}
+}
+
+// read returns the last state committed.
+func (g *group) read() []byte {
+	get := state.Command{
+		Op: state.GET,
+		K:  stateKey,
+	}
+	return get.Execute(g.rep.State)
+}
+
+// state returns the replicated state of the master. It must be called
+// with master.lock held.
+func (master *Master) state() ([]byte, error) {
+	return json.Marshal(&masterState{
+		NodeList:    master.nodeList,
+		AddrList:    master.addrList,
+		PortList:    master.portList,
+		Tokens:      master.tokens,
+		Leader:      master.leader,
+		Alive:       master.alive,
+		Latencies:   master.latencies,
+		Info:        master.info,
+		NextLeader:  master.nextLeader,
+		FirstLeader: master.firstLeader,
+		FinishInit:  master.finishInit,
+		Replaceable: master.replaceable,
+		Epoch:       master.epoch,
+	})
+}
+
+// apply replaces the state of the master by value. It must be called
+// with master.lock held.
+func (master *Master) apply(value []byte) error {
+	var s masterState
+	if err := json.Unmarshal(value, &s); err != nil {
+		return err
+	}
+	if len(s.NodeList) > master.N || len(s.Leader) != master.N {
+		return fmt.Errorf("the state is for %d replicas instead of %d",
+			len(s.Leader), master.N)
+	}
+	if len(s.AddrList) != len(s.NodeList) || len(s.PortList) != len(s.NodeList) ||
+		len(s.Tokens) != len(s.NodeList) {
+		return fmt.Errorf("the state lists %d replicas, but %d addresses, %d ports and %d tokens",
+			len(s.NodeList), len(s.AddrList), len(s.PortList), len(s.Tokens))
+	}
+
+	master.nodeList = append(master.nodeList[:0], s.NodeList...)
+	master.addrList = append(master.addrList[:0], s.AddrList...)
+	master.portList = append(master.portList[:0], s.PortList...)
+	master.tokens = append(master.tokens[:0], s.Tokens...)
+	copy(master.leader, s.Leader)
+	copy(master.alive, s.Alive)
+	copy(master.latencies, s.Latencies)
+	copy(master.info, s.Info)
+	copy(master.replaceable, s.Replaceable)
+	master.nextLeader = s.NextLeader
+	master.firstLeader = s.FirstLeader
+	master.epoch = s.Epoch
+	if s.FinishInit && !master.finishInit {
+		master.finishInit = true
+		master.initCond.Broadcast()
+	}
+	return nil
+}
+
+// replicate records the state of the master and returns its version.
+// The state is proposed to the group in the background (see replicator),
+// and synced waits until it is. It must be called with master.lock
+// held, which it does not release, so that the operations of the master
+// do not interleave.
+func (master *Master) replicate() int64 {
+	master.updateEpoch()
+	value, err := master.state()
+	if err != nil {
+		log.Println("Cannot replicate the state of the master:", err)
+		return master.replicated
+	}
+	master.store(value)
+
+	if master.group == nil || bytes.Equal(value, master.pending) {
+		return master.version
+	}
+	master.version++
+	master.pending = value
+	select {
+	case master.replicating <- struct{}{}:
+	default:
+	}
+	return master.version
+}
+
+// replicator proposes the last state recorded by replicate to the
+// group. The states recorded while a proposal is pending are thus
+// proposed as one.
+func (master *Master) replicator() {
+	for range master.replicating {
+		master.lock.Lock()
+		value, version := master.pending, master.version
+		master.lock.Unlock()
+
+		err := master.group.propose(value, version)
+		if err != nil {
+			log.Println("Cannot replicate the state of the master:", err)
+		}
+
+		master.lock.Lock()
+		master.replicated = version
+		master.replicateErr = err
+		master.replicatedCond.Broadcast()
+		master.lock.Unlock()
+	}
+}
+
+// synced waits until the state of the given version, or a later one,
+// has been proposed to the group, and returns the error of the
+// proposal. It must be called without master.lock.
+func (master *Master) synced(version int64) error {
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	for master.replicated < version {
+		master.replicatedCond.Wait()
+	}
+	return master.replicateErr
+}
+
+// load loads the last state committed if the master is not the
+// primary one.
+func (master *Master) load() {
+	g := master.group
+	if g.isPrimary() {
+		return
+	}
+	value := g.read()
+	if len(value) == 0 {
+		return
+	}
+
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	if err := master.apply(value); err != nil {
+		log.Println("Cannot load the state of the master:", err)
+		return
+	}
+	g.m.Lock()
+	g.last = value
+	g.m.Unlock()
+}
+
+// follow loads the state committed by the primary master
+// until this master becomes the primary one.
+func (master *Master) follow() {
+	for !master.group.isPrimary() {
+		master.load()
+		time.Sleep(500 * time.Millisecond)
+	}
+	if master.group != nil {
+		log.Println("This master is the primary one")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Persistent state.
+//
+// With -store, the master writes its state (see masterState) to a file
+// each time it changes, and reads it back when it starts. A restarted
+// master thus knows the replicas registered before, and watches them as
+// a new primary master would.
+
+var storeFile = flag.String("store", "", "File in which the master keeps its state across restarts")
+
+// store writes value to the store file if it has changed. It must be
+// called with master.lock held.
+func (master *Master) store(value []byte) {
+	if *storeFile == "" || bytes.Equal(value, master.stored) {
+		return
+	}
+	tmp := *storeFile + ".tmp"
+	err := ioutil.WriteFile(tmp, value, 0644)
+	if err == nil {
+		err = os.Rename(tmp, *storeFile)
+	}
+	if err != nil {
+		log.Println("Cannot store the state of the master:", err)
+		return
+	}
+	master.stored = value
+}
+
+// restore loads the state stored by a previous run of the master.
+func (master *Master) restore() error {
+	if *storeFile == "" {
+		return nil
+	}
+	value, err := ioutil.ReadFile(*storeFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	master.lock.Lock()
+	defer master.lock.Unlock()
+
+	if err := master.apply(value); err != nil {
+		return err
+	}
+	master.stored = value
+	log.Printf("Restored %d replicas from %s", len(master.nodeList), *storeFile)
+	return nil
+}
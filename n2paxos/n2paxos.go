@@ -5,6 +5,7 @@ import (
 	"log"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/orcaman/concurrent-map"
@@ -28,12 +29,14 @@ type Replica struct {
 	proposes  cmap.ConcurrentMap
 	cmdDescs  cmap.ConcurrentMap
 	delivered cmap.ConcurrentMap
+	// every slot up to executedUpTo is delivered
+	executedUpTo int32
 
 	sender  smr.Sender
 	batcher *Batcher
 	history []commandStaticDesc
 
-	AQ smr.Quorum
+	AQ smr.QuorumI
 	qs smr.QuorumSet
 	cs CommunicationSupply
 
@@ -67,12 +70,12 @@ type commandStaticDesc struct {
 	cmd     state.Command
 }
 
-func NewReplica(rid int, addrs []string, exec, dr, optExec bool,
+func NewReplica(rid int, addrs []string, exec, lread, dr, optExec bool,
 	pl, f int, qfile string, ps map[string]struct{}) *Replica {
 	cmap.SHARD_COUNT = 32768
 
 	r := &Replica{
-		Replica: smr.NewReplica(rid, f, addrs, false, exec, false, dr, ps),
+		Replica: smr.NewReplica(rid, f, addrs, false, exec, lread, dr, ps),
 
 		ballot:  0,
 		cballot: 0,
@@ -81,6 +84,8 @@ func NewReplica(rid int, addrs []string, exec, dr, optExec bool,
 		isLeader:    false,
 		lastCmdSlot: 0,
 
+		executedUpTo: -1,
+
 		slots:     cmap.New(),
 		proposes:  cmap.New(),
 		cmdDescs:  cmap.New(),
@@ -100,6 +105,7 @@ func NewReplica(rid int, addrs []string, exec, dr, optExec bool,
 		},
 	}
 
+	r.Protocol = "n2paxos"
 	r.sender = smr.NewSender(r.Replica)
 	r.batcher = NewBatcher(r, 16)
 	r.qs = smr.NewQuorumSet(r.N/2+1, r.N)
@@ -110,12 +116,43 @@ func NewReplica(rid int, addrs []string, exec, dr, optExec bool,
 		r.ballot = leaderIds[0]
 		r.cballot = leaderIds[0]
 		r.isLeader = (leaderIds[0] == r.Id)
-	} else if err == smr.NO_QUORUM_FILE {
+	} else if err == nil || err == smr.NO_QUORUM_FILE {
 		r.AQ = r.qs.AQ(r.ballot)
 		r.isLeader = (r.ballot == r.Id)
 	} else {
 		log.Fatal(err)
 	}
+	if q, err := smr.QuorumFromFile(qfile, r.Replica); err != nil &&
+		err != smr.NO_QUORUM_FILE {
+		log.Fatal(err)
+	} else if q != nil {
+		r.AQ = q
+		r.SetQuorum(q)
+	}
+
+	if lread {
+		r.ReadChan = make(chan *smr.GPropose, smr.MaxPendingProposals)
+		r.EnableReadIndex(smr.ReadIndexer{
+			Leader: func() int32 {
+				return smr.Leader(r.ballot, r.N)
+			},
+			Ballot: func() int32 {
+				return r.ballot
+			},
+			Ready: func() bool {
+				return true
+			},
+			// every command committed so far
+			// occupies a slot before lastCmdSlot
+			Index: func() int32 {
+				return int32(r.lastCmdSlot - 1)
+			},
+			// called outside of the run loop
+			Executed: func(i int32) bool {
+				return atomic.LoadInt32(&r.executedUpTo) >= i
+			},
+		})
+	}
 
 	initCs(&r.cs, r.RPC)
 
@@ -136,6 +173,11 @@ func NewReplica(rid int, addrs []string, exec, dr, optExec bool,
 	return r
 }
 
+func (r *Replica) GetLeader(_ *smr.GetLeaderArgs, reply *smr.GetLeaderReply) error {
+	reply.Leader = smr.Leader(r.ballot, r.N)
+	return nil
+}
+
 func (r *Replica) run() {
 	r.ConnectToPeers()
 	latencies := r.ComputeClosestPeers()
@@ -146,6 +188,12 @@ func (r *Replica) run() {
 		}
 	}
 
+	var renewals <-chan time.Time
+	if r.ReadChan != nil {
+		renewals = r.LeaseRenewals()
+		defer r.StopLeaseRenewals()
+	}
+
 	go r.WaitForClientConnections()
 
 	var cmdId CommandId
@@ -172,6 +220,17 @@ func (r *Replica) run() {
 				}
 			}
 
+		case read := <-r.ReadChan:
+			r.ServeRead(read)
+
+		case m := <-r.ReadIndexChan:
+			r.HandleReadIndex(m)
+
+		case <-renewals:
+			if r.isLeader {
+				r.RenewLease(r.ballot)
+			}
+
 		case m := <-r.cs.twoAChan:
 			twoA := m.(*M2A)
 			r.getCmdDesc(twoA.CmdSlot, twoA)
@@ -222,6 +281,8 @@ func (r *Replica) handle2A(msg *M2A, desc *commandDesc) {
 		return
 	}
 
+	r.ObserveBallot(msg.Ballot)
+
 	desc.cmd = msg.Cmd
 	desc.cmdId = msg.CmdId
 	desc.cmdSlot = msg.CmdSlot
@@ -284,6 +345,11 @@ func (r *Replica) deliver(desc *commandDesc, slot int) {
 		r.delivered.Set(strconv.Itoa(slot), struct{}{})
 		dlog.Printf("Executing " + desc.cmd.String())
 		v := desc.cmd.Execute(r.State)
+		// slots are delivered in order, but a read must never
+		// see a gap, hence executedUpTo only grows by one
+		if atomic.CompareAndSwapInt32(&r.executedUpTo, int32(slot-1), int32(slot)) {
+			r.ExecutedUpTo(int32(slot))
+		}
 		go func(nextSlot int) {
 			r.deliverChan <- nextSlot
 		}(slot + 1)
@@ -0,0 +1,15 @@
+package n2paxos
+
+import "github.com/vonaka/shreplic/server/smr"
+
+func init() {
+	smr.RegisterProtocol(&smr.Protocol{
+		Name:  "n2paxos",
+		Flags: []string{"lread", "lease", "drift", "optexec", "qfile", "desc", "pool"},
+		NewReplica: func(o *smr.Options) interface{} {
+			MaxDescRoutines = o.Desc
+			return NewReplica(o.Id, o.Addrs, o.Exec, o.LRead, o.Dreply,
+				o.OptExec, o.Pool, o.F, o.QFile, o.Proxies)
+		},
+	})
+}
@@ -13,15 +13,15 @@ import (
 type Client struct {
 	*base.SimpleClient
 
-	val       state.Value
 	ready     chan struct{}
 	ballot    int32
 	delivered map[CommandId]struct{}
 
-	SQ        smr.QuorumI
-	FQ        smr.QuorumI
-	slowPathH *smr.MsgSet
-	fastPathH *smr.MsgSet
+	SQ smr.QuorumI
+	FQ smr.QuorumI
+	// the acks of the commands in flight,
+	// more than one if the client is pipelined
+	cmds map[CommandId]*cmdAcks
 
 	fixedMajority bool
 
@@ -31,6 +31,12 @@ type Client struct {
 	cs CommunicationSupply
 }
 
+type cmdAcks struct {
+	slowPathH *smr.MsgSet
+	fastPathH *smr.MsgSet
+	val       state.Value
+}
+
 func NewClient(maddr, collocated string, mport, reqNum, writes, psize, conflict int,
 	fast, lread, leaderless, verbose bool, logger *log.Logger, args string) *Client {
 
@@ -38,23 +44,30 @@ func NewClient(maddr, collocated string, mport, reqNum, writes, psize, conflict
 	f := flag.NewFlagSet("custom Paxoi arguments", flag.ExitOnError)
 	repNum := f.Int("N", -1, "Number of replicas")
 	f.Int("pclients", 0, "Number of clients already running on other machines")
+	qfile := f.String("qfile", "", "Quorum file of the replicas (only with a cluster file)")
 	f.Parse(strings.Fields(args))
 	if *repNum == -1 {
 		f.Usage()
 		return nil
 	}
 
+	q, err := base.GetQuorum(maddr, mport, *qfile, logger)
+	if err != nil {
+		log.Println("Cannot get the quorums of the replicas:", err)
+		return nil
+	}
+
 	c := &Client{
 		SimpleClient: base.NewSimpleClient(maddr, collocated, mport, reqNum, writes,
 			psize, conflict, fast, lread, leaderless, verbose, logger),
 
-		val:       nil,
 		ready:     make(chan struct{}, 1),
 		ballot:    -1,
 		delivered: make(map[CommandId]struct{}),
 
-		SQ: smr.NewMajorityOf(*repNum),
-		FQ: smr.NewThreeQuartersOf(*repNum),
+		SQ:   smr.NewMajorityOf(*repNum),
+		FQ:   smr.NewThreeQuartersOf(*repNum),
+		cmds: make(map[CommandId]*cmdAcks),
 
 		fixedMajority: true,
 
@@ -63,17 +76,22 @@ func NewClient(maddr, collocated string, mport, reqNum, writes, psize, conflict
 	}
 
 	c.ReadTable = true
+	c.Protocol = "paxoi"
 	c.WaitResponse = func() error {
 		<-c.ready
 		return nil
 	}
 
+	if q != nil {
+		c.SQ = q
+		c.FQ = smr.FastQuorumOf(q, *repNum)
+	}
 	if c.fixedMajority {
-		c.FQ = smr.NewMajorityOf(*repNum)
+		// the fast quorums are the active quorums, which are slow quorums
+		c.FQ = c.SQ
 	}
 
 	initCs(&c.cs, c.RPC)
-	c.reinitFastAndSlowAcks()
 
 	c.Println("SQ:", c.SQ)
 	c.Println("FQ:", c.FQ)
@@ -83,7 +101,18 @@ func NewClient(maddr, collocated string, mport, reqNum, writes, psize, conflict
 	return c
 }
 
-func (c *Client) reinitFastAndSlowAcks() {
+// getAcks returns the acks of command cmdId.
+func (c *Client) getAcks(cmdId CommandId) *cmdAcks {
+	a, exists := c.cmds[cmdId]
+	if !exists {
+		a = &cmdAcks{}
+		c.reinitFastAndSlowAcks(a)
+		c.cmds[cmdId] = a
+	}
+	return a
+}
+
+func (c *Client) reinitFastAndSlowAcks(a *cmdAcks) {
 	accept := func(msg, leaderMsg interface{}) bool {
 		if leaderMsg == nil {
 			return true
@@ -100,10 +129,10 @@ func (c *Client) reinitFastAndSlowAcks() {
 		}
 	}
 
-	c.slowPathH.Free()
-	c.slowPathH = c.slowPathH.ReinitMsgSet(c.SQ, accept, free, c.handleFastAndSlowAcks)
-	c.fastPathH.Free()
-	c.fastPathH = c.fastPathH.ReinitMsgSet(c.FQ, accept, free, c.handleFastAndSlowAcks)
+	a.slowPathH.Free()
+	a.slowPathH = a.slowPathH.ReinitMsgSet(c.SQ, accept, free, c.handleFastAndSlowAcks)
+	a.fastPathH.Free()
+	a.fastPathH = a.fastPathH.ReinitMsgSet(c.FQ, accept, free, c.handleFastAndSlowAcks)
 }
 
 func (c *Client) handleMsgs() {
@@ -111,6 +140,7 @@ func (c *Client) handleMsgs() {
 		select {
 		case m := <-c.cs.replyChan:
 			reply := m.(*MReply)
+			c.Refresh(reply.Epoch)
 			c.handleReply(reply)
 
 		/*case m := <-c.cs.readReplyChan:
@@ -123,6 +153,7 @@ func (c *Client) handleMsgs() {
 
 		case m := <-c.cs.fastAckChan:
 			fastAck := m.(*MFastAck)
+			c.Refresh(fastAck.Epoch)
 			c.handleFastAck(fastAck, false)
 
 		case m := <-c.cs.lightSlowAckChan:
@@ -132,6 +163,7 @@ func (c *Client) handleMsgs() {
 		case m := <-c.cs.acksChan:
 			acks := m.(*MAcks)
 			for _, f := range acks.FastAcks {
+				c.Refresh(f.Epoch)
 				c.handleFastAck(copyFastAck(&f), false)
 			}
 			for _, s := range acks.LightSlowAcks {
@@ -162,7 +194,7 @@ func (c *Client) handleMsgs() {
 				if _, exists := c.delivered[fastAck.CmdId]; !exists && fastAck.Checksum == nil {
 					//c.Println("got slowAck", fastAck.Replica, fastAck.CmdId)
 					//c.Println("wanna add", fastAck.Replica, fastAck.CmdId, false)
-					c.slowPathH.Add(fastAck.Replica, false, fastAck)
+					c.getAcks(fastAck.CmdId).slowPathH.Add(fastAck.Replica, false, fastAck)
 				}
 			}
 		}
@@ -174,7 +206,9 @@ func (c *Client) handleFastAck(f *MFastAck, fromLeader bool) bool {
 		c.ballot = f.Ballot
 	} else if c.ballot < f.Ballot {
 		c.ballot = f.Ballot
-		c.reinitFastAndSlowAcks()
+		for _, a := range c.cmds {
+			c.reinitFastAndSlowAcks(a)
+		}
 	} else if c.ballot > f.Ballot {
 		return false
 	}
@@ -184,7 +218,7 @@ func (c *Client) handleFastAck(f *MFastAck, fromLeader bool) bool {
 	}
 
 	//c.Println("got fastAck", f.Replica, f.CmdId, f.Checksum)
-	c.fastPathH.Add(f.Replica, fromLeader, f)
+	c.getAcks(f.CmdId).fastPathH.Add(f.Replica, fromLeader, f)
 	return true
 }
 
@@ -207,7 +241,7 @@ func (c *Client) handleLightSlowAck(ls *MLightSlowAck) {
 	if _, exists := c.delivered[f.CmdId]; !exists {
 		//c.Println("wanna add", f.Replica, f.CmdId, false)
 		//c.Println("got slowAck", f.Replica, f.CmdId)
-		c.slowPathH.Add(f.Replica, false, f)
+		c.getAcks(f.CmdId).slowPathH.Add(f.Replica, false, f)
 	}
 	// if c.handleFastAck(f, false) {
 	// 	c.slowPathH.Add(f.Replica, false, f)
@@ -223,13 +257,7 @@ func (c *Client) handleFastAndSlowAcks(leaderMsg interface{}, msgs []interface{}
 	if _, exists := c.delivered[cmdId]; exists {
 		return
 	}
-	c.delivered[cmdId] = struct{}{}
-
-	c.Println("Slow Paths:", c.slowPaths)
-	c.Println("Returning:", c.val.String())
-	c.reinitFastAndSlowAcks()
-	c.ResChan <- c.val
-	c.ready <- struct{}{}
+	c.deliver(cmdId, c.cmds[cmdId].val)
 }
 
 func (c *Client) handleReply(r *MReply) {
@@ -241,12 +269,12 @@ func (c *Client) handleReply(r *MReply) {
 	f.Ballot = r.Ballot
 	f.CmdId = r.CmdId
 	f.Checksum = r.Checksum
-	c.val = r.Rep
+	c.getAcks(r.CmdId).val = r.Rep
 	c.handleFastAck(f, true)
 	if _, exists := c.delivered[f.CmdId]; !exists {
 		//c.Println("wanna add", f.Replica, f.CmdId, true)
 		//c.Println("got Reply", r.Replica, r.CmdId)
-		c.slowPathH.Add(f.Replica, true, f)
+		c.getAcks(f.CmdId).slowPathH.Add(f.Replica, true, f)
 	}
 	// if c.handleFastAck(f, true) {
 	// 	c.slowPathH.Add(f.Replica, true, f)
@@ -257,16 +285,24 @@ func (c *Client) handleAccept(a *MAccept) {
 	if _, exists := c.delivered[a.CmdId]; exists {
 		return
 	}
-	c.delivered[a.CmdId] = struct{}{}
-
 	//c.Println("got Accept", a.Replica, a.CmdId)
 
-	c.val = a.Rep
+	c.deliver(a.CmdId, a.Rep)
+}
+
+func (c *Client) deliver(cmdId CommandId, val state.Value) {
+	c.delivered[cmdId] = struct{}{}
+	if a, exists := c.cmds[cmdId]; exists {
+		delete(c.cmds, cmdId)
+		a.slowPathH.Free()
+		a.fastPathH.Free()
+	}
 	c.Println("Slow Paths:", c.slowPaths)
-	c.Println("Returning:", c.val.String())
-	c.reinitFastAndSlowAcks()
-	c.ResChan <- c.val
-	c.ready <- struct{}{}
+	c.Println("Returning:", val.String())
+	if !c.Deliver(cmdId.SeqNum, val) {
+		c.ResChan <- val
+		c.ready <- struct{}{}
+	}
 }
 
 // func (c *Client) handleReadReply(r *MReadReply) {
@@ -65,6 +65,28 @@ func (cmdId CommandId) String() string {
 	return fmt.Sprintf("%v,%v", cmdId.ClientId, cmdId.SeqNum)
 }
 
+// the answers to the clients (see smr.Answer)
+
+func (m *MFastAck) AnsweredCommand() int32 {
+	return m.CmdId.SeqNum
+}
+
+func (m *MLightSlowAck) AnsweredCommand() int32 {
+	return m.CmdId.SeqNum
+}
+
+func (m *MReply) AnsweredCommand() int32 {
+	return m.CmdId.SeqNum
+}
+
+func (m *MAccept) AnsweredCommand() int32 {
+	return m.CmdId.SeqNum
+}
+
+func (m *MReadReply) AnsweredCommand() int32 {
+	return m.CmdId.SeqNum
+}
+
 type Dep []CommandId
 
 func (d Dep) Contains(cmdId CommandId) bool {
@@ -1,6 +1,7 @@
 package paxoi
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -56,6 +57,7 @@ type Replica struct {
 	//dl            *DelayLog
 	//recNum        int
 	recover        chan int32
+	installs       chan *quorumsInstall
 	recStart       time.Time
 	newLeaderAckNs *smr.MsgSet
 
@@ -63,6 +65,13 @@ type Replica struct {
 	proposes map[CommandId]*smr.GPropose
 }
 
+type quorumsInstall struct {
+	AQs     []smr.Quorum
+	leaders []int32
+	done    chan error
+	ballot  int32
+}
+
 type commandDesc struct {
 	phase      int
 	cmd        state.Command
@@ -136,7 +145,8 @@ func NewReplica(rid int, addrs []string, exec, fastRead, dr, optExec, AQreconf b
 		routineCount: 0,
 
 		//recNum:  0,
-		recover: make(chan int32, 8),
+		recover:  make(chan int32, 8),
+		installs: make(chan *quorumsInstall),
 
 		descPool: sync.Pool{
 			New: func() interface{} {
@@ -147,6 +157,7 @@ func NewReplica(rid int, addrs []string, exec, fastRead, dr, optExec, AQreconf b
 		proposes: make(map[CommandId]*smr.GPropose),
 	}
 
+	r.Protocol = "paxoi"
 	useFastAckPool = pl > 1
 
 	r.SQ = smr.NewMajorityOf(r.N)
@@ -160,6 +171,14 @@ func NewReplica(rid int, addrs []string, exec, fastRead, dr, optExec, AQreconf b
 	if err != nil && err != smr.THREE_QUARTERS {
 		log.Fatal(err)
 	}
+	// the clients count the slow acks themselves
+	if q, err := smr.QuorumFromFile(qfile, r.Replica); err != nil &&
+		err != smr.NO_QUORUM_FILE {
+		log.Fatal(err)
+	} else if q != nil {
+		r.SQ = q
+		r.FQ = smr.FastQuorumOf(q, r.N)
+	}
 	r.qs = qs
 	r.ballot = r.qs.BallotAt(0)
 	if r.ballot == -1 {
@@ -170,6 +189,9 @@ func NewReplica(rid int, addrs []string, exec, fastRead, dr, optExec, AQreconf b
 	if err != smr.THREE_QUARTERS {
 		r.fixedMajority = true
 		r.FQ = r.qs.AQ(r.ballot)
+		if !r.isSlowQuorum(r.qs.AQ(r.ballot)) {
+			log.Fatalf("active quorum %v is not a quorum of %v", r.FQ, r.SQ)
+		}
 	}
 	//r.gc = NewGc(r)
 	//if AQreconf {
@@ -224,6 +246,46 @@ func (r *Replica) BeTheLeader(_ *smr.BeTheLeaderArgs, reply *smr.BeTheLeaderRepl
 	return nil
 }
 
+// InstallQuorums installs new active quorums. The leader of the first
+// of them starts the ballot of this quorum, the other replicas only
+// learn which quorums are preferred.
+func (r *Replica) InstallQuorums(args *smr.InstallQuorumsArgs, reply *smr.InstallQuorumsReply) error {
+	AQs, leaders, err := r.ParseQuorums("quorums", args.Content)
+	if err != nil && err != smr.THREE_QUARTERS {
+		return err
+	}
+	if (err == smr.THREE_QUARTERS) == r.fixedMajority {
+		return errors.New("fast quorums cannot be changed at runtime")
+	}
+	if q, err := r.ParseQuorum("quorums", args.Content); err != nil {
+		return err
+	} else if q != nil && fmt.Sprint(q) != fmt.Sprint(r.SQ) {
+		return errors.New("slow quorums cannot be changed at runtime")
+	}
+	if len(AQs) == 0 {
+		return errors.New("no active quorum is given")
+	}
+	for _, AQ := range AQs {
+		if r.fixedMajority && !r.isSlowQuorum(AQ) {
+			return fmt.Errorf("active quorum %v is not a quorum of %v", AQ, r.SQ)
+		}
+	}
+
+	in := &quorumsInstall{
+		AQs:     AQs,
+		leaders: leaders,
+		done:    make(chan error, 1),
+		ballot:  -1,
+	}
+	r.installs <- in
+	if err := <-in.done; err != nil {
+		return err
+	}
+	reply.Leader = leaders[0]
+	reply.Ballot = in.ballot
+	return nil
+}
+
 func (r *Replica) run() {
 	r.ConnectToPeers()
 	latencies := r.ComputeClosestPeers()
@@ -237,6 +299,7 @@ func (r *Replica) run() {
 	go r.WaitForClientConnections()
 
 	var cmdId CommandId
+	failures := r.SubscribeFailures()
 	//var swapChan chan SwapValue
 
 	// if r.dl != nil {
@@ -262,32 +325,28 @@ func (r *Replica) run() {
 		// 	r.recover <- r.qs.BallotOf(r.Id, newAQ)
 
 		case newBallot := <-r.recover:
-			newLeader := &MNewLeader{
-				Replica: r.Id,
-				Ballot:  r.ballot,
+			r.startRecovery(r.recoveryBallot(newBallot))
+
+		case e := <-failures:
+			if e.Suspected && e.Peer == r.leader() && r.Successor(e.Peer) == r.Id {
+				log.Printf("Leader %d is suspected (phi %.1f)", e.Peer, e.Phi)
+				r.startRecovery(r.recoveryBallot(-1))
 			}
-			if newBallot != -1 {
-				if newBallot > newLeader.Ballot {
-					newLeader.Ballot = newBallot
-				} else {
-					newLeader.Ballot = r.qs.SameHigher(newBallot, newLeader.Ballot)
-				}
-			} else {
-				newLeader.Ballot = smr.NextBallotOf(r.Id, newLeader.Ballot, r.N)
+
+		case in := <-r.installs:
+			qs, err := r.qs.WithActiveQuorums(in.AQs, in.leaders)
+			if err != nil {
+				in.done <- err
+				continue
 			}
-			for quorumIsAlive := false; r.fixedMajority && !quorumIsAlive; {
-				quorumIsAlive = true
-				for rid := range r.qs.AQ(newLeader.Ballot) {
-					if rid != r.Id && !r.Alive[rid] {
-						newLeader.Ballot = smr.NextBallotOf(r.Id, newLeader.Ballot, r.N)
-						quorumIsAlive = false
-						break
-					}
-				}
+			r.qs = qs
+			if smr.Leader(qs.BallotAt(0), r.N) != r.Id {
+				in.done <- nil
+				continue
 			}
-			r.sender.SendToAll(newLeader, r.cs.newLeaderRPC)
-			r.reinitNewLeaderAckNs()
-			r.handleNewLeader(newLeader)
+			in.ballot = r.recoveryBallot(qs.BallotAt(0))
+			in.done <- nil
+			r.startRecovery(in.ballot)
 
 		case cmdId := <-r.deliverChan:
 			if rDesc, exists := r.reads[cmdId]; exists {
@@ -381,6 +440,55 @@ func (r *Replica) run() {
 	}
 }
 
+// isSlowQuorum returns true if the active quorum AQ is a slow quorum,
+// so that it can be the fast quorum of its ballot.
+func (r *Replica) isSlowQuorum(AQ smr.Quorum) bool {
+	ids := make([]int32, 0, len(AQ))
+	for rid := range AQ {
+		ids = append(ids, rid)
+	}
+	return smr.IsQuorum(r.SQ, ids)
+}
+
+// recoveryBallot returns the ballot at which the replica becomes the
+// leader, newBallot if possible (-1 for any ballot).
+func (r *Replica) recoveryBallot(newBallot int32) int32 {
+	ballot := r.ballot
+	if newBallot != -1 {
+		if newBallot > ballot {
+			ballot = newBallot
+		} else {
+			ballot = r.qs.SameHigher(newBallot, ballot)
+		}
+	} else {
+		ballot = smr.NextBallotOf(r.Id, ballot, r.N)
+	}
+	for quorumIsAlive := false; r.fixedMajority && !quorumIsAlive; {
+		// the active quorum is the fast quorum of the ballot
+		quorumIsAlive = r.isSlowQuorum(r.qs.AQ(ballot))
+		for rid := range r.qs.AQ(ballot) {
+			if rid != r.Id && (!r.Alive[rid] || r.Suspected(rid)) {
+				quorumIsAlive = false
+				break
+			}
+		}
+		if !quorumIsAlive {
+			ballot = smr.NextBallotOf(r.Id, ballot, r.N)
+		}
+	}
+	return ballot
+}
+
+func (r *Replica) startRecovery(ballot int32) {
+	newLeader := &MNewLeader{
+		Replica: r.Id,
+		Ballot:  ballot,
+	}
+	r.sender.SendToAll(newLeader, r.cs.newLeaderRPC)
+	r.reinitNewLeaderAckNs()
+	r.handleNewLeader(newLeader)
+}
+
 func (r *Replica) handlePropose(msg *smr.GPropose, desc *commandDesc, cmdId CommandId) {
 	if r.status != NORMAL || desc.propose != nil {
 		return
@@ -410,6 +518,7 @@ func (r *Replica) handlePropose(msg *smr.GPropose, desc *commandDesc, cmdId Comm
 	fastAck.CmdId = cmdId
 	fastAck.Dep = desc.dep
 	fastAck.Checksum = desc.hs
+	fastAck.Epoch = r.Epoch()
 	//fmt.Println(cmdId, fastAck.Checksum)
 
 	fastAckSend := copyFastAck(fastAck)
@@ -957,6 +1066,11 @@ func (r *Replica) leader() int32 {
 	return smr.Leader(r.ballot, r.N)
 }
 
+func (r *Replica) GetLeader(_ *smr.GetLeaderArgs, reply *smr.GetLeaderReply) error {
+	reply.Leader = r.leader()
+	return nil
+}
+
 func (r *Replica) getDep(cmd state.Command) Dep {
 	dep := []CommandId{}
 	keysOfCmd := keysOf(cmd)
@@ -28,6 +28,7 @@ type MFastAck struct {
 	CmdId    CommandId
 	Dep      []CommandId
 	Checksum []SHash
+	Epoch    int32
 }
 
 type MSlowAck struct {
@@ -67,6 +68,7 @@ type MReply struct {
 	CmdId    CommandId
 	Checksum []SHash
 	Rep      []byte
+	Epoch    int32
 }
 
 type MAccept struct {
@@ -171,6 +173,7 @@ func copyFastAck(fa *MFastAck) *MFastAck {
 	fa2.CmdId = fa.CmdId
 	fa2.Dep = fa.Dep
 	fa2.Checksum = fa.Checksum
+	fa2.Epoch = fa.Epoch
 	return fa2
 }
 
@@ -179,7 +182,7 @@ func (m *MFastAck) New() fastrpc.Serializable {
 }
 
 func (m *MSlowAck) New() fastrpc.Serializable {
-	return (*MSlowAck)(newFastAck())
+	return new(MSlowAck)
 }
 
 func (m *MLightSlowAck) New() fastrpc.Serializable {
@@ -1592,6 +1595,13 @@ func (t *MFastAck) Marshal(wire io.Writer) {
 		bs[0] = byte(t.Checksum[i].H[31])
 		wire.Write(bs)
 	}
+	bs = b[:4]
+	tmp32 = t.Epoch
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
 }
 
 func (t *MFastAck) Unmarshal(rr io.Reader) error {
@@ -1762,6 +1772,11 @@ func (t *MFastAck) Unmarshal(rr io.Reader) error {
 		}
 		t.Checksum[i].H[31] = byte(bs[0])
 	}
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.Epoch = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	return nil
 }
 
@@ -2100,6 +2115,13 @@ func (t *MReply) Marshal(wire io.Writer) {
 		bs[0] = byte(t.Rep[i])
 		wire.Write(bs)
 	}
+	bs = b[:4]
+	tmp32 = t.Epoch
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
 }
 
 func (t *MReply) Unmarshal(rr io.Reader) error {
@@ -2265,6 +2287,11 @@ func (t *MReply) Unmarshal(rr io.Reader) error {
 		}
 		t.Rep[i] = byte(bs[0])
 	}
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.Epoch = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	return nil
 }
 
@@ -382,6 +382,7 @@ func (r *Replica) handleSync(msg *MSync) {
 				fastAck.Ballot = r.ballot
 				fastAck.CmdId = cmdId
 				fastAck.Dep = msg.Deps[cmdId]
+				fastAck.Epoch = r.Epoch()
 				r.batcher.SendFastAck(copyFastAck(fastAck))
 				if desc != nil {
 					defer r.handleFastAck(fastAck, desc)
@@ -391,6 +392,7 @@ func (r *Replica) handleSync(msg *MSync) {
 					Ballot:  r.ballot,
 					CmdId:   cmdId,
 					//Dep:     msg.Deps[cmdId],
+					Epoch:   r.Epoch(),
 				}
 				r.sender.SendToClient(propose.ClientId, reply, r.cs.replyRPC)
 			} else {
@@ -450,6 +452,5 @@ func (r *Replica) reinitNewLeaderAckNs() {
 		return true
 	}
 	free := func(_ interface{}) {}
-	Q := smr.NewMajorityOf(r.N)
-	r.newLeaderAckNs = r.newLeaderAckNs.ReinitMsgSet(Q, accept, free, r.handleNewLeaderAckNs)
+	r.newLeaderAckNs = r.newLeaderAckNs.ReinitMsgSet(r.SQ, accept, free, r.handleNewLeaderAckNs)
 }
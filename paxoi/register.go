@@ -0,0 +1,25 @@
+package paxoi
+
+import "github.com/vonaka/shreplic/server/smr"
+
+func init() {
+	smr.RegisterProtocol(&smr.Protocol{
+		Name: "paxoi",
+		Flags: []string{"lread", "optexec", "qfile", "desc", "pool", "AQreconf",
+			"fd", "heartbeat", "phi"},
+		NewReplica: func(o *smr.Options) interface{} {
+			MaxDescRoutines = o.Desc
+			return NewReplica(o.Id, o.Addrs, o.Exec, o.LRead, o.Dreply,
+				o.OptExec, o.AQReconf, o.Pool, o.F, o.QFile, o.Proxies)
+		},
+		NewClient: func(o *smr.ClientOptions) smr.ProtocolClient {
+			c := NewClient(o.MAddr, o.Collocated, o.MPort, o.Requests, o.Writes,
+				o.PSize, o.Conflicts, o.Fast, o.LRead, o.Leaderless, o.Verbose,
+				o.Logger, smr.ClientArgs(o.Args, o.N))
+			if c == nil {
+				return nil
+			}
+			return c
+		},
+	})
+}
@@ -53,6 +53,7 @@ func NewReplyChan(r *Replica) *replyChan {
 						Checksum: args.hs,
 						//Dep:     args.dep,
 						Rep:     args.val,
+						Epoch:   r.Epoch(),
 					}
 					r.sender.SendToClient(args.propose.ClientId, reply, r.cs.replyRPC)
 				} else if args.propose.Collocated && r.optExec {
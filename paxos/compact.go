@@ -0,0 +1,63 @@
+package paxos
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/vonaka/shreplic/state"
+)
+
+// Log compaction.
+//
+// With CompactLog, a replica forgets the values written by the executed
+// instances that a later executed instance overwrites. Their PUTs are
+// kept with an empty value, so that a replica that recovers them still
+// executes them before the instance that overwrites them. The replicated
+// masters use it, as each of their proposals is their whole state.
+
+var (
+	CompactLog      = false
+	CompactInterval = time.Second
+)
+
+// put is the position of a PUT in the log.
+type put struct {
+	instance int32
+	cmd      int
+}
+
+// compactLog must be called by the run loop.
+func (r *Replica) compactLog() {
+	executed := atomic.LoadInt32(&r.executedUpTo)
+	for i := r.compactedUpTo + 1; i <= executed; i++ {
+		// the instances before a state transfer are unknown
+		inst := r.instanceSpace[i]
+		if inst == nil {
+			continue
+		}
+		for j, c := range inst.cmds {
+			if c.Op != state.PUT {
+				continue
+			}
+			if p, exists := r.lastPuts[c.K]; exists {
+				r.forget(p)
+			}
+			r.lastPuts[c.K] = put{i, j}
+		}
+	}
+	r.compactedUpTo = executed
+}
+
+// forget replaces the value written by p with an empty one. The
+// commands of the instance are copied, as they can be shared with the
+// messages that carried them.
+func (r *Replica) forget(p put) {
+	inst := r.instanceSpace[p.instance]
+	cmds := append([]state.Command(nil), inst.cmds...)
+	cmds[p.cmd].V = nil
+	inst.cmds = cmds
+	if inst.lb != nil {
+		inst.lb.cmds = cmds
+		inst.lb.clientProposals = nil
+	}
+}
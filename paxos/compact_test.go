@@ -0,0 +1,57 @@
+package paxos
+
+import (
+	"testing"
+
+	"github.com/vonaka/shreplic/state"
+)
+
+func putCmd(k state.Key, v string) state.Command {
+	return state.Command{Op: state.PUT, K: k, V: state.Value(v)}
+}
+
+func TestCompactLog(t *testing.T) {
+	r := &Replica{
+		instanceSpace: make([]*Instance, 8),
+		compactedUpTo: -1,
+		lastPuts:      make(map[state.Key]put),
+	}
+	sent := []state.Command{putCmd(1, "a"), putCmd(2, "a")}
+	r.instanceSpace[0] = &Instance{cmds: sent, status: COMMITTED}
+	r.instanceSpace[1] = &Instance{cmds: []state.Command{putCmd(1, "b")}, status: COMMITTED}
+	// unknown after a state transfer
+	r.instanceSpace[2] = nil
+	r.instanceSpace[3] = &Instance{cmds: []state.Command{putCmd(1, "c")}, status: COMMITTED}
+	r.instanceSpace[4] = &Instance{cmds: []state.Command{putCmd(2, "b")}, status: COMMITTED}
+
+	r.executedUpTo = 3
+	r.compactLog()
+	for _, test := range []struct {
+		instance int32
+		cmd      int
+		v        string
+	}{
+		{0, 0, ""},
+		{0, 1, "a"},
+		{1, 0, ""},
+		{3, 0, "c"},
+		// not executed yet
+		{4, 0, "b"},
+	} {
+		if v := string(r.instanceSpace[test.instance].cmds[test.cmd].V); v != test.v {
+			t.Errorf("instance %d, command %d: %q, want %q", test.instance, test.cmd, v, test.v)
+		}
+	}
+	if string(sent[0].V) != "a" {
+		t.Error("commands shared with a message modified")
+	}
+
+	r.executedUpTo = 4
+	r.compactLog()
+	if r.instanceSpace[0].cmds[1].V != nil || string(r.instanceSpace[4].cmds[0].V) != "b" {
+		t.Errorf("instances %v and %v", r.instanceSpace[0].cmds, r.instanceSpace[4].cmds)
+	}
+	if r.compactedUpTo != 4 {
+		t.Errorf("compacted up to %d, want 4", r.compactedUpTo)
+	}
+}
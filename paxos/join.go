@@ -0,0 +1,65 @@
+package paxos
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+
+	"github.com/vonaka/shreplic/server/smr"
+)
+
+// A replica that replaces a dead one starts from the state of a live
+// peer, taken between the execution of two instances. It then executes
+// the instances that follow, and recovers those that it has missed
+// while joining.
+
+// stateRequest asks the execution loop for the state (reply) or for a
+// snapshot written to disk (snapshot).
+type stateRequest struct {
+	reply    *smr.StateReply
+	snapshot *smr.SnapshotReply
+	err      error
+	done     chan struct{}
+}
+
+// GetState returns the state of the replica and the last
+// instance executed.
+func (r *Replica) GetState(args *smr.StateArgs, reply *smr.StateReply) error {
+	if !r.Exec {
+		return errors.New("the replica does not execute commands")
+	}
+	req := &stateRequest{
+		reply: reply,
+		done:  make(chan struct{}),
+	}
+	r.stateRequests <- req
+	<-req.done
+	return nil
+}
+
+// Snapshot writes the state of the replica next to its stable store,
+// along with the last instance executed.
+func (r *Replica) Snapshot(args *smr.SnapshotArgs, reply *smr.SnapshotReply) error {
+	if !r.Exec {
+		return errors.New("the replica does not execute commands")
+	}
+	req := &stateRequest{
+		snapshot: reply,
+		done:     make(chan struct{}),
+	}
+	r.stateRequests <- req
+	<-req.done
+	return req.err
+}
+
+func (r *Replica) catchUp() {
+	executed, err := r.FetchState()
+	if err != nil {
+		log.Println("Cannot fetch the state of the replicas:", err)
+		return
+	}
+	atomic.StoreInt32(&r.executedUpTo, executed)
+	if r.crtInstance < executed {
+		r.crtInstance = executed
+	}
+}
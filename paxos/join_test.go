@@ -0,0 +1,66 @@
+package paxos
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/vonaka/shreplic/server/smr"
+	"github.com/vonaka/shreplic/state"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paxos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := smr.Storage
+	smr.Storage = dir
+	defer func() { smr.Storage = storage }()
+
+	r := &Replica{
+		Replica:       smr.NewReplica(0, 0, []string{""}, false, true, false, false, nil),
+		instanceSpace: make([]*Instance, 8),
+		crtInstance:   -1,
+		executedUpTo:  -1,
+		stateRequests: make(chan *stateRequest),
+	}
+	for i := int32(0); i < 3; i++ {
+		r.instanceSpace[i] = &Instance{
+			cmds:   []state.Command{putCmd(state.Key(i), "v")},
+			status: COMMITTED,
+		}
+	}
+	r.crtInstance = 2
+	// the execution loop runs until the end of the tests
+	go r.executeCommands()
+
+	// each instance puts a new key, so the snapshot of instance i has
+	// i+1 keys, whenever it is taken
+	for try := 0; try < 2; try++ {
+		reply := &smr.SnapshotReply{}
+		if err := r.Snapshot(new(smr.SnapshotArgs), reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Keys != int(reply.Position)+1 {
+			t.Errorf("%d keys at position %d", reply.Keys, reply.Position)
+		}
+		f, err := os.Open(reply.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := state.InitState().Restore(f)
+		f.Close()
+		if err != nil || n != reply.Keys {
+			t.Errorf("%d keys restored from %s, want %d (error %v)", n, reply.File, reply.Keys, err)
+		}
+	}
+}
+
+func TestSnapshotNoExec(t *testing.T) {
+	r := &Replica{Replica: &smr.Replica{}}
+	if err := r.Snapshot(new(smr.SnapshotArgs), new(smr.SnapshotReply)); err == nil {
+		t.Error("snapshot taken by a replica that does not execute commands")
+	}
+}
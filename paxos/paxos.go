@@ -5,6 +5,7 @@ import (
 	"io"
 	"log"
 	"math"
+	"sync/atomic"
 	"time"
 
 	"github.com/vonaka/shreplic/server/smr"
@@ -49,6 +50,33 @@ type Replica struct {
 
 	totalRecNum  int
 	totalSendNum int
+
+	// the last ballot in which this replica
+	// committed a command as a leader
+	lastCommittedBallot int32
+
+	// states requested by joining replicas
+	stateRequests chan *stateRequest
+
+	// leadership changes requested by the master
+	leaderRequests chan *leaderRequest
+
+	// the quorums of the Prepare and Accept phases
+	readQ  smr.QuorumI
+	writeQ smr.QuorumI
+
+	// leading is 1 if IsLeader, for the other goroutines (see Leading)
+	leading int32
+
+	// the instances up to compactedUpTo have been compacted,
+	// and lastPuts are the last PUTs of each key (see compactLog)
+	compactedUpTo int32
+	lastPuts      map[state.Key]put
+}
+
+type leaderRequest struct {
+	leader bool
+	done   chan struct{}
 }
 
 type InstanceStatus int
@@ -70,15 +98,15 @@ type Instance struct {
 
 type LeaderBookkeeping struct {
 	clientProposals []*smr.GPropose
-	prepareOKs      int
-	acceptOKs       int
+	prepareOKs      *smr.MsgSet
+	acceptOKs       *smr.MsgSet
 	nacks           int
 	ballot          int32
 	cmds            []state.Command
 	lastTriedBallot int32
 }
 
-func NewReplica(id int, peerAddrList []string, Isleader bool, thrifty bool, exec bool, lread bool, dreply bool, durable bool, batchWait int, f int, ps map[string]struct{}) *Replica {
+func NewReplica(id int, peerAddrList []string, Isleader bool, thrifty bool, exec bool, lread bool, dreply bool, durable bool, batchWait int, f int, qfile string, ps map[string]struct{}) *Replica {
 	r := &Replica{smr.NewReplica(id, f, peerAddrList, thrifty, exec, lread, dreply, ps),
 		make(chan fastrpc.Serializable, smr.CHAN_BUFFER_SIZE),
 		make(chan fastrpc.Serializable, smr.CHAN_BUFFER_SIZE),
@@ -98,12 +126,50 @@ func NewReplica(id int, peerAddrList []string, Isleader bool, thrifty bool, exec
 		0,
 		true,
 		-1,
-		batchWait, 0, 0}
+		batchWait, 0, 0, -1,
+		make(chan *stateRequest),
+		make(chan *leaderRequest),
+		nil, nil, 0,
+		-1, make(map[state.Key]put)}
 
+	r.Protocol = "paxos"
 	r.Durable = durable
 
+	r.readQ = smr.Majority(r.ReadQuorumSize())
+	r.writeQ = smr.Majority(r.WriteQuorumSize())
+	if q, err := smr.QuorumFromFile(qfile, r.Replica); err != nil &&
+		err != smr.NO_QUORUM_FILE {
+		log.Fatal(err)
+	} else if q != nil {
+		r.readQ = q
+		r.writeQ = q
+		r.SetQuorum(q)
+	}
+
+	if lread {
+		r.ReadChan = make(chan *smr.GPropose, smr.MaxPendingProposals)
+		r.EnableReadIndex(smr.ReadIndexer{
+			Leader: r.leader,
+			Ballot: func() int32 {
+				return r.defaultBallot[r.Id]
+			},
+			// a freshly elected leader must first
+			// commit a command in its own ballot
+			Ready: func() bool {
+				return r.lastCommittedBallot == r.defaultBallot[r.Id]
+			},
+			Index: func() int32 {
+				return r.crtInstance
+			},
+			// called outside of the run loop
+			Executed: func(i int32) bool {
+				return atomic.LoadInt32(&r.executedUpTo) >= i
+			},
+		})
+	}
+
 	if Isleader {
-		r.BeTheLeader(nil, nil)
+		r.becomeLeader()
 	}
 
 	for i := 0; i < len(r.defaultBallot); i++ {
@@ -159,12 +225,42 @@ func (r *Replica) sync() {
 
 /* RPC to be called by master */
 
+// BeTheLeader and StepDown are handled by the run loop,
+// as it is the only one to change IsLeader.
 func (r *Replica) BeTheLeader(args *smr.BeTheLeaderArgs, reply *smr.BeTheLeaderReply) error {
-	r.IsLeader = true
+	r.requestLeader(true)
+	return nil
+}
+
+func (r *Replica) StepDown(args *smr.StepDownArgs, reply *smr.StepDownReply) error {
+	r.requestLeader(false)
+	return nil
+}
+
+func (r *Replica) requestLeader(leader bool) {
+	req := &leaderRequest{
+		leader: leader,
+		done:   make(chan struct{}),
+	}
+	r.leaderRequests <- req
+	<-req.done
+}
+
+func (r *Replica) handleLeaderRequest(req *leaderRequest) {
+	if req.leader {
+		r.becomeLeader()
+	} else {
+		r.setLeader(false)
+		log.Println("I am no longer the leader")
+	}
+	close(req.done)
+}
+
+func (r *Replica) becomeLeader() {
+	r.setLeader(true)
 	r.totalRecNum = 0
 	r.totalSendNum = 0
 	log.Println("I am the leader")
-	return nil
 }
 
 func (r *Replica) replyPrepare(replicaId int32, reply *PrepareReply) {
@@ -198,12 +294,22 @@ func (r *Replica) run() {
 
 	r.ConnectToPeers()
 
+	if smr.Join {
+		r.catchUp()
+	}
+
 	r.ComputeClosestPeers()
 
 	if r.Exec {
 		go r.executeCommands()
 	}
 
+	var renewals <-chan time.Time
+	if r.ReadChan != nil {
+		renewals = r.LeaseRenewals()
+		defer r.StopLeaseRenewals()
+	}
+
 	fastClockChan = make(chan bool, 1)
 
 	//Enabled fast clock when batching
@@ -211,7 +317,15 @@ func (r *Replica) run() {
 		go r.fastClock()
 	}
 
+	var compactions <-chan time.Time
+	if CompactLog {
+		t := time.NewTicker(CompactInterval)
+		defer t.Stop()
+		compactions = t.C
+	}
+
 	onOffProposeChan := r.ProposeChan
+	failures := r.SubscribeFailures()
 
 	go r.WaitForClientConnections()
 
@@ -228,6 +342,20 @@ func (r *Replica) run() {
 			}
 			break
 
+		case read := <-r.ReadChan:
+			r.ServeRead(read)
+			break
+
+		case m := <-r.ReadIndexChan:
+			r.HandleReadIndex(m)
+			break
+
+		case <-renewals:
+			if r.IsLeader {
+				r.RenewLease(r.defaultBallot[r.Id])
+			}
+			break
+
 		case <-fastClockChan:
 			//activate new proposals channel
 			onOffProposeChan = r.ProposeChan
@@ -278,6 +406,18 @@ func (r *Replica) run() {
 		case iid := <-r.instancesToRecover:
 			r.recover(iid)
 			break
+
+		case e := <-failures:
+			r.handleFailure(e)
+			break
+
+		case req := <-r.leaderRequests:
+			r.handleLeaderRequest(req)
+			break
+
+		case <-compactions:
+			r.compactLog()
+			break
 		}
 
 	}
@@ -287,7 +427,9 @@ func (r *Replica) makeBallot(instance int32) {
 	lb := r.instanceSpace[instance].lb
 	n := int32(r.Id)
 	if r.IsLeader {
-		for n < r.defaultBallot[r.Id] || n < r.maxRecvBallot {
+		// the ballots below N are left to the followers
+		// that recover an instance (see followBallot)
+		for n < int32(r.N) || n < r.defaultBallot[r.Id] || n < r.maxRecvBallot {
 			n += int32(r.N)
 		}
 	}
@@ -302,16 +444,19 @@ func (r *Replica) bcastPrepare(instance int32) {
 		}
 	}()
 
-	args := &Prepare{r.Id, instance, r.instanceSpace[instance].lb.lastTriedBallot}
+	lb := r.instanceSpace[instance].lb
+	lb.prepareOKs = r.acks(lb.prepareOKs, r.readQ)
+	args := &Prepare{r.Id, instance, lb.lastTriedBallot}
 
 	n := r.N - 1
 
 	sent := 0
+	order := r.PeerOrder()
 	for q := 0; q < r.N-1; q++ {
-		if !r.Alive[r.PreferredPeerOrder[q]] {
+		if !r.Alive[order[q]] {
 			continue
 		}
-		r.SendMsg(r.PreferredPeerOrder[q], r.prepareRPC, args)
+		r.SendMsg(order[q], r.prepareRPC, args)
 		sent++
 		if sent >= n {
 			break
@@ -320,6 +465,16 @@ func (r *Replica) bcastPrepare(instance int32) {
 
 }
 
+// acks returns ms reinitialized to collect the acks of a quorum of q,
+// the acks of this replica included.
+func (r *Replica) acks(ms *smr.MsgSet, q smr.QuorumI) *smr.MsgSet {
+	ms = ms.ReinitMsgSet(q, func(_, _ interface{}) bool {
+		return true
+	}, func(interface{}) {}, nil)
+	ms.Add(r.Id, true, r)
+	return ms
+}
+
 var pa Accept
 
 func (r *Replica) bcastAccept(instance int32) {
@@ -328,20 +483,23 @@ func (r *Replica) bcastAccept(instance int32) {
 			log.Println("Accept bcast failed:", err)
 		}
 	}()
+	lb := r.instanceSpace[instance].lb
+	lb.acceptOKs = r.acks(lb.acceptOKs, r.writeQ)
 	pa.LeaderId = r.Id
 	pa.Instance = instance
-	pa.Ballot = r.instanceSpace[instance].lb.lastTriedBallot
-	pa.Command = r.instanceSpace[instance].lb.cmds
+	pa.Ballot = lb.lastTriedBallot
+	pa.Command = lb.cmds
 	args := &pa
 
 	n := r.N - 1
 
 	sent := 0
+	order := r.PeerOrder()
 	for q := 0; q < r.N-1; q++ {
-		if !r.Alive[r.PreferredPeerOrder[q]] {
+		if !r.Alive[order[q]] {
 			continue
 		}
-		r.SendMsg(r.PreferredPeerOrder[q], r.acceptRPC, args)
+		r.SendMsg(order[q], r.acceptRPC, args)
 		sent++
 		if sent >= n {
 			break
@@ -371,20 +529,64 @@ func (r *Replica) bcastCommit(instance int32, ballot int32, command []state.Comm
 	argsShort := &pcs
 
 	sent := 0
+	order := r.PeerOrder()
 	for q := 0; q < r.N-1; q++ {
-		if !r.Alive[r.PreferredPeerOrder[q]] {
+		if !r.Alive[order[q]] {
 			continue
 		}
-		r.SendMsg(r.PreferredPeerOrder[q], r.commitShortRPC, argsShort)
+		r.SendMsg(order[q], r.commitShortRPC, argsShort)
 		sent++
 	}
 
 }
 
+// setLeader must be called by the run loop.
+func (r *Replica) setLeader(leader bool) {
+	r.IsLeader = leader
+	if leader {
+		atomic.StoreInt32(&r.leading, 1)
+	} else {
+		atomic.StoreInt32(&r.leading, 0)
+	}
+}
+
+// Leading returns IsLeader and can be called by any goroutine.
+func (r *Replica) Leading() bool {
+	return atomic.LoadInt32(&r.leading) == 1
+}
+
+// Leader returns the id of the leader known by the replica,
+// -1 if unknown.
+func (r *Replica) Leader() int32 {
+	return r.leader()
+}
+
+func (r *Replica) GetLeader(args *smr.GetLeaderArgs, reply *smr.GetLeaderReply) error {
+	reply.Leader = r.leader()
+	return nil
+}
+
+// leader returns the id of the current leader or -1 if unknown
+func (r *Replica) leader() int32 {
+	if r.IsLeader {
+		return r.Id
+	} else if r.maxRecvBallot < 0 {
+		return -1
+	}
+	return smr.Leader(r.maxRecvBallot, r.N)
+}
+
 func (r *Replica) handlePropose(propose *smr.GPropose) {
 	if !r.IsLeader {
 		dlog.Printf("Not the leader, cannot propose %v\n", propose.CommandId)
-		preply := &smr.ProposeReplyTS{FALSE, -1, state.NIL(), 0}
+		preply := &smr.ProposeReplyTS{
+			OK:        FALSE,
+			CommandId: propose.CommandId,
+			Value:     state.NIL(),
+			Timestamp: propose.Timestamp,
+			Status:    smr.STATUS_NOT_LEADER,
+			Leader:    r.leader(),
+		}
 		r.ReplyProposeTS(preply, propose.Reply, propose.Mutex)
 		return
 	}
@@ -408,7 +610,7 @@ func (r *Replica) handlePropose(propose *smr.GPropose) {
 		r.defaultBallot[r.Id],
 		r.defaultBallot[r.Id],
 		PREPARING,
-		&LeaderBookkeeping{proposals, 0, 0, 0, r.Id, nil, -1}}
+		&LeaderBookkeeping{proposals, nil, nil, 0, r.Id, nil, -1}}
 	r.makeBallot(r.crtInstance)
 
 	inst := r.instanceSpace[r.crtInstance]
@@ -429,10 +631,29 @@ func (r *Replica) handlePropose(propose *smr.GPropose) {
 	}
 }
 
+// followBallot makes the leader a follower if another replica leads a
+// higher ballot, e.g., after it took over (see handleFailure). The
+// ballots below N, with which followers recover instances, never take
+// over.
+func (r *Replica) followBallot(leaderId, ballot int32) {
+	if !r.IsLeader || leaderId == r.Id ||
+		ballot < int32(r.N) || ballot <= r.defaultBallot[r.Id] {
+		return
+	}
+	log.Printf("Replica %d is the new leader\n", leaderId)
+	r.setLeader(false)
+}
+
 func (r *Replica) handlePrepare(prepare *Prepare) {
+	if r.LeaseBlocks(prepare.LeaderId) {
+		dlog.Printf("Lease is held by another replica, ignoring Prepare from %d\n", prepare.LeaderId)
+		return
+	}
+
 	if prepare.Ballot > r.maxRecvBallot {
 		r.maxRecvBallot = prepare.Ballot
 	}
+	r.followBallot(prepare.LeaderId, prepare.Ballot)
 
 	inst := r.instanceSpace[prepare.Instance]
 	if inst == nil {
@@ -464,6 +685,7 @@ func (r *Replica) handlePrepare(prepare *Prepare) {
 		dlog.Printf("Joined higher ballot %d < %d", prepare.Ballot, inst.bal)
 	} else if inst.bal < prepare.Ballot {
 		dlog.Printf("Joining ballot %d ", prepare.Ballot)
+		r.ObserveBallot(prepare.Ballot)
 		inst.bal = prepare.Ballot
 		inst.status = PREPARED
 		if r.crtInstance == prepare.Instance {
@@ -484,6 +706,7 @@ func (r *Replica) handleAccept(accept *Accept) {
 	if accept.Ballot > r.maxRecvBallot {
 		r.maxRecvBallot = accept.Ballot
 	}
+	r.followBallot(accept.LeaderId, accept.Ballot)
 
 	if inst == nil {
 		if accept.Instance > r.crtInstance {
@@ -504,6 +727,7 @@ func (r *Replica) handleAccept(accept *Accept) {
 	} else if inst.status == COMMITTED {
 		dlog.Printf("Already committed \n")
 	} else {
+		r.ObserveBallot(accept.Ballot)
 		inst.cmds = accept.Command
 		inst.bal = accept.Ballot
 		inst.vbal = accept.Ballot
@@ -513,7 +737,7 @@ func (r *Replica) handleAccept(accept *Accept) {
 		r.sync()
 	}
 
-	areply := &AcceptReply{accept.Instance, inst.bal}
+	areply := &AcceptReply{accept.Instance, inst.bal, r.Id}
 	r.replyAccept(accept.LeaderId, areply)
 }
 
@@ -617,12 +841,12 @@ func (r *Replica) handlePrepareReply(preply *PrepareReply) {
 		lb.cmds = preply.Command
 	}
 
-	lb.prepareOKs++
+	lb.prepareOKs.Add(preply.AcceptorId, false, preply)
 	if r.defaultBallot[preply.AcceptorId] < preply.DefaultBallot {
 		r.defaultBallot[preply.AcceptorId] = preply.DefaultBallot
 	}
 
-	if lb.prepareOKs < r.Replica.ReadQuorumSize() && len(preply.Command) != 0 {
+	if !lb.prepareOKs.Complete() && len(preply.Command) != 0 {
 		r.totalRecNum += len(preply.Command)
 		log.Println("totalRecNum:", r.totalRecNum)
 	}
@@ -631,7 +855,7 @@ func (r *Replica) handlePrepareReply(preply *PrepareReply) {
 	// ignoring `lb.cmds = preply.Command` executed
 	// previously. This is strange
 
-	if lb.prepareOKs+1 >= r.Replica.ReadQuorumSize() {
+	if lb.prepareOKs.Complete() {
 		if lb.clientProposals != nil {
 			dlog.Printf("Pushing client proposals")
 			cmds := make([]state.Command, len(lb.clientProposals))
@@ -702,11 +926,12 @@ func (r *Replica) handleAcceptReply(areply *AcceptReply) {
 		return
 	}
 
-	lb.acceptOKs++
-	if lb.acceptOKs+1 >= r.Replica.WriteQuorumSize() {
+	lb.acceptOKs.Add(areply.AcceptorId, false, areply)
+	if lb.acceptOKs.Complete() {
 		dlog.Printf("Committing (crtInstance=%d)\n", r.crtInstance)
 		inst = r.instanceSpace[areply.Instance]
 		inst.status = COMMITTED
+		r.lastCommittedBallot = inst.bal
 		r.recordInstanceMetadata(r.instanceSpace[areply.Instance])
 		r.sync() //is this necessary?
 
@@ -715,10 +940,11 @@ func (r *Replica) handleAcceptReply(areply *AcceptReply) {
 			// give client the all clear
 			for i := 0; i < len(inst.cmds); i++ {
 				propreply := &smr.ProposeReplyTS{
-					TRUE,
-					lb.clientProposals[i].CommandId,
-					state.NIL(),
-					lb.clientProposals[i].Timestamp}
+					OK:        TRUE,
+					CommandId: lb.clientProposals[i].CommandId,
+					Value:     state.NIL(),
+					Timestamp: lb.clientProposals[i].Timestamp,
+				}
 				r.ReplyProposeTS(propreply, lb.clientProposals[i].Reply, lb.clientProposals[i].Mutex)
 			}
 		}
@@ -739,13 +965,28 @@ func (r *Replica) recover(instance int32) {
 	}
 
 	if r.instanceSpace[instance].lb == nil {
-		r.instanceSpace[instance].lb = &LeaderBookkeeping{nil, 0, 0, 0, -1, nil, -1}
+		r.instanceSpace[instance].lb = &LeaderBookkeeping{nil, nil, nil, 0, -1, nil, -1}
 	}
 
 	r.makeBallot(instance)
 	r.bcastPrepare(instance)
 }
 
+// handleFailure makes the replica the leader if the current
+// leader is suspected and if it is the next replica in line.
+func (r *Replica) handleFailure(e smr.FailureEvent) {
+	if !e.Suspected || r.IsLeader || e.Peer != r.leader() || r.Successor(e.Peer) != r.Id {
+		return
+	}
+	log.Printf("Leader %d is suspected (phi %.1f)\n", e.Peer, e.Phi)
+	r.becomeLeader()
+	for i := atomic.LoadInt32(&r.executedUpTo) + 1; i <= r.crtInstance; i++ {
+		if inst := r.instanceSpace[i]; inst == nil || inst.status != COMMITTED {
+			r.recover(i)
+		}
+	}
+}
+
 func (r *Replica) executeCommands() {
 	timeout := int64(0)
 	problemInstance := int32(0)
@@ -753,6 +994,18 @@ func (r *Replica) executeCommands() {
 	for !r.Shutdown {
 		executed := false
 
+		select {
+		case req := <-r.stateRequests:
+			if req.snapshot != nil {
+				req.err = r.WriteSnapshot(req.snapshot, r.executedUpTo)
+			} else {
+				r.SnapshotState(req.reply)
+				req.reply.Position = r.executedUpTo
+			}
+			close(req.done)
+		default:
+		}
+
 		// FIXME idempotence
 		for i := r.executedUpTo + 1; i <= r.crtInstance; i++ {
 			inst := r.instanceSpace[i]
@@ -762,17 +1015,18 @@ func (r *Replica) executeCommands() {
 					if r.Dreply && inst.lb != nil && inst.lb.clientProposals != nil {
 						val := inst.cmds[j].Execute(r.State)
 						propreply := &smr.ProposeReplyTS{
-							TRUE,
-							inst.lb.clientProposals[j].CommandId,
-							val,
-							inst.lb.clientProposals[j].Timestamp}
+							OK:        TRUE,
+							CommandId: inst.lb.clientProposals[j].CommandId,
+							Value:     val,
+							Timestamp: inst.lb.clientProposals[j].Timestamp,
+						}
 						r.ReplyProposeTS(propreply, inst.lb.clientProposals[j].Reply, inst.lb.clientProposals[j].Mutex)
 					} else if inst.cmds[j].Op == state.PUT {
 						inst.cmds[j].Execute(r.State)
 					}
 				}
 				executed = true
-				r.executedUpTo++
+				atomic.AddInt32(&r.executedUpTo, 1)
 				dlog.Printf("Executed up to %d (crtInstance=%d)", r.executedUpTo, r.crtInstance)
 			} else {
 				if i == problemInstance {
@@ -793,7 +1047,9 @@ func (r *Replica) executeCommands() {
 			}
 		}
 
-		if !executed {
+		if executed {
+			r.ExecutedUpTo(atomic.LoadInt32(&r.executedUpTo))
+		} else {
 			r.M.Lock()
 			r.M.Unlock() // FIXME for cache coherence
 			time.Sleep(SLEEP_TIME_NS)
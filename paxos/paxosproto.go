@@ -33,8 +33,9 @@ type Accept struct {
 }
 
 type AcceptReply struct {
-	Instance int32
-	Ballot   int32
+	Instance   int32
+	Ballot     int32
+	AcceptorId int32
 }
 
 type Commit struct {
@@ -327,7 +328,7 @@ func (t *AcceptReply) New() fastrpc.Serializable {
 	return new(AcceptReply)
 }
 func (t *AcceptReply) BinarySize() (nbytes int, sizeKnown bool) {
-	return 9, true
+	return 12, true
 }
 
 type AcceptReplyCache struct {
@@ -360,9 +361,9 @@ func (p *AcceptReplyCache) Put(t *AcceptReply) {
 	p.mu.Unlock()
 }
 func (t *AcceptReply) Marshal(wire io.Writer) {
-	var b [9]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:8]
+	bs = b[:12]
 	tmp32 := t.Instance
 	bs[0] = byte(tmp32)
 	bs[1] = byte(tmp32 >> 8)
@@ -373,18 +374,24 @@ func (t *AcceptReply) Marshal(wire io.Writer) {
 	bs[5] = byte(tmp32 >> 8)
 	bs[6] = byte(tmp32 >> 16)
 	bs[7] = byte(tmp32 >> 24)
+	tmp32 = t.AcceptorId
+	bs[8] = byte(tmp32)
+	bs[9] = byte(tmp32 >> 8)
+	bs[10] = byte(tmp32 >> 16)
+	bs[11] = byte(tmp32 >> 24)
 	wire.Write(bs)
 }
 
 func (t *AcceptReply) Unmarshal(wire io.Reader) error {
-	var b [8]byte
+	var b [12]byte
 	var bs []byte
-	bs = b[:8]
-	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
 		return err
 	}
 	t.Instance = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
 	t.Ballot = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	t.AcceptorId = int32((uint32(bs[8]) | (uint32(bs[9]) << 8) | (uint32(bs[10]) << 16) | (uint32(bs[11]) << 24)))
 	return nil
 }
 
@@ -0,0 +1,15 @@
+package paxos
+
+import "github.com/vonaka/shreplic/server/smr"
+
+func init() {
+	smr.RegisterProtocol(&smr.Protocol{
+		Name: "paxos",
+		Flags: []string{"thrifty", "lread", "lease", "drift", "durable",
+			"batchwait", "qfile", "fd", "heartbeat", "phi"},
+		NewReplica: func(o *smr.Options) interface{} {
+			return NewReplica(o.Id, o.Addrs, o.IsLeader, o.Thrifty, o.Exec,
+				o.LRead, o.Dreply, o.Durable, o.BatchWait, o.F, o.QFile, o.Proxies)
+		},
+	})
+}
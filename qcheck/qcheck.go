@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vonaka/shreplic/server/smr"
+)
+
+var (
+	replicas    = flag.String("replicas", "", "Comma-separated list of the replica addresses, ordered by id")
+	maxfailures = flag.Int("maxfailures", -1, "Maximum number of failures")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s -replicas <addr,...> [options] <quorum file>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *replicas == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	addrs := strings.Split(*replicas, ",")
+	if *maxfailures == -1 {
+		*maxfailures = (len(addrs) - 1) / 2
+	}
+
+	failed := false
+	for _, qfile := range flag.Args() {
+		if err := check(qfile, addrs, *maxfailures); err != nil {
+			fmt.Println(err)
+			failed = true
+		} else {
+			fmt.Printf("%s: OK\n", qfile)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func check(qfile string, addrs []string, f int) error {
+	r := &smr.Replica{
+		N:            len(addrs),
+		F:            f,
+		PeerAddrList: addrs,
+	}
+	AQs, leaders, err := smr.NewQuorumsFromFile(qfile, r)
+	if err != nil && err != smr.THREE_QUARTERS {
+		return err
+	}
+	q, err := smr.QuorumFromFile(qfile, r)
+	if err != nil {
+		return err
+	}
+
+	if q == nil {
+		q = smr.NewMajorityOf(r.N)
+	}
+	fmt.Printf("%s: quorums: %v\n", qfile, q)
+	for i, AQ := range AQs {
+		if len(AQ) != 0 {
+			fmt.Printf("%s: active quorum of %d: %v\n", qfile, leaders[i], AQ)
+		}
+	}
+	return nil
+}
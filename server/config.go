@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/vonaka/shreplic/server/smr"
+)
+
+// Configuration file.
+//
+// The options of a server can also be given in a JSON file (-config):
+//
+//	{
+//		"protocol": "paxoi",
+//		"common": {
+//			"maddr": "10.0.0.1",
+//			"maxpending": 50000
+//		},
+//		"paxoi": {
+//			"qfile": "quorums.json",
+//			"pool": 2
+//		},
+//		"n2paxos": {
+//			"lread": true
+//		}
+//	}
+//
+// The keys are the names of the flags and "protocol" is the name given
+// to -proto. The "common" section holds the options of every protocol
+// and the other sections the options of a single protocol; only the
+// section of the chosen protocol is applied, but all of them are
+// checked against what their protocol supports.
+// The flags given on the command line override the file and are
+// checked the same way, and -dumpconfig prints the resulting
+// configuration. A protocol given both by the file and by the cluster
+// file (-cluster) must be the same.
+
+var (
+	configFile = flag.String("config", "", "Configuration file")
+	dumpConfig = flag.Bool("dumpconfig", false, "Print the effective configuration and exit")
+)
+
+// configFlags are the flags that cannot appear in a configuration file.
+var configFlags = map[string]struct{}{
+	"cluster":    {},
+	"config":     {},
+	"dumpconfig": {},
+	"proto":      {},
+}
+
+type serverConfig struct {
+	Protocol string                                `json:"protocol,omitempty"`
+	Common   map[string]json.RawMessage            `json:"common,omitempty"`
+	Sections map[string]map[string]json.RawMessage `json:"-"`
+}
+
+// isCommon returns true if every protocol supports option name.
+func isCommon(name string) bool {
+	for _, pname := range smr.Protocols() {
+		if p, _ := smr.GetProtocol(pname); !p.Supports(name) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseConfig(content []byte) (*serverConfig, error) {
+	sections := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(content, &sections); err != nil {
+		return nil, err
+	}
+
+	c := &serverConfig{
+		Sections: make(map[string]map[string]json.RawMessage),
+	}
+	for name, s := range sections {
+		var err error
+		switch name {
+		case "protocol":
+			err = json.Unmarshal(s, &c.Protocol)
+		case "common":
+			err = json.Unmarshal(s, &c.Common)
+		default:
+			var opts map[string]json.RawMessage
+			if err = json.Unmarshal(s, &opts); err == nil {
+				c.Sections[name] = opts
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return c, nil
+}
+
+// validate checks c for protocol p and returns all the problems found.
+func (c *serverConfig) validate(p *smr.Protocol) error {
+	var errs []string
+	check := func(section, name string, p *smr.Protocol) {
+		if _, exists := configFlags[name]; exists {
+			errs = append(errs, fmt.Sprintf("%s: %s cannot be set here", section, name))
+		} else if flag.Lookup(name) == nil {
+			errs = append(errs, fmt.Sprintf("%s: unknown option %s", section, name))
+		} else if !p.Supports(name) {
+			errs = append(errs, fmt.Sprintf("%s: %s is not supported by %s",
+				section, name, p.Name))
+		}
+	}
+
+	for _, name := range sortedKeys(c.Common) {
+		check("common", name, p)
+	}
+	for _, section := range sortedSections(c.Sections) {
+		q, exists := smr.GetProtocol(section)
+		if !exists {
+			errs = append(errs, "unknown protocol "+section)
+			continue
+		}
+		for _, name := range sortedKeys(c.Sections[section]) {
+			check(section, name, q)
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// validateFlags checks the flags given on the command line for
+// protocol p and returns all the problems found.
+func validateFlags(p *smr.Protocol) error {
+	var errs []string
+	flag.Visit(func(f *flag.Flag) {
+		if !p.Supports(f.Name) {
+			errs = append(errs, fmt.Sprintf("-%s is not supported by %s",
+				f.Name, p.Name))
+		}
+	})
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// loadConfig applies the configuration file to the flags that are not
+// given on the command line. clusterProto is the protocol given by the
+// cluster file, if any.
+func loadConfig(filename, clusterProto string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	c, err := parseConfig(content)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	set := make(map[string]struct{})
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = struct{}{}
+	})
+	if c.Protocol != "" && clusterProto != "" && c.Protocol != clusterProto {
+		return fmt.Errorf("%s: protocol %s conflicts with protocol %s of the cluster file",
+			filename, c.Protocol, clusterProto)
+	}
+	if _, exists := set["proto"]; !exists && c.Protocol != "" {
+		*proto = c.Protocol
+	}
+	p, exists := smr.GetProtocol(*proto)
+	if !exists {
+		return fmt.Errorf("%s: unknown protocol %s", filename, *proto)
+	}
+
+	if err := c.validate(p); err != nil {
+		return fmt.Errorf("%s:\n%v", filename, err)
+	}
+	apply := func(section string, opts map[string]json.RawMessage) error {
+		for name, v := range opts {
+			if _, exists := set[name]; exists {
+				continue
+			}
+			value := string(v)
+			switch value[0] {
+			case '{', '[':
+				return fmt.Errorf("%s: %s: expected a string, a number or a boolean",
+					section, name)
+			case '"':
+				json.Unmarshal(v, &value)
+			}
+			if err := flag.Set(name, value); err != nil {
+				return fmt.Errorf("%s: %s: %v", section, name, err)
+			}
+		}
+		return nil
+	}
+	if err := apply("common", c.Common); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := apply(p.Name, c.Sections[p.Name]); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// printConfig prints the effective configuration in the format
+// of the configuration files.
+func printConfig(p *smr.Protocol) {
+	common := make(map[string]interface{})
+	section := make(map[string]interface{})
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, exists := configFlags[f.Name]; exists {
+			return
+		}
+		v := f.Value.(flag.Getter).Get()
+		if isCommon(f.Name) {
+			common[f.Name] = v
+		} else if p.Supports(f.Name) {
+			section[f.Name] = v
+		}
+	})
+	c := map[string]interface{}{
+		"protocol": p.Name,
+		"common":   common,
+		p.Name:     section,
+	}
+	out, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(out))
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedSections(m map[string]map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,11 @@
+package main
+
+// The protocols register themselves when their package is imported.
+// The protocols added with shreplic are imported by a file of their own.
+import (
+	_ "github.com/vonaka/shreplic/curp"
+	_ "github.com/vonaka/shreplic/epaxos"
+	_ "github.com/vonaka/shreplic/n2paxos"
+	_ "github.com/vonaka/shreplic/paxoi"
+	_ "github.com/vonaka/shreplic/paxos"
+)
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -11,33 +12,29 @@ import (
 	"os"
 	"os/signal"
 	"runtime/pprof"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/vonaka/shreplic/curp"
-	"github.com/vonaka/shreplic/epaxos"
+	"github.com/google/uuid"
 	"github.com/vonaka/shreplic/master/defs"
-	"github.com/vonaka/shreplic/n2paxos"
-	"github.com/vonaka/shreplic/paxoi"
-	"github.com/vonaka/shreplic/paxos"
-	//user imports
+	"github.com/vonaka/shreplic/server/smr"
 )
 
 var (
 	portnum     = flag.Int("port", 7070, "Port # to listen on")
 	masterAddr  = flag.String("maddr", "", "Master address")
 	masterPort  = flag.Int("mport", 7087, "Master port")
+	clusterFile = flag.String("cluster", "", "Cluster file listing the replicas (the master is not used)")
 	myAddr      = flag.String("addr", "", "Server address (this machine)")
-	doEpaxos    = flag.Bool("epaxos", false, "Use EPaxos as the replication protocol")
-	doPaxoi     = flag.Bool("paxoi", false, "Use Paxoi as the replication protocol")
-	doN2paxos   = flag.Bool("n2paxos", false, "Use n²Paxos as the replication protocol")
-	doCurp      = flag.Bool("curp", false, "Use CURP as the replication protocol")
-	doOptCurp   = flag.Bool("curpOpt", false, "Use optimized CURP as the replication protocol")
+	proto       = flag.String("proto", "paxos", "Replication protocol (see -proto help)")
 	cpuprofile  = flag.String("cpuprofile", "", "Cpu profile")
 	thrifty     = flag.Bool("thrifty", false, "Use only as many messages as strictly required")
 	exec        = flag.Bool("exec", true, "Execute commands")
 	optExec     = flag.Bool("optexec", false, "Execute commands optimistically")
-	lread       = flag.Bool("lread", false, "Fast reads")
+	lread       = flag.Bool("lread", false, "Fast reads (linearizable local reads under leader leases for Paxos and n²Paxos)")
+	leaseDur    = flag.Int("lease", 2000, "Duration of leader leases in milliseconds (only for Paxos and n²Paxos)")
+	clockDrift  = flag.Float64("drift", 0.01, "Maximal clock drift rate between replicas (only for Paxos and n²Paxos)")
 	dreply      = flag.Bool("dreply", true, "Reply to client only after command has been executed")
 	beacon      = flag.Bool("beacon", false, "Send beacons to other replicas to compare their relative speeds")
 	maxfailures = flag.Int("maxfailures", -1, "Maximum number of failures")
@@ -49,14 +46,59 @@ var (
 	descNum     = flag.Int("desc", 100, "Number of command descriptors (only for Paxoi and n²Paxos)")
 	poolLevel   = flag.Int("pool", 1, "Level of pool usage from 0 to 2 (only for Paxoi and n²Paxos)")
 	AQreconf    = flag.Bool("AQreconf", true, "Automatically reconfigure Paxoi's slow active quorum")
+	maxPending  = flag.Int("maxpending", 100000, "Maximum number of proposals waiting to be handled")
+	compress    = flag.Bool("compress", false, "Compress the messages sent to the replicas that also use this option")
+	compressMin = flag.Int("compressmin", 1024, "Size in bytes above which messages are compressed")
+	maxInFlight = flag.Int("maxinflight", 0, "Maximum number of unanswered proposals per client (0 means no limit)")
+	fd          = flag.Bool("fd", false, "Detect the failures of the other replicas without the master (only for Paxos, Paxoi and EPaxos)")
+	heartbeat   = flag.Int("heartbeat", 100, "Milliseconds between two heartbeats of the failure detector")
+	phi         = flag.Float64("phi", 8, "Suspicion level above which the failure detector suspects a replica")
 	args        = flag.String("args", "", "Custom arguments")
-
-	//user flags
 )
 
 func main() {
 	flag.Parse()
 
+	var (
+		cluster      *smr.Cluster
+		clusterProto string
+	)
+	if *clusterFile != "" {
+		var err error
+		cluster, err = smr.ReadCluster(*clusterFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		protoSet := false
+		flag.Visit(func(f *flag.Flag) {
+			protoSet = protoSet || f.Name == "proto"
+		})
+		if cluster.Protocol != "" && !protoSet {
+			*proto = cluster.Protocol
+		}
+		clusterProto = cluster.Protocol
+	}
+	if *configFile != "" {
+		if err := loadConfig(*configFile, clusterProto); err != nil {
+			log.Fatal(err)
+		}
+	}
+	p, exists := smr.GetProtocol(*proto)
+	if !exists {
+		if *proto != "help" {
+			fmt.Printf("unknown protocol %s\n", *proto)
+		}
+		fmt.Println("protocols:", strings.Join(smr.Protocols(), ", "))
+		os.Exit(1)
+	}
+	if err := validateFlags(p); err != nil {
+		log.Fatal(err)
+	}
+	if *dumpConfig {
+		printConfig(p)
+		return
+	}
+
 	ps := make(map[string]struct{})
 	if *proxy != "" {
 		f, err := os.Open(*proxy)
@@ -83,49 +125,65 @@ func main() {
 	}
 
 	log.Printf("Server starting on port %d", *portnum)
-	fullAddr := fmt.Sprintf("%s:%d", *masterAddr, *masterPort)
-	replicaId, nodeList, isLeader := registerWithMaster(fullAddr)
+	var (
+		replicaId int
+		nodeList  []string
+		isLeader  bool
+	)
+	if cluster != nil {
+		var err error
+		replicaId, err = cluster.Find(*myAddr, *portnum)
+		if err != nil {
+			log.Fatal(*clusterFile, ": ", err)
+		}
+		nodeList = cluster.Addrs()
+		isLeader = replicaId == cluster.Leader
+	} else {
+		replicaId, nodeList, isLeader, smr.Join, smr.InitialEpoch =
+			registerWithMaster(defs.MasterAddrs(*masterAddr, *masterPort))
+	}
 
 	if *maxfailures == -1 {
 		*maxfailures = (len(nodeList) - 1) / 2
 	}
 	log.Printf("Tolerating %d max. failures", *maxfailures)
 
-	if *doEpaxos {
-		log.Println("Starting Egalitarian Paxos replica...")
-		rep := epaxos.NewReplica(replicaId, nodeList, *thrifty, *exec, *lread,
-			*dreply, *beacon, *durable, *batchWait, *tConf, *maxfailures, ps)
-		rpc.Register(rep)
-	} else if *doPaxoi {
-		log.Println("Starting Paxoi replica...")
-		paxoi.MaxDescRoutines = *descNum
-		rep := paxoi.NewReplica(replicaId, nodeList, *exec, *lread,
-			*dreply, *optExec, *AQreconf, *poolLevel, *maxfailures, *qfile, ps)
-		rpc.Register(rep)
-	} else if *doN2paxos {
-		log.Println("Starting n²Paxos replica...")
-		n2paxos.MaxDescRoutines = *descNum
-		rep := n2paxos.NewReplica(replicaId, nodeList, *exec,
-			*dreply, *optExec, *poolLevel, *maxfailures, *qfile, ps)
-		rpc.Register(rep)
-	} else if *doCurp {
-		log.Println("Starting CURP replica...")
-		curp.MaxDescRoutines = *descNum
-		rep := curp.NewReplica(replicaId, nodeList, *exec,
-			*dreply, *poolLevel, *maxfailures, *qfile, false, ps)
-		rpc.Register(rep)
-	} else if *doOptCurp {
-		log.Println("Starting optimized CURP replica...")
-		curp.MaxDescRoutines = *descNum
-		rep := curp.NewReplica(replicaId, nodeList, *exec,
-			*dreply, *poolLevel, *maxfailures, *qfile, true, ps)
-		rpc.Register(rep)
-	} else {
-		log.Println("Starting Paxos replica...")
-		rep := paxos.NewReplica(replicaId, nodeList, isLeader, *thrifty, *exec,
-			*lread, *dreply, *durable, *batchWait, *maxfailures, ps)
-		rpc.Register(rep)
+	smr.LeaseDuration = time.Duration(*leaseDur) * time.Millisecond
+	smr.MaxClockDrift = *clockDrift
+	smr.MaxPendingProposals = *maxPending
+	smr.MaxClientInFlight = *maxInFlight
+	smr.CompressThreshold = *compressMin
+	if *compress {
+		smr.Compression = smr.COMPRESSION_FLATE
 	}
+	smr.FailureDetection = *fd
+	smr.HeartbeatInterval = time.Duration(*heartbeat) * time.Millisecond
+	smr.PhiThreshold = *phi
+
+	log.Printf("Starting %s replica...", p.Name)
+	rep := p.NewReplica(&smr.Options{
+		Id:       replicaId,
+		Addrs:    nodeList,
+		IsLeader: isLeader,
+		F:        *maxfailures,
+		Proxies:  ps,
+
+		Thrifty:   *thrifty,
+		Exec:      *exec,
+		OptExec:   *optExec,
+		LRead:     *lread,
+		Dreply:    *dreply,
+		Beacon:    *beacon,
+		Durable:   *durable,
+		TConf:     *tConf,
+		AQReconf:  *AQreconf,
+		BatchWait: *batchWait,
+		Desc:      *descNum,
+		Pool:      *poolLevel,
+		QFile:     *qfile,
+		Args:      *args,
+	})
+	rpc.Register(rep)
 
 	rpc.HandleHTTP()
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", *portnum+1000))
@@ -136,37 +194,46 @@ func main() {
 	http.Serve(l, nil)
 }
 
-func registerWithMaster(masterAddr string) (int, []string, bool) {
+// registerWithMaster tries the masters in turn until
+// the primary one registers the replica.
+func registerWithMaster(masters []string) (int, []string, bool, bool, int32) {
 	var reply defs.RegisterReply
 	args := &defs.RegisterArgs{
-		Addr: *myAddr,
-		Port: *portnum,
+		Addr:        *myAddr,
+		Port:        *portnum,
+		Protocol:    *proto,
+		MaxFailures: *maxfailures,
+		Token:       uuid.New().String(),
+	}
+	if *qfile != "" {
+		args.Quorums, _ = ioutil.ReadFile(*qfile)
 	}
-	log.Printf("connecting to: %v", masterAddr)
 
-	for {
-		mcli, err := rpc.DialHTTP("tcp", masterAddr)
+	for m := 0; ; m = (m + 1) % len(masters) {
+		log.Printf("connecting to: %v", masters[m])
+		mcli, err := rpc.DialHTTP("tcp", masters[m])
 		if err == nil {
+			// the master answers once every replica is registered
 			for {
-				// TODO: This is an active wait, not cool.
 				err = mcli.Call("Master.Register", args, &reply)
-				if err == nil {
-					if reply.Ready {
-						break
-					}
-					time.Sleep(4)
-				} else {
-					log.Printf("%v", err)
+				if err != nil || reply.Ready {
+					break
 				}
+				log.Println("Waiting for the other replicas...")
+			}
+			mcli.Close()
+			if err == nil {
+				break
+			}
+			if defs.IsError(err, defs.ErrClusterFull) || defs.IsError(err, defs.ErrDuplicate) {
+				log.Fatal("Registration rejected: ", err)
 			}
-			break
-		} else {
-			log.Printf("%v", err)
 		}
-		time.Sleep(4)
+		log.Printf("%v", err)
+		time.Sleep(100 * time.Millisecond)
 	}
 
-	return reply.ReplicaId, reply.NodeList, reply.IsLeader
+	return reply.ReplicaId, reply.NodeList, reply.IsLeader, reply.Join, reply.Epoch
 }
 
 func catchKill(interrupt chan os.Signal) {
@@ -0,0 +1,57 @@
+package smr
+
+import (
+	"bufio"
+	"os"
+)
+
+// Administration.
+//
+// Besides Ping, BeTheLeader and InstallQuorums, the master calls these
+// RPCs on behalf of shr-admin. To transfer the leadership, the master
+// asks the new leader to be the leader and then the old one to step
+// down.
+
+// StepDown makes the leader a follower. Protocols whose leader is
+// given by the ballot need not override it, as the new leader starts a
+// higher ballot.
+func (r *Replica) StepDown(args *StepDownArgs, reply *StepDownReply) error {
+	return nil
+}
+
+// Snapshot writes the state of the replica next to its stable store.
+// Protocols that execute commands in a log should override it to take
+// the snapshot between the execution of two entries (see WriteSnapshot).
+func (r *Replica) Snapshot(args *SnapshotArgs, reply *SnapshotReply) error {
+	return r.WriteSnapshot(reply, -1)
+}
+
+// WriteSnapshot writes the state of the replica, taken at the given
+// position of the log, next to its stable store.
+func (r *Replica) WriteSnapshot(reply *SnapshotReply, position int32) error {
+	name := storeFullFileName(int(r.Id)) + ".snapshot"
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	reply.Keys = r.State.Snapshot(w)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	reply.File = name
+	reply.Position = position
+	return f.Close()
+}
+
+func (r *Replica) GetStats(args *StatsArgs, reply *StatsReply) error {
+	r.M.Lock()
+	defer r.M.Unlock()
+
+	reply.Stats = make(map[string]int, len(r.Stats.M))
+	for k, v := range r.Stats.M {
+		reply.Stats[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package smr
+
+import (
+	"bufio"
+	"sync"
+	"time"
+
+	"github.com/vonaka/shreplic/state"
+)
+
+// Admission control.
+//
+// A proposal received from a client is admitted only if fewer than
+// MaxPendingProposals proposals wait in the queue of the protocol and
+// if the client has fewer than MaxClientInFlight proposals that are not
+// answered yet. Otherwise the client receives a STATUS_OVERLOADED reply
+// and is expected to retry later. The queues of the protocol hold at
+// most MaxPendingProposals proposals. Clients that track the RPC table
+// of the protocol receive the rejections with the code PROPOSE_REPLY,
+// as the other messages they read.
+//
+// A proposal is answered by ReplyProposeTS or, for the protocols that
+// answer with their own messages, by the first message sent to the
+// client that implements Answer.
+
+var (
+	MaxPendingProposals = 100000
+	// 0 means no limit
+	MaxClientInFlight = 0
+	// a proposal that is not answered after this delay is
+	// no longer counted as in flight
+	InFlightTimeout = 5 * time.Second
+)
+
+// Answer is implemented by the messages that answer a command of
+// a client, whose id is given by AnsweredCommand.
+type Answer interface {
+	AnsweredCommand() int32
+}
+
+type admission struct {
+	m sync.Mutex
+	// admission time of the proposals in flight,
+	// per connection and per command
+	inFlight map[*bufio.Writer]map[int32]time.Time
+}
+
+func newAdmission() *admission {
+	return &admission{
+		inFlight: make(map[*bufio.Writer]map[int32]time.Time),
+	}
+}
+
+// admit pushes p to queue if there is room for it,
+// otherwise p is rejected.
+func (r *Replica) admit(p *GPropose, queue chan *GPropose) {
+	if !r.acquireInFlight(p) {
+		r.reject(p, STATUS_OVERLOADED, "rejected_client")
+		return
+	}
+	select {
+	case queue <- p:
+	default:
+		r.releaseInFlight(p.Reply, p.CommandId)
+		r.reject(p, STATUS_OVERLOADED, "rejected_queue")
+	}
+}
+
+func (r *Replica) reject(p *GPropose, status uint8, reason string) {
+	r.M.Lock()
+	r.Stats.M["rejected"]++
+	r.Stats.M[reason]++
+	r.M.Unlock()
+
+	r.links.client(p.Reply).sendReply(&ProposeReplyTS{
+		OK:        FALSE,
+		CommandId: p.CommandId,
+		Value:     state.NIL(),
+		Timestamp: p.Timestamp,
+		Status:    status,
+		Leader:    -1,
+		Epoch:     r.Epoch(),
+	})
+}
+
+func (r *Replica) acquireInFlight(p *GPropose) bool {
+	if MaxClientInFlight <= 0 {
+		return true
+	}
+
+	a := r.admission
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	now := time.Now()
+	cmds, exists := a.inFlight[p.Reply]
+	if !exists {
+		cmds = make(map[int32]time.Time)
+		a.inFlight[p.Reply] = cmds
+	}
+	for id, t := range cmds {
+		if now.Sub(t) > InFlightTimeout {
+			delete(cmds, id)
+		}
+	}
+	// a proposal sent again is admitted again
+	if _, exists := cmds[p.CommandId]; !exists && len(cmds) >= MaxClientInFlight {
+		return false
+	}
+	cmds[p.CommandId] = now
+	return true
+}
+
+// releaseInFlight is called once command cmdId of the client
+// writing to w is answered.
+func (r *Replica) releaseInFlight(w *bufio.Writer, cmdId int32) {
+	if MaxClientInFlight <= 0 {
+		return
+	}
+
+	a := r.admission
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if cmds, exists := a.inFlight[w]; exists {
+		delete(cmds, cmdId)
+	}
+}
+
+func (r *Replica) forgetClient(w *bufio.Writer) {
+	a := r.admission
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	delete(a.inFlight, w)
+}
@@ -0,0 +1,141 @@
+package smr
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vonaka/shreplic/state"
+)
+
+func withInFlight(t *testing.T, max int, timeout time.Duration) {
+	maxInFlight, inFlightTimeout := MaxClientInFlight, InFlightTimeout
+	MaxClientInFlight, InFlightTimeout = max, timeout
+	t.Cleanup(func() {
+		MaxClientInFlight, InFlightTimeout = maxInFlight, inFlightTimeout
+	})
+}
+
+func testPropose(w *bufio.Writer, cmdId int32) *GPropose {
+	return &GPropose{
+		Propose: &Propose{
+			CommandId: cmdId,
+			Command:   state.Command{Op: state.PUT},
+		},
+		Reply: w,
+	}
+}
+
+func TestAdmissionCap(t *testing.T) {
+	withInFlight(t, 2, time.Minute)
+	r := newTestReplica(t, 0, 3)
+	w := bufio.NewWriter(ioutil.Discard)
+	queue := make(chan *GPropose, 10)
+
+	r.admit(testPropose(w, 1), queue)
+	r.admit(testPropose(w, 2), queue)
+	r.admit(testPropose(w, 3), queue)
+	if len(queue) != 2 {
+		t.Fatalf("%d proposals admitted, want 2", len(queue))
+	}
+
+	// a proposal sent again is not counted twice
+	r.admit(testPropose(w, 2), queue)
+	if len(queue) != 3 {
+		t.Fatal("proposal 2 sent again is not admitted")
+	}
+
+	// neither the rejection of 3 nor the answer to 4,
+	// which is not admitted, frees a place
+	r.ReplyProposeTS(&ProposeReplyTS{CommandId: 3, Value: state.NIL()}, w, nil)
+	r.ReplyProposeTS(&ProposeReplyTS{CommandId: 4, Value: state.NIL()}, w, nil)
+	r.admit(testPropose(w, 5), queue)
+	if len(queue) != 3 {
+		t.Fatal("proposal 5 admitted above the cap")
+	}
+
+	r.ReplyProposeTS(&ProposeReplyTS{CommandId: 1, Value: state.NIL()}, w, nil)
+	r.admit(testPropose(w, 5), queue)
+	if len(queue) != 4 {
+		t.Fatal("proposal 5 not admitted once 1 is answered")
+	}
+
+	// the other clients have their own cap
+	r.admit(testPropose(bufio.NewWriter(ioutil.Discard), 1), queue)
+	if len(queue) != 5 {
+		t.Fatal("proposal of another client not admitted")
+	}
+}
+
+func TestAdmissionTimeout(t *testing.T) {
+	withInFlight(t, 1, 10*time.Millisecond)
+	r := newTestReplica(t, 0, 3)
+	w := bufio.NewWriter(ioutil.Discard)
+	queue := make(chan *GPropose, 10)
+
+	r.admit(testPropose(w, 1), queue)
+	r.admit(testPropose(w, 2), queue)
+	if len(queue) != 1 {
+		t.Fatalf("%d proposals admitted, want 1", len(queue))
+	}
+	time.Sleep(20 * time.Millisecond)
+	r.admit(testPropose(w, 2), queue)
+	if len(queue) != 2 {
+		t.Fatal("proposal 2 not admitted once 1 is expired")
+	}
+}
+
+func TestAdmissionQueue(t *testing.T) {
+	withInFlight(t, 2, time.Minute)
+	r := newTestReplica(t, 0, 3)
+	w := bufio.NewWriter(ioutil.Discard)
+	queue := make(chan *GPropose, 1)
+
+	r.admit(testPropose(w, 1), queue)
+	// the queue is full, nothing waits for room
+	r.admit(testPropose(w, 2), queue)
+	if len(queue) != 1 {
+		t.Fatalf("%d proposals admitted, want 1", len(queue))
+	}
+	if n := r.Stats.M["rejected_queue"]; n != 1 {
+		t.Fatalf("%d proposals rejected by the queue, want 1", n)
+	}
+
+	// the rejected proposal does not count as in flight
+	<-queue
+	r.admit(testPropose(w, 3), queue)
+	if len(queue) != 1 {
+		t.Fatal("proposal 3 not admitted")
+	}
+}
+
+func TestRejectTable(t *testing.T) {
+	r := newTestReplica(t, 0, 3)
+
+	for _, table := range []bool{false, true} {
+		client, server := net.Pipe()
+		w := bufio.NewWriter(server)
+		r.links.addClient(w, server, table)
+		r.reject(testPropose(w, 7), STATUS_OVERLOADED, "rejected_queue")
+
+		reader := bufio.NewReader(client)
+		if table {
+			code, err := reader.ReadByte()
+			if err != nil || code != PROPOSE_REPLY {
+				t.Fatalf("code %d (error %v) sent to a client reading the table", code, err)
+			}
+		}
+		rep := &ProposeReplyTS{}
+		if err := rep.Unmarshal(reader); err != nil {
+			t.Fatal(err)
+		}
+		if rep.OK != FALSE || rep.CommandId != 7 || rep.Status != STATUS_OVERLOADED {
+			t.Errorf("table %t: wrong rejection %+v", table, rep)
+		}
+		r.links.forgetClient(w)
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,111 @@
+package smr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// Static clusters.
+//
+// Instead of registering with the master, the servers and the clients
+// can read the replicas from a cluster file (-cluster):
+//
+//	{
+//		"protocol": "paxos",
+//		"leader": 0,
+//		"replicas": [
+//			{"id": 0, "addr": "10.0.0.1:7070"},
+//			{"id": 1, "addr": "10.0.0.2:7070"},
+//			{"id": 2, "addr": "10.0.0.3:7070"}
+//		]
+//	}
+//
+// The ids go from 0 to N-1. "protocol" is optional and "leader" is the
+// initial leader (0 by default). The clients then ask the replicas who
+// the leader is (see GetLeader), so the master is not needed.
+
+type ClusterReplica struct {
+	Id   int    `json:"id"`
+	Addr string `json:"addr"`
+}
+
+type Cluster struct {
+	Protocol string           `json:"protocol,omitempty"`
+	Leader   int              `json:"leader"`
+	Replicas []ClusterReplica `json:"replicas"`
+}
+
+func ReadCluster(filename string) (*Cluster, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{}
+	if err := json.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	sort.Slice(c.Replicas, func(i, j int) bool {
+		return c.Replicas[i].Id < c.Replicas[j].Id
+	})
+	for i, rep := range c.Replicas {
+		if rep.Id != i {
+			return nil, fmt.Errorf("%s: the ids must go from 0 to %d",
+				filename, len(c.Replicas)-1)
+		}
+		if _, _, err := net.SplitHostPort(rep.Addr); err != nil {
+			return nil, fmt.Errorf("%s: replica %d: %v", filename, i, err)
+		}
+	}
+	if len(c.Replicas) == 0 {
+		return nil, fmt.Errorf("%s: no replica", filename)
+	}
+	if c.Leader < 0 || c.Leader >= len(c.Replicas) {
+		return nil, fmt.Errorf("%s: no replica %d", filename, c.Leader)
+	}
+	return c, nil
+}
+
+// Addrs returns the addresses of the replicas ordered by id.
+func (c *Cluster) Addrs() []string {
+	addrs := make([]string, len(c.Replicas))
+	for i, rep := range c.Replicas {
+		addrs[i] = rep.Addr
+	}
+	return addrs
+}
+
+// Find returns the id of the replica listening on addr:port. If addr is
+// empty, the port alone must identify the replica.
+func (c *Cluster) Find(addr string, port int) (int, error) {
+	id := -1
+	for _, rep := range c.Replicas {
+		h, p, _ := net.SplitHostPort(rep.Addr)
+		if p != strconv.Itoa(port) || (addr != "" && h != addr) {
+			continue
+		}
+		if id != -1 {
+			return -1, fmt.Errorf("several replicas listen on port %d, use -addr", port)
+		}
+		id = rep.Id
+	}
+	if id == -1 {
+		return -1, fmt.Errorf("no replica listens on %s:%d", addr, port)
+	}
+	return id, nil
+}
+
+// RPCAddr returns the address on which the replica listening on addr
+// answers the RPCs.
+func RPCAddr(addr string) string {
+	h, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	port, _ := strconv.Atoi(p)
+	return net.JoinHostPort(h, strconv.Itoa(port+1000))
+}
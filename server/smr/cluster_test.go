@@ -0,0 +1,106 @@
+package smr
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeCluster(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	filename := filepath.Join(dir, "cluster.json")
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestReadCluster(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		// the error expected, none if empty
+		err string
+	}{
+		{content: `{"replicas": [{"id": 1, "addr": "10.0.0.2:7070"},
+			{"id": 0, "addr": "10.0.0.1:7070"}]}`},
+		{content: `{"protocol": "paxos", "leader": 1, "replicas": [
+			{"id": 0, "addr": "10.0.0.1:7070"}, {"id": 1, "addr": "10.0.0.2:7070"}]}`},
+		{content: `{"replicas": [`, err: "unexpected end"},
+		{content: `{"replicas": []}`, err: "no replica"},
+		{content: `{"replicas": [{"id": 0, "addr": "10.0.0.1:7070"},
+			{"id": 2, "addr": "10.0.0.2:7070"}]}`, err: "the ids must go from 0 to 1"},
+		{content: `{"replicas": [{"id": 0, "addr": "10.0.0.1:7070"},
+			{"id": 0, "addr": "10.0.0.2:7070"}]}`, err: "the ids must go from 0 to 1"},
+		{content: `{"replicas": [{"id": 0, "addr": "10.0.0.1"}]}`, err: "replica 0"},
+		{content: `{"leader": 1, "replicas": [{"id": 0, "addr": "10.0.0.1:7070"}]}`,
+			err: "no replica 1"},
+		{content: `{"leader": -1, "replicas": [{"id": 0, "addr": "10.0.0.1:7070"}]}`,
+			err: "no replica -1"},
+	} {
+		c, err := ReadCluster(writeCluster(t, test.content))
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.content, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error %v, want %q", test.content, err, test.err)
+		} else if err == nil {
+			want := []string{"10.0.0.1:7070", "10.0.0.2:7070"}
+			if addrs := c.Addrs(); !reflect.DeepEqual(addrs, want) {
+				t.Errorf("%s: addresses %v, want %v", test.content, addrs, want)
+			}
+		}
+	}
+
+	if _, err := ReadCluster("/nonexistent/cluster.json"); err == nil {
+		t.Error("missing cluster file read")
+	}
+}
+
+func TestClusterFind(t *testing.T) {
+	c := &Cluster{
+		Replicas: []ClusterReplica{
+			{0, "10.0.0.1:7070"},
+			{1, "10.0.0.2:7070"},
+			{2, "10.0.0.2:7071"},
+		},
+	}
+	for _, test := range []struct {
+		addr string
+		port int
+		// -1 if an error is expected
+		id int
+	}{
+		{"10.0.0.1", 7070, 0},
+		{"10.0.0.2", 7070, 1},
+		{"", 7071, 2},
+		// several replicas listen on 7070
+		{"", 7070, -1},
+		{"10.0.0.1", 7071, -1},
+		{"10.0.0.3", 7070, -1},
+	} {
+		id, err := c.Find(test.addr, test.port)
+		if id != test.id || (err != nil) != (test.id == -1) {
+			t.Errorf("Find(%q, %d) = %d, %v, want %d", test.addr, test.port, id, err, test.id)
+		}
+	}
+}
+
+func TestRPCAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		"10.0.0.1:7070": "10.0.0.1:8070",
+		"[::1]:7070":    "[::1]:8070",
+		"10.0.0.1":      "10.0.0.1",
+	} {
+		if got := RPCAddr(addr); got != want {
+			t.Errorf("RPCAddr(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
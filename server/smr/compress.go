@@ -0,0 +1,146 @@
+package smr
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+)
+
+// Compression of peer links.
+//
+// The compression of a peer connection is negotiated during the
+// handshake: it is used only if both replicas enable it. The writer
+// goroutine of such a link then compresses the batches of messages
+// (see link) that are at least CompressThreshold bytes long. Such a
+// batch is sent as a GENERIC_SMR_COMPRESSED code, followed by its
+// length and the compressed messages, which are read back by
+// replicaListener as if they were received one after the other.
+// Therefore, the Marshal and Unmarshal methods of the protocols are
+// not concerned by compression.
+
+const (
+	COMPRESSION_NONE  = uint8(0)
+	COMPRESSION_FLATE = uint8(1)
+)
+
+var (
+	Compression       = COMPRESSION_NONE
+	CompressThreshold = 1024
+	CompressLevel     = flate.BestSpeed
+	// MaxCompressBatch is the size above which the messages waiting
+	// in the queue of a link are no longer added to the current batch
+	MaxCompressBatch = 64 * 1024
+	// MaxCompressedSize bounds the size of a batch a replica accepts
+	MaxCompressedSize = 64 * 1024 * 1024
+)
+
+var errTooLarge = errors.New("compressed batch is too large")
+
+// compression returns the compression to use with a peer offering c.
+func compression(c uint8) uint8 {
+	if c == COMPRESSION_FLATE && Compression == COMPRESSION_FLATE {
+		return COMPRESSION_FLATE
+	}
+	return COMPRESSION_NONE
+}
+
+type compressor struct {
+	zw  *flate.Writer
+	buf bytes.Buffer
+	len [binary.MaxVarintLen64]byte
+}
+
+func newCompressor() *compressor {
+	c := &compressor{}
+	c.zw, _ = flate.NewWriter(&c.buf, CompressLevel)
+	return c
+}
+
+// write writes batch to w, compressed if it is large enough and if
+// it is worth it, and returns the number of bytes written.
+func (c *compressor) write(w *bufio.Writer, batch []byte) int {
+	if len(batch) < CompressThreshold {
+		w.Write(batch)
+		return len(batch)
+	}
+
+	c.buf.Reset()
+	c.zw.Reset(&c.buf)
+	c.zw.Write(batch)
+	c.zw.Close()
+
+	n := binary.PutUvarint(c.len[:], uint64(c.buf.Len()))
+	if 1+n+c.buf.Len() >= len(batch) {
+		// incompressible
+		w.Write(batch)
+		return len(batch)
+	}
+	w.WriteByte(GENERIC_SMR_COMPRESSED)
+	w.Write(c.len[:n])
+	w.Write(c.buf.Bytes())
+	return 1 + n + c.buf.Len()
+}
+
+type decompressor struct {
+	zr    io.ReadCloser
+	in    bytes.Reader
+	batch bytes.Reader
+}
+
+func newDecompressor() *decompressor {
+	return &decompressor{
+		zr: flate.NewReader(nil),
+	}
+}
+
+// read reads a compressed batch from reader. The messages of the batch
+// can then be read from d.batch.
+func (d *decompressor) read(reader *bufio.Reader) error {
+	n, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return err
+	}
+	if n > uint64(MaxCompressedSize) {
+		return errTooLarge
+	}
+	data := make([]byte, n)
+	if _, err = io.ReadFull(reader, data); err != nil {
+		return err
+	}
+
+	d.in.Reset(data)
+	if err = d.zr.(flate.Resetter).Reset(&d.in, nil); err != nil {
+		return err
+	}
+	batch, err := ioutil.ReadAll(io.LimitReader(d.zr, int64(MaxCompressedSize)+1))
+	if err != nil {
+		return err
+	}
+	if len(batch) > MaxCompressedSize {
+		return errTooLarge
+	}
+	d.batch.Reset(batch)
+	return nil
+}
+
+type linkStats struct {
+	bytes     int64
+	wireBytes int64
+}
+
+func (s *linkStats) add(bytes, wireBytes int) {
+	atomic.AddInt64(&s.bytes, int64(bytes))
+	atomic.AddInt64(&s.wireBytes, int64(wireBytes))
+}
+
+func (s *linkStats) get() LinkStats {
+	return LinkStats{
+		Bytes:     atomic.LoadInt64(&s.bytes),
+		WireBytes: atomic.LoadInt64(&s.wireBytes),
+	}
+}
@@ -0,0 +1,113 @@
+package smr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// readBeacons reads the beacons written to buf as replicaListener does.
+func readBeacons(t *testing.T, buf *bytes.Buffer) []int64 {
+	reader := bufio.NewReader(buf)
+	z := newDecompressor()
+	ts := []int64{}
+	for {
+		in := byteReader(reader)
+		if z.batch.Len() > 0 {
+			in = &z.batch
+		}
+		code, err := in.ReadByte()
+		if err != nil {
+			return ts
+		}
+		switch code {
+		case GENERIC_SMR_COMPRESSED:
+			if err := z.read(reader); err != nil {
+				t.Fatal(err)
+			}
+		case GENERIC_SMR_BEACON:
+			var b Beacon
+			if err := b.Unmarshal(in); err != nil {
+				t.Fatal(err)
+			}
+			ts = append(ts, b.Timestamp)
+		default:
+			t.Fatalf("unexpected code %d", code)
+		}
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 10, 1000, 10000} {
+		batch := &bytes.Buffer{}
+		for i := 0; i < n; i++ {
+			batch.WriteByte(GENERIC_SMR_BEACON)
+			(&Beacon{Timestamp: int64(i)}).Marshal(batch)
+		}
+
+		buf := &bytes.Buffer{}
+		w := bufio.NewWriter(buf)
+		wire := newCompressor().write(w, batch.Bytes())
+		w.Flush()
+		if wire != buf.Len() {
+			t.Errorf("%d beacons: %d bytes written, %d reported", n, buf.Len(), wire)
+		}
+		compressed := buf.Bytes()[0] == GENERIC_SMR_COMPRESSED
+		if compressed != (batch.Len() >= CompressThreshold) {
+			t.Errorf("%d beacons (%d bytes): compressed is %t",
+				n, batch.Len(), compressed)
+		}
+
+		ts := readBeacons(t, buf)
+		if len(ts) != n {
+			t.Fatalf("%d beacons read, want %d", len(ts), n)
+		}
+		for i, v := range ts {
+			if v != int64(i) {
+				t.Fatalf("beacon %d has timestamp %d", i, v)
+			}
+		}
+	}
+}
+
+func TestCompressIncompressible(t *testing.T) {
+	batch := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(batch)
+
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+	newCompressor().write(w, batch)
+	w.Flush()
+	if !bytes.Equal(buf.Bytes(), batch) {
+		t.Error("incompressible batch not sent as is")
+	}
+}
+
+func TestDecompressTooLarge(t *testing.T) {
+	max := MaxCompressedSize
+	MaxCompressedSize = 1024
+	defer func() {
+		MaxCompressedSize = max
+	}()
+
+	// the announced size is too large
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], 2048)
+	err := newDecompressor().read(bufio.NewReader(bytes.NewReader(size[:n])))
+	if err != errTooLarge {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// the decompressed batch is too large
+	buf := &bytes.Buffer{}
+	w := bufio.NewWriter(buf)
+	newCompressor().write(w, make([]byte, 4096))
+	w.Flush()
+	reader := bufio.NewReader(buf)
+	reader.ReadByte()
+	if err := newDecompressor().read(reader); err != errTooLarge {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package smr
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Configuration epochs.
+//
+// The master numbers its successive views of the replicas (their
+// addresses, which ones are alive and which one is the leader) and
+// sends the number of its view to the replicas. The replicas tag their
+// replies to the clients with it, so that the clients ask the master
+// for its view only when it has changed.
+
+// InitialEpoch is the epoch given by the master when the replica
+// registers.
+var InitialEpoch int32 = 0
+
+// Epoch returns the last configuration epoch received from the master.
+func (r *Replica) Epoch() int32 {
+	return atomic.LoadInt32(&r.epoch)
+}
+
+// SetEpoch is called by the master. Epochs never decrease.
+func (r *Replica) SetEpoch(args *SetEpochArgs, reply *SetEpochReply) error {
+	for {
+		e := atomic.LoadInt32(&r.epoch)
+		if args.Epoch <= e {
+			return nil
+		}
+		if atomic.CompareAndSwapInt32(&r.epoch, e, args.Epoch) {
+			log.Printf("Configuration epoch %d", args.Epoch)
+			return nil
+		}
+	}
+}
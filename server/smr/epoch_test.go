@@ -0,0 +1,90 @@
+package smr
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vonaka/shreplic/state"
+)
+
+func TestSetEpoch(t *testing.T) {
+	r := newTestReplica(t, 0, 3)
+	if e := r.Epoch(); e != 0 {
+		t.Fatalf("epoch is %d before the master sets it", e)
+	}
+
+	for _, test := range []struct {
+		set, want int32
+	}{
+		{3, 3},
+		{5, 5},
+		// epochs never decrease
+		{4, 5},
+		{5, 5},
+		{6, 6},
+	} {
+		r.SetEpoch(&SetEpochArgs{Epoch: test.set}, &SetEpochReply{})
+		if e := r.Epoch(); e != test.want {
+			t.Errorf("epoch is %d after %d, want %d", e, test.set, test.want)
+		}
+	}
+}
+
+func TestInitialEpoch(t *testing.T) {
+	InitialEpoch = 4
+	defer func() { InitialEpoch = 0 }()
+	r := newTestReplica(t, 0, 3)
+	if e := r.Epoch(); e != 4 {
+		t.Fatalf("epoch is %d, want the epoch of the registration", e)
+	}
+	r.SetEpoch(&SetEpochArgs{Epoch: 2}, &SetEpochReply{})
+	if e := r.Epoch(); e != 4 {
+		t.Errorf("epoch is %d after an older epoch", e)
+	}
+}
+
+func TestReplyEpoch(t *testing.T) {
+	r := newTestReplica(t, 0, 3)
+	r.SetEpoch(&SetEpochArgs{Epoch: 7}, &SetEpochReply{})
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	w := bufio.NewWriter(server)
+	r.links.addClient(w, server, false)
+
+	replies := make(chan *ProposeReplyTS, 2)
+	go func() {
+		reader := bufio.NewReader(client)
+		for {
+			rep := &ProposeReplyTS{}
+			if rep.Unmarshal(reader) != nil {
+				return
+			}
+			replies <- rep
+		}
+	}()
+
+	r.ReplyProposeTS(&ProposeReplyTS{
+		OK:        TRUE,
+		CommandId: 1,
+		Value:     state.NIL(),
+	}, w, nil)
+	r.reject(&GPropose{
+		Propose: &Propose{CommandId: 2},
+		Reply:   w,
+	}, STATUS_OVERLOADED, "rejected_queue")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case rep := <-replies:
+			if rep.Epoch != 7 {
+				t.Errorf("reply to %d has epoch %d, want 7", rep.CommandId, rep.Epoch)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no reply")
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package smr
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Phi-accrual failure detection.
+//
+// Once FailureDetection is enabled, a replica sends a beacon to each of
+// its peers every HeartbeatInterval. The replies of a peer to these
+// beacons are its heartbeats (the other beacons and beacon replies, e.g.
+// those of the latency probes, are not, so that the heartbeats of a
+// peer come at a regular pace): the detector keeps the last FDWindowSize
+// intervals between them and, assuming they are normally distributed,
+// computes the suspicion level of the peer as
+//
+//	phi = -log10(P(no heartbeat during the time elapsed since the last one))
+//
+// A peer is suspected while phi is above PhiThreshold, and for good once
+// its connection is broken. Protocols react to the suspicions with
+// SubscribeFailures.
+
+var (
+	FailureDetection  = false
+	HeartbeatInterval = 100 * time.Millisecond
+	PhiThreshold      = 8.0
+	FDWindowSize      = 100
+	// FDMinStdDev avoids suspecting a peer as soon as its very regular
+	// heartbeats are slightly late
+	FDMinStdDev = 50 * time.Millisecond
+)
+
+// FailureEvent notifies that Peer became suspected or is no longer
+// suspected.
+type FailureEvent struct {
+	Peer      int32
+	Suspected bool
+	Phi       float64
+}
+
+type failureDetector struct {
+	m     sync.Mutex
+	peers []*heartbeats
+	subs  []chan FailureEvent
+}
+
+type heartbeats struct {
+	// the timestamps of the beacons that are not answered yet
+	beacons   map[int64]struct{}
+	last      time.Time
+	intervals []float64
+	next      int
+	sum       float64
+	sumSq     float64
+	lost      bool
+	suspected bool
+}
+
+func newFailureDetector(n int) *failureDetector {
+	fd := &failureDetector{
+		peers: make([]*heartbeats, n),
+	}
+	for i := range fd.peers {
+		fd.peers[i] = &heartbeats{}
+	}
+	return fd
+}
+
+func (h *heartbeats) add(now time.Time) {
+	if h.last.IsZero() {
+		// there is no interval yet
+		h.last = now
+		h.addInterval(float64(HeartbeatInterval))
+		return
+	}
+	h.addInterval(float64(now.Sub(h.last)))
+	h.last = now
+}
+
+func (h *heartbeats) addInterval(d float64) {
+	if len(h.intervals) < FDWindowSize {
+		h.intervals = append(h.intervals, d)
+	} else {
+		old := h.intervals[h.next]
+		h.sum -= old
+		h.sumSq -= old * old
+		h.intervals[h.next] = d
+		h.next = (h.next + 1) % FDWindowSize
+	}
+	h.sum += d
+	h.sumSq += d * d
+}
+
+func (h *heartbeats) phi(now time.Time) float64 {
+	if h.lost {
+		return math.Inf(1)
+	}
+	if h.last.IsZero() {
+		return 0
+	}
+	n := float64(len(h.intervals))
+	mean := h.sum / n
+	stdDev := math.Sqrt(math.Max(h.sumSq/n-mean*mean, 0))
+	if stdDev < float64(FDMinStdDev) {
+		stdDev = float64(FDMinStdDev)
+	}
+	y := (float64(now.Sub(h.last)) - mean) / stdDev
+	return -math.Log10(0.5 * math.Erfc(y/math.Sqrt2))
+}
+
+// sent records the beacon sent to rid with timestamp ts.
+func (fd *failureDetector) sent(rid int32, ts int64) {
+	fd.m.Lock()
+	defer fd.m.Unlock()
+
+	h := fd.peers[rid]
+	if h.beacons == nil {
+		h.beacons = make(map[int64]struct{})
+	}
+	if len(h.beacons) >= FDWindowSize {
+		// forget the oldest beacon
+		oldest := ts
+		for t := range h.beacons {
+			if t < oldest {
+				oldest = t
+			}
+		}
+		delete(h.beacons, oldest)
+	}
+	h.beacons[ts] = struct{}{}
+}
+
+// heartbeat is called when rid replies to the beacon with timestamp ts,
+// which is a heartbeat if the beacon is sent by the failure detector.
+func (fd *failureDetector) heartbeat(rid int32, ts int64) {
+	fd.m.Lock()
+	defer fd.m.Unlock()
+
+	h := fd.peers[rid]
+	if _, exists := h.beacons[ts]; exists {
+		delete(h.beacons, ts)
+		h.add(time.Now())
+	}
+}
+
+func (fd *failureDetector) lost(rid int32) {
+	fd.m.Lock()
+	defer fd.m.Unlock()
+
+	fd.peers[rid].lost = true
+}
+
+// rejoined forgets the heartbeats of a peer that reconnects.
+func (fd *failureDetector) rejoined(rid int32) {
+	fd.m.Lock()
+	defer fd.m.Unlock()
+
+	fd.peers[rid] = &heartbeats{
+		suspected: fd.peers[rid].suspected,
+	}
+}
+
+// check notifies the subscribers of the peers whose suspicion changed.
+func (fd *failureDetector) check(self int32) {
+	now := time.Now()
+	events := []FailureEvent{}
+
+	fd.m.Lock()
+	for rid, h := range fd.peers {
+		if int32(rid) == self {
+			continue
+		}
+		phi := h.phi(now)
+		if suspected := phi > PhiThreshold; suspected != h.suspected {
+			h.suspected = suspected
+			events = append(events, FailureEvent{
+				Peer:      int32(rid),
+				Suspected: suspected,
+				Phi:       phi,
+			})
+		}
+	}
+	subs := fd.subs
+	fd.m.Unlock()
+
+	// a subscriber that lags behind misses the event instead of
+	// stalling the detection of the other peers
+	for _, e := range events {
+		for _, s := range subs {
+			select {
+			case s <- e:
+			default:
+				log.Printf("Failure event on %v dropped, subscriber is full", e.Peer)
+			}
+		}
+	}
+}
+
+// detectFailures must be called once the replica is connected to its
+// peers.
+func (r *Replica) detectFailures() {
+	for !r.Shutdown {
+		for rid := int32(0); rid < int32(r.N); rid++ {
+			if rid == r.Id {
+				continue
+			}
+			r.M.Lock()
+			alive := r.Alive[rid]
+			r.M.Unlock()
+			if alive {
+				r.fd.sent(rid, r.sendBeacon(rid))
+			}
+		}
+		time.Sleep(HeartbeatInterval)
+		r.fd.check(r.Id)
+	}
+}
+
+// SubscribeFailures returns a channel on which the changes of suspicion
+// of the peers are sent, or nil if FailureDetection is disabled. The
+// channel is buffered; the events that do not fit in it are dropped,
+// so Suspected should be consulted when an event may have been missed.
+func (r *Replica) SubscribeFailures() chan FailureEvent {
+	if !FailureDetection {
+		return nil
+	}
+	c := make(chan FailureEvent, 2*r.N)
+	r.fd.m.Lock()
+	r.fd.subs = append(r.fd.subs, c)
+	r.fd.m.Unlock()
+	return c
+}
+
+// Suspicion returns the suspicion level (phi) of peer rid.
+func (r *Replica) Suspicion(rid int32) float64 {
+	if rid == r.Id {
+		return 0
+	}
+	r.fd.m.Lock()
+	defer r.fd.m.Unlock()
+
+	return r.fd.peers[rid].phi(time.Now())
+}
+
+// Suspected returns true if peer rid is currently suspected.
+func (r *Replica) Suspected(rid int32) bool {
+	if rid == r.Id {
+		return false
+	}
+	r.fd.m.Lock()
+	defer r.fd.m.Unlock()
+
+	return r.fd.peers[rid].suspected
+}
+
+// Successor returns the first replica following leader, in the order
+// of ids, that is not suspected.
+func (r *Replica) Successor(leader int32) int32 {
+	for i := int32(1); i < int32(r.N); i++ {
+		rid := (leader + i) % int32(r.N)
+		if !r.Suspected(rid) {
+			return rid
+		}
+	}
+	return r.Id
+}
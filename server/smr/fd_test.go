@@ -0,0 +1,142 @@
+package smr
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPhi(t *testing.T) {
+	h := &heartbeats{}
+	start := time.Now()
+	if phi := h.phi(start); phi != 0 {
+		t.Errorf("phi is %f before the first heartbeat", phi)
+	}
+
+	for i := 0; i < 2*FDWindowSize; i++ {
+		h.add(start.Add(time.Duration(i) * HeartbeatInterval))
+	}
+	if len(h.intervals) != FDWindowSize {
+		t.Fatalf("%d intervals kept, want %d", len(h.intervals), FDWindowSize)
+	}
+	if mean := h.sum / float64(FDWindowSize); math.Abs(mean-float64(HeartbeatInterval)) > 1 {
+		t.Errorf("mean interval is %f, want %d", mean, HeartbeatInterval)
+	}
+
+	// phi grows with the time elapsed since the last heartbeat
+	prev := -1.0
+	for _, d := range []time.Duration{0, HeartbeatInterval, 2 * HeartbeatInterval,
+		4 * HeartbeatInterval, 10 * HeartbeatInterval} {
+		phi := h.phi(h.last.Add(d))
+		if phi <= prev {
+			t.Errorf("phi is %f after %v, %f before", phi, d, prev)
+		}
+		prev = phi
+	}
+	if phi := h.phi(h.last.Add(HeartbeatInterval)); phi > 1 {
+		t.Errorf("phi is %f once a heartbeat is due", phi)
+	}
+	if phi := h.phi(h.last.Add(10 * HeartbeatInterval)); phi < PhiThreshold {
+		t.Errorf("phi is %f after 10 missed heartbeats", phi)
+	}
+
+	h.lost = true
+	if phi := h.phi(h.last); !math.IsInf(phi, 1) {
+		t.Errorf("phi is %f once the connection is lost", phi)
+	}
+}
+
+func TestHeartbeats(t *testing.T) {
+	fd := newFailureDetector(3)
+	h := fd.peers[1]
+
+	// only the replies to the beacons of the detector count
+	fd.heartbeat(1, 42)
+	if !h.last.IsZero() {
+		t.Fatal("reply to an unknown beacon counted as a heartbeat")
+	}
+	fd.sent(1, 42)
+	fd.heartbeat(1, 42)
+	if h.last.IsZero() {
+		t.Fatal("reply to a beacon of the detector not counted")
+	}
+	last := h.last
+	fd.heartbeat(1, 42)
+	if h.last != last {
+		t.Fatal("reply counted twice")
+	}
+
+	// the oldest beacons are forgotten
+	for ts := int64(0); ts < int64(FDWindowSize)+1; ts++ {
+		fd.sent(2, ts)
+	}
+	if len(fd.peers[2].beacons) != FDWindowSize {
+		t.Fatalf("%d beacons kept, want %d", len(fd.peers[2].beacons), FDWindowSize)
+	}
+	if _, exists := fd.peers[2].beacons[0]; exists {
+		t.Fatal("oldest beacon not forgotten")
+	}
+}
+
+func TestSuspicion(t *testing.T) {
+	fd := newFailureDetector(3)
+	sub := make(chan FailureEvent, 6)
+	fd.subs = append(fd.subs, sub)
+
+	now := time.Now()
+	for i := 10; i >= 0; i-- {
+		fd.peers[1].add(now.Add(-time.Duration(i) * HeartbeatInterval))
+		fd.peers[2].add(now.Add(-time.Duration(i+20) * HeartbeatInterval))
+	}
+	fd.check(0)
+	if len(sub) != 1 {
+		t.Fatalf("%d events, want 1", len(sub))
+	}
+	if e := <-sub; e.Peer != 2 || !e.Suspected {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// nothing changes
+	fd.check(0)
+	if len(sub) != 0 {
+		t.Fatalf("%d events, want 0", len(sub))
+	}
+
+	fd.rejoined(2)
+	fd.check(0)
+	if e := <-sub; e.Peer != 2 || e.Suspected {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	fd.lost(1)
+	fd.check(0)
+	if e := <-sub; e.Peer != 1 || !e.Suspected {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestSuspicionFullSubscriber(t *testing.T) {
+	fd := newFailureDetector(3)
+	full := make(chan FailureEvent)
+	sub := make(chan FailureEvent, 2)
+	fd.subs = append(fd.subs, full, sub)
+
+	fd.lost(1)
+	fd.lost(2)
+	done := make(chan struct{})
+	go func() {
+		fd.check(0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("check blocked on a subscriber that does not read")
+	}
+	if len(sub) != 2 {
+		t.Fatalf("%d events, want 2", len(sub))
+	}
+	if !fd.peers[1].suspected || !fd.peers[2].suspected {
+		t.Error("dropped events changed the suspicion")
+	}
+}
@@ -0,0 +1,157 @@
+package smr
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+
+	"github.com/vonaka/shreplic/tools/fastrpc"
+)
+
+// Connection handshake.
+//
+// The first message sent on a connection to a replica is a Hello. It
+// carries the protocol of the sender, the version of the wire format
+// and a fingerprint of the RPC table of the sender, so that two nodes
+// that would not understand each other's messages never start
+// exchanging them. Replicas also compare a hash of their configuration
+// (list of replicas, number of tolerated failures, quorum system, etc.),
+// but not the active quorums, which can be replaced at runtime.
+// The receiver answers with a HelloReply explaining why the connection
+// is rejected, if it is, and which compression the connection uses.
+// A connection closed before sending anything is a probe measuring the
+// round-trip time to the replica (see tools.Ping), and is not reported.
+
+const (
+	WIRE_VERSION = int32(4)
+
+	HELLO_PEER   = uint8(0)
+	HELLO_CLIENT = uint8(1)
+)
+
+// AddToConfig must be called with every piece of configuration the
+// replicas must agree on before being connected.
+func (r *Replica) AddToConfig(data []byte) {
+	r.config = append(r.config, data...)
+}
+
+// ConfigHash returns a hash of the configuration of the replica.
+func (r *Replica) ConfigHash() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s;%d;", strings.Join(r.PeerAddrList, ","), r.F)
+	h.Write(r.config)
+	h.Write(r.qconfig)
+	return h.Sum64()
+}
+
+// NewHello returns the Hello a node sends to a replica. Clients that do
+// not know which protocol the replicas run let protocol empty, and
+// clients that do not track the RPC table pass a nil rpc.
+func NewHello(kind uint8, id int32, protocol string, rpc *fastrpc.Table, config uint64) *Hello {
+	h := &Hello{
+		Kind:       kind,
+		Id:         id,
+		Version:    WIRE_VERSION,
+		ConfigHash: config,
+		Protocol:   []byte(protocol),
+	}
+	if rpc != nil {
+		h.Fingerprint = rpc.Fingerprint()
+	}
+	return h
+}
+
+// Handshake sends hello and waits for the answer. It returns an error
+// only if the communication failed, a rejected hello is reported
+// by the OK field of the reply.
+func Handshake(reader *bufio.Reader, writer *bufio.Writer, hello *Hello) (*HelloReply, error) {
+	hello.Marshal(writer)
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	reply := &HelloReply{}
+	if err := reply.Unmarshal(reader); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (r *Replica) hello() *Hello {
+	h := NewHello(HELLO_PEER, r.Id, r.Protocol, r.RPC, r.ConfigHash())
+	h.Compression = Compression
+	return h
+}
+
+func (r *Replica) checkHello(h *Hello) error {
+	if h.Version != WIRE_VERSION {
+		return fmt.Errorf("wire version mismatch: local %d, remote %d",
+			WIRE_VERSION, h.Version)
+	}
+
+	switch h.Kind {
+	case HELLO_PEER:
+		if string(h.Protocol) != r.Protocol {
+			return fmt.Errorf("protocol mismatch: local %q, remote %q",
+				r.Protocol, h.Protocol)
+		}
+		if h.Fingerprint != r.RPC.Fingerprint() {
+			return errors.New("RPC table mismatch: replicas register different messages")
+		}
+		if h.ConfigHash != r.ConfigHash() {
+			return errors.New("configuration mismatch: different replica " +
+				"list, number of failures or quorum system")
+		}
+		if h.Id < 0 || h.Id >= int32(r.N) || h.Id == r.Id {
+			return fmt.Errorf("unexpected replica id %d", h.Id)
+		}
+
+	case HELLO_CLIENT:
+		if len(h.Protocol) != 0 && string(h.Protocol) != r.Protocol {
+			return fmt.Errorf("protocol mismatch: replica runs %q, client expects %q",
+				r.Protocol, h.Protocol)
+		}
+		if h.Fingerprint != 0 && h.Fingerprint != r.RPC.Fingerprint() {
+			return errors.New("RPC table mismatch: client and replica " +
+				"register different messages")
+		}
+
+	default:
+		return fmt.Errorf("unknown kind of connection %d", h.Kind)
+	}
+
+	return nil
+}
+
+// errProbe is returned by acceptHello for a connection closed before
+// sending anything.
+var errProbe = errors.New("probe")
+
+// acceptHello reads the Hello of a new connection and answers it.
+func (r *Replica) acceptHello(reader *bufio.Reader, writer *bufio.Writer) (*Hello, error) {
+	h := &Hello{}
+	if err := h.Unmarshal(reader); err == io.EOF {
+		return nil, errProbe
+	} else if err != nil {
+		return nil, err
+	}
+
+	reply := &HelloReply{
+		OK:       TRUE,
+		Protocol: []byte(r.Protocol),
+	}
+	if h.Kind == HELLO_PEER {
+		reply.Compression = compression(h.Compression)
+	}
+	err := r.checkHello(h)
+	if err != nil {
+		reply.OK = FALSE
+		reply.Reason = []byte(err.Error())
+	}
+	reply.Marshal(writer)
+	writer.Flush()
+
+	return h, err
+}
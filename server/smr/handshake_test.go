@@ -0,0 +1,161 @@
+package smr
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckHello(t *testing.T) {
+	r := newTestReplica(t, 0, 3)
+	r.Protocol = "paxos"
+	fp := r.RPC.Fingerprint()
+	config := r.ConfigHash()
+
+	for _, test := range []struct {
+		name  string
+		hello Hello
+		ok    bool
+	}{
+		{"peer", Hello{Kind: HELLO_PEER, Id: 1, Version: WIRE_VERSION,
+			Protocol: []byte("paxos"), Fingerprint: fp, ConfigHash: config}, true},
+		{"peer version", Hello{Kind: HELLO_PEER, Id: 1, Version: WIRE_VERSION - 1,
+			Protocol: []byte("paxos"), Fingerprint: fp, ConfigHash: config}, false},
+		{"peer protocol", Hello{Kind: HELLO_PEER, Id: 1, Version: WIRE_VERSION,
+			Protocol: []byte("epaxos"), Fingerprint: fp, ConfigHash: config}, false},
+		{"peer table", Hello{Kind: HELLO_PEER, Id: 1, Version: WIRE_VERSION,
+			Protocol: []byte("paxos"), Fingerprint: fp + 1, ConfigHash: config}, false},
+		{"peer configuration", Hello{Kind: HELLO_PEER, Id: 1, Version: WIRE_VERSION,
+			Protocol: []byte("paxos"), Fingerprint: fp, ConfigHash: config + 1}, false},
+		{"peer own id", Hello{Kind: HELLO_PEER, Id: 0, Version: WIRE_VERSION,
+			Protocol: []byte("paxos"), Fingerprint: fp, ConfigHash: config}, false},
+		{"peer unknown id", Hello{Kind: HELLO_PEER, Id: 3, Version: WIRE_VERSION,
+			Protocol: []byte("paxos"), Fingerprint: fp, ConfigHash: config}, false},
+		{"peer negative id", Hello{Kind: HELLO_PEER, Id: -1, Version: WIRE_VERSION,
+			Protocol: []byte("paxos"), Fingerprint: fp, ConfigHash: config}, false},
+		{"client", Hello{Kind: HELLO_CLIENT, Id: 42, Version: WIRE_VERSION}, true},
+		{"client protocol", Hello{Kind: HELLO_CLIENT, Id: 42, Version: WIRE_VERSION,
+			Protocol: []byte("paxos")}, true},
+		{"client table", Hello{Kind: HELLO_CLIENT, Id: 42, Version: WIRE_VERSION,
+			Protocol: []byte("paxos"), Fingerprint: fp}, true},
+		{"client version", Hello{Kind: HELLO_CLIENT, Id: 42, Version: WIRE_VERSION + 1}, false},
+		{"client other protocol", Hello{Kind: HELLO_CLIENT, Id: 42, Version: WIRE_VERSION,
+			Protocol: []byte("curp")}, false},
+		{"client other table", Hello{Kind: HELLO_CLIENT, Id: 42, Version: WIRE_VERSION,
+			Fingerprint: fp + 1}, false},
+		{"unknown kind", Hello{Kind: 7, Id: 1, Version: WIRE_VERSION}, false},
+	} {
+		h := test.hello
+		if err := r.checkHello(&h); (err == nil) != test.ok {
+			t.Errorf("%s: error %v", test.name, err)
+		}
+	}
+}
+
+func TestAcceptHello(t *testing.T) {
+	r := newTestReplica(t, 0, 3)
+	r.Protocol = "paxos"
+
+	for _, test := range []struct {
+		protocol string
+		ok       uint8
+	}{
+		{"paxos", TRUE},
+		{"epaxos", FALSE},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			r.acceptHello(bufio.NewReader(server), bufio.NewWriter(server))
+			server.Close()
+		}()
+
+		hello := NewHello(HELLO_CLIENT, 42, test.protocol, nil, 0)
+		reply, err := Handshake(bufio.NewReader(client), bufio.NewWriter(client), hello)
+		client.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.OK != test.ok {
+			t.Errorf("%s: OK is %d, want %d", test.protocol, reply.OK, test.ok)
+		}
+		if string(reply.Protocol) != "paxos" {
+			t.Errorf("%s: replica announces %q", test.protocol, reply.Protocol)
+		}
+		if (len(reply.Reason) == 0) != (test.ok == TRUE) {
+			t.Errorf("%s: reason %q", test.protocol, reply.Reason)
+		}
+	}
+}
+
+func TestAcceptProbe(t *testing.T) {
+	r := newTestReplica(t, 0, 3)
+
+	for _, hello := range [][]byte{nil, {HELLO_CLIENT, 0, 0}} {
+		client, server := net.Pipe()
+		go func() {
+			client.Write(hello)
+			client.Close()
+		}()
+		_, err := r.acceptHello(bufio.NewReader(server), bufio.NewWriter(server))
+		server.Close()
+		if probe := len(hello) == 0; (err == errProbe) != probe {
+			t.Errorf("%d bytes sent: error %v", len(hello), err)
+		}
+	}
+}
+
+func TestConfigHashQuorums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quorums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	replicas := `"replicas": [{"id": 0, "addr": "127.0.0.1:7070"},
+		{"id": 1, "addr": "127.0.0.1:7071"}, {"id": 2, "addr": "127.0.0.1:7072"}]`
+	initial := `{` + replicas + `, "weights": {"0": 2, "1": 2, "2": 1},
+		"active_quorums": [{"leader": 0, "replicas": [0, 1]}]}`
+
+	for _, test := range []struct {
+		name    string
+		content string
+		same    bool
+	}{
+		{"same file", initial, true},
+		{"installed quorums", `{` + replicas + `, "weights": {"0": 2, "1": 2, "2": 1},
+			"active_quorums": [{"leader": 1, "replicas": [1, 0]},
+			{"leader": 0, "replicas": [0, 1]}]}`, true},
+		{"no active quorum", `{` + replicas + `, "weights": {"0": 2, "1": 2, "2": 1}}`, true},
+		{"other weights", `{` + replicas + `, "weights": {"0": 1, "1": 2, "2": 2},
+			"active_quorums": [{"leader": 0, "replicas": [0, 1]}]}`, false},
+		{"majorities", `{` + replicas + `,
+			"active_quorums": [{"leader": 0, "replicas": [0, 1]}]}`, false},
+	} {
+		qfiles := []string{filepath.Join(dir, "initial"), filepath.Join(dir, "other")}
+		for i, content := range []string{initial, test.content} {
+			if err := ioutil.WriteFile(qfiles[i], []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the first replica started with the initial quorum file,
+		// the second one joins with the current one
+		r0 := newTestReplica(t, 0, 3)
+		if _, _, err := NewQuorumsFromFile(qfiles[0], r0); err != nil {
+			t.Fatal(err)
+		}
+		r1 := newTestReplica(t, 1, 3)
+		if _, err := QuorumFromFile(qfiles[1], r1); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		r0.Protocol, r1.Protocol = "paxos", "paxos"
+
+		err := r0.checkHello(r1.hello())
+		if (err == nil) != test.same {
+			t.Errorf("%s: hello of the joining replica: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package smr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"strings"
+	"time"
+)
+
+// Replacement of a dead replica.
+//
+// The master can give the id of a dead replica to a new machine. It
+// first sends the new list of replicas to the live ones (SetPeers),
+// which forget the connection to the dead replica, and then registers
+// the new one with Join set. Instead of waiting for the connections of
+// its peers, a joining replica connects to every live peer, and the
+// peers accept the connection along with those of the clients. Then,
+// protocols that support it fetch the state of a live peer (FetchState)
+// and catch up from there.
+
+// Join is true if the replica joins replicas that are already running.
+var Join = false
+
+var JoinRetries = 3
+
+// joinPeers connects a joining replica to its live peers.
+func (r *Replica) joinPeers() {
+	port := strings.Split(r.PeerAddrList[r.Id], ":")[1]
+	l, err := net.Listen("tcp", "0.0.0.0:"+port)
+	if err != nil {
+		log.Fatal(r.PeerAddrList[r.Id], err)
+	}
+	r.Listener = l
+
+	for i := int32(0); i < int32(r.N); i++ {
+		if i == r.Id {
+			continue
+		}
+		for try := 0; ; try++ {
+			err := r.dialPeer(i)
+			if err == nil {
+				break
+			}
+			if try == JoinRetries {
+				log.Printf("Cannot connect to %d: %v", i, err)
+				break
+			}
+			time.Sleep(1e9)
+		}
+	}
+	log.Printf("Replica %d: done joining peers", r.Id)
+	log.Printf("Node list %v", r.PeerAddrList)
+}
+
+func (r *Replica) dialPeer(id int32) error {
+	conn, err := net.DialTimeout("tcp", r.PeerAddrList[id], time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	reply, err := Handshake(reader, writer, r.hello())
+	if err == nil && reply.OK != TRUE {
+		err = errors.New(string(reply.Reason))
+	}
+	if err == nil {
+		err = r.addPeer(id, conn, reader, writer, reply.Compression)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	log.Printf("OUT Connected to %d", id)
+	return nil
+}
+
+// addPeer adds the connection of a peer that connects to the
+// replicas once they are running.
+func (r *Replica) addPeer(id int32, conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, compression uint8) error {
+	r.M.Lock()
+	if r.Alive[id] {
+		r.M.Unlock()
+		return fmt.Errorf("replica %d is already connected", id)
+	}
+	if r.Peers[id] != nil {
+		r.Peers[id].Close()
+	}
+	r.Peers[id] = conn
+	r.PeerReaders[id] = reader
+	r.PeerWriters[id] = writer
+	r.links.setPeer(id, writer, conn, compression)
+	r.Alive[id] = true
+	r.M.Unlock()
+
+	r.fd.rejoined(id)
+	go r.replicaListener(int(id), reader)
+	return nil
+}
+
+// SetPeers updates the addresses of the peers replaced by new machines.
+func (r *Replica) SetPeers(args *SetPeersArgs, reply *SetPeersReply) error {
+	if len(args.Addrs) != r.N {
+		return fmt.Errorf("%d replicas instead of %d", len(args.Addrs), r.N)
+	}
+
+	r.M.Lock()
+	defer r.M.Unlock()
+
+	for i, addr := range args.Addrs {
+		if int32(i) == r.Id || addr == r.PeerAddrList[i] {
+			continue
+		}
+		log.Printf("Replica %d is replaced by %s", i, addr)
+		r.PeerAddrList[i] = addr
+		if r.Peers[i] != nil {
+			r.Peers[i].Close()
+		}
+		r.Alive[i] = false
+	}
+	return nil
+}
+
+// GetState returns the state of the replica to a joining one. Protocols
+// that support state transfer must override it, as they must also give
+// the position of the state (see SnapshotState).
+func (r *Replica) GetState(args *StateArgs, reply *StateReply) error {
+	return fmt.Errorf("%s does not support state transfer", r.Protocol)
+}
+
+// SnapshotState writes the state of the replica into reply. It must be
+// called between the execution of two commands, so that the state
+// matches reply.Position.
+func (r *Replica) SnapshotState(reply *StateReply) {
+	var b bytes.Buffer
+	reply.Keys = r.State.Snapshot(&b)
+	reply.State = b.Bytes()
+}
+
+// FetchState loads the state of the first live peer that gives it, and
+// returns its position.
+func (r *Replica) FetchState() (int32, error) {
+	err := errors.New("no live peer")
+	for i := int32(0); i < int32(r.N); i++ {
+		r.M.Lock()
+		addr, alive := r.PeerAddrList[i], r.Alive[i]
+		r.M.Unlock()
+		if i == r.Id || !alive {
+			continue
+		}
+
+		var c *rpc.Client
+		c, err = rpc.DialHTTP("tcp", RPCAddr(addr))
+		if err != nil {
+			continue
+		}
+		reply := &StateReply{}
+		err = c.Call("Replica.GetState", new(StateArgs), reply)
+		c.Close()
+		if err != nil {
+			continue
+		}
+		var n int
+		n, err = r.State.Restore(bytes.NewReader(reply.State))
+		if err != nil {
+			return -1, err
+		}
+		log.Printf("Fetched %d keys from replica %d (position %d)", n, i, reply.Position)
+		return reply.Position, nil
+	}
+	return -1, err
+}
@@ -0,0 +1,226 @@
+package smr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vonaka/shreplic/tools/dlog"
+)
+
+// Leader leases.
+//
+// A leader periodically asks the other replicas for a lease. A replica
+// that grants a lease to some leader promises not to take part in the
+// election of any other leader for LeaseDuration (measured with its own
+// clock) and not to grant a lease to a smaller ballot. Once a write
+// quorum granted a lease, every read quorum intersects it, hence no
+// other leader can be elected (and no other write can be committed)
+// before the lease expires. The leader measures the lease from the
+// moment it sent the request and shortens it by the maximal clock
+// drift, so that it always expires at the leader before it expires at
+// any of the granting replicas.
+
+var (
+	LeaseDuration = 2 * time.Second
+	MaxClockDrift = 0.01
+)
+
+type lease struct {
+	m sync.Mutex
+
+	// as a granting replica
+	holder int32
+	until  time.Time
+	ballot int32
+
+	// as a leader
+	validUntil  time.Time
+	validBallot int32
+	rounds      map[int64]*leaseRound
+
+	// renewals ticks when the leader must renew its lease
+	renewals *time.Ticker
+}
+
+type leaseRound struct {
+	ballot int32
+	grants map[int32]struct{}
+}
+
+func newLease() *lease {
+	return &lease{
+		holder:      -1,
+		ballot:      -1,
+		validBallot: -1,
+		rounds:      make(map[int64]*leaseRound),
+	}
+}
+
+// LeaseRenewals returns the channel on which the run loop of the
+// protocol is regularly told to renew the lease of the leader with
+// RenewLease. The run loop must call StopLeaseRenewals once it stops.
+func (r *Replica) LeaseRenewals() <-chan time.Time {
+	l := r.lease
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.renewals == nil {
+		l.renewals = time.NewTicker(LeaseDuration / 4)
+	}
+	return l.renewals.C
+}
+
+// StopLeaseRenewals stops the ticker of LeaseRenewals.
+func (r *Replica) StopLeaseRenewals() {
+	l := r.lease
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.renewals != nil {
+		l.renewals.Stop()
+		l.renewals = nil
+	}
+}
+
+// RenewLease asks the other replicas for a lease associated with ballot.
+func (r *Replica) RenewLease(ballot int32) {
+	r.requestLease(ballot)
+}
+
+// HasLease returns true if the replica holds a lease that is valid
+// right now and that was granted for ballot.
+func (r *Replica) HasLease(ballot int32) bool {
+	l := r.lease
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.validBallot == ballot && l.ballot <= ballot &&
+		time.Now().Before(l.validUntil)
+}
+
+// LeaseBlocks returns true if the replica has promised a lease to some
+// replica other than candidate and this promise is still running. In
+// that case candidate must not be helped to become the new leader.
+func (r *Replica) LeaseBlocks(candidate int32) bool {
+	l := r.lease
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.holder != -1 && l.holder != candidate &&
+		time.Now().Before(l.until)
+}
+
+// ObserveBallot must be called each time the replica joins a ballot.
+// Leases are never granted to smaller ballots.
+func (r *Replica) ObserveBallot(ballot int32) {
+	l := r.lease
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if ballot > l.ballot {
+		l.ballot = ballot
+	}
+}
+
+func (r *Replica) observedBallot() int32 {
+	l := r.lease
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.ballot
+}
+
+func (r *Replica) requestLease(ballot int32) {
+	now := time.Now()
+	l := r.lease
+
+	l.m.Lock()
+	if ballot < l.ballot ||
+		(l.holder != r.Id && l.holder != -1 && now.Before(l.until)) {
+		l.m.Unlock()
+		return
+	}
+	// a leader grants a lease to itself
+	l.holder = r.Id
+	l.until = now.Add(LeaseDuration)
+	l.ballot = ballot
+	for ts := range l.rounds {
+		if now.Sub(time.Unix(0, ts)) > LeaseDuration {
+			delete(l.rounds, ts)
+		}
+	}
+	l.rounds[now.UnixNano()] = &leaseRound{
+		ballot: ballot,
+		grants: make(map[int32]struct{}),
+	}
+	l.m.Unlock()
+
+	req := &LeaseRequest{
+		Ballot:    ballot,
+		Timestamp: now.UnixNano(),
+	}
+	for p := int32(0); p < int32(r.N); p++ {
+		if p == r.Id {
+			continue
+		}
+		r.M.Lock()
+		alive := r.Alive[p]
+		r.M.Unlock()
+		if alive {
+			r.SendMsg(p, GENERIC_SMR_LEASE, req)
+		}
+	}
+}
+
+func (r *Replica) handleLeaseRequest(rid int32, req *LeaseRequest) {
+	now := time.Now()
+	l := r.lease
+
+	l.m.Lock()
+	ok := FALSE
+	if req.Ballot >= l.ballot &&
+		(l.holder == rid || l.holder == -1 || now.After(l.until)) {
+		l.holder = rid
+		l.until = now.Add(LeaseDuration)
+		l.ballot = req.Ballot
+		ok = TRUE
+	}
+	ballot := l.ballot
+	l.m.Unlock()
+
+	dlog.Println("lease request from", rid, "granted:", ok == TRUE)
+	r.SendMsg(rid, GENERIC_SMR_LEASE_REPLY, &LeaseReply{
+		Ballot:    ballot,
+		Timestamp: req.Timestamp,
+		OK:        ok,
+	})
+}
+
+func (r *Replica) handleLeaseReply(rid int32, rep *LeaseReply) {
+	l := r.lease
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if rep.OK != TRUE {
+		if rep.Ballot > l.ballot {
+			l.ballot = rep.Ballot
+		}
+		return
+	}
+
+	round, exists := l.rounds[rep.Timestamp]
+	if !exists || round.ballot != rep.Ballot {
+		return
+	}
+	round.grants[rid] = struct{}{}
+	if !r.hasQuorum(round.grants, r.WriteQuorumSize()) {
+		return
+	}
+
+	d := time.Duration(float64(LeaseDuration) * (1 - 2*MaxClockDrift))
+	until := time.Unix(0, rep.Timestamp).Add(d)
+	if until.After(l.validUntil) || l.validBallot != round.ballot {
+		l.validUntil = until
+		l.validBallot = round.ballot
+	}
+}
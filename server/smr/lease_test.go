@@ -0,0 +1,147 @@
+package smr
+
+import (
+	"testing"
+	"time"
+)
+
+func lastRound(r *Replica) int64 {
+	r.lease.m.Lock()
+	defer r.lease.m.Unlock()
+	last := int64(0)
+	for ts := range r.lease.rounds {
+		if ts > last {
+			last = ts
+		}
+	}
+	return last
+}
+
+func TestLeaseQuorum(t *testing.T) {
+	r := newTestReplica(t, 0, 5)
+
+	r.RenewLease(3)
+	ts := lastRound(r)
+	if ts == 0 {
+		t.Fatal("no lease round")
+	}
+	if r.HasLease(3) {
+		t.Fatal("lease without any grant")
+	}
+
+	r.handleLeaseReply(1, &LeaseReply{Ballot: 3, Timestamp: ts, OK: TRUE})
+	if r.HasLease(3) {
+		t.Fatal("lease granted by 2 replicas out of 5")
+	}
+	// a grant for another round does not count
+	r.handleLeaseReply(2, &LeaseReply{Ballot: 3, Timestamp: ts + 1, OK: TRUE})
+	if r.HasLease(3) {
+		t.Fatal("lease granted by a grant for another round")
+	}
+	r.handleLeaseReply(2, &LeaseReply{Ballot: 3, Timestamp: ts, OK: TRUE})
+	if !r.HasLease(3) {
+		t.Fatal("no lease after a write quorum of grants")
+	}
+	if r.HasLease(4) {
+		t.Fatal("lease valid for another ballot")
+	}
+
+	// a higher ballot invalidates the lease
+	r.ObserveBallot(4)
+	if r.HasLease(3) {
+		t.Fatal("lease still valid after a higher ballot")
+	}
+}
+
+func TestLeaseRefusal(t *testing.T) {
+	r := newTestReplica(t, 0, 3)
+
+	r.RenewLease(2)
+	ts := lastRound(r)
+	r.handleLeaseReply(1, &LeaseReply{Ballot: 5, Timestamp: ts, OK: FALSE})
+	if r.HasLease(2) {
+		t.Fatal("lease after a refusal")
+	}
+	if b := r.observedBallot(); b != 5 {
+		t.Fatalf("observed ballot %d instead of 5", b)
+	}
+
+	// no lease is requested for a ballot smaller than the observed one
+	rounds := len(r.lease.rounds)
+	r.RenewLease(2)
+	if len(r.lease.rounds) != rounds {
+		t.Fatal("lease requested for an old ballot")
+	}
+}
+
+func TestLeaseGrant(t *testing.T) {
+	duration := LeaseDuration
+	LeaseDuration = 100 * time.Millisecond
+	defer func() {
+		LeaseDuration = duration
+	}()
+
+	r := newTestReplica(t, 0, 3)
+
+	r.handleLeaseRequest(1, &LeaseRequest{Ballot: 4})
+	if !r.LeaseBlocks(2) {
+		t.Fatal("the lease of 1 does not block 2")
+	}
+	if r.LeaseBlocks(1) {
+		t.Fatal("the lease of 1 blocks 1")
+	}
+
+	// the lease of 1 is still running
+	r.handleLeaseRequest(2, &LeaseRequest{Ballot: 5})
+	if r.lease.holder != 1 {
+		t.Fatalf("lease granted to %d while 1 holds it", r.lease.holder)
+	}
+
+	time.Sleep(2 * LeaseDuration)
+	if r.LeaseBlocks(2) {
+		t.Fatal("expired lease blocks 2")
+	}
+	r.handleLeaseRequest(2, &LeaseRequest{Ballot: 3})
+	if r.lease.holder != 1 {
+		t.Fatal("lease granted to a smaller ballot")
+	}
+	r.handleLeaseRequest(2, &LeaseRequest{Ballot: 5})
+	if r.lease.holder != 2 {
+		t.Fatal("expired lease not granted to 2")
+	}
+}
+
+func TestLeaseRenewals(t *testing.T) {
+	duration := LeaseDuration
+	LeaseDuration = 40 * time.Millisecond
+	defer func() {
+		LeaseDuration = duration
+	}()
+
+	r := newTestReplica(t, 0, 3)
+	renewals := r.LeaseRenewals()
+	if r.LeaseRenewals() != renewals {
+		t.Fatal("a second ticker started")
+	}
+	select {
+	case <-renewals:
+	case <-time.After(time.Second):
+		t.Fatal("no renewal")
+	}
+
+	r.StopLeaseRenewals()
+	if r.lease.renewals != nil {
+		t.Fatal("ticker kept after stop")
+	}
+	// drain a tick sent before the stop
+	select {
+	case <-renewals:
+	default:
+	}
+	select {
+	case <-renewals:
+		t.Fatal("renewal after stop")
+	case <-time.After(2 * LeaseDuration):
+	}
+	r.StopLeaseRenewals()
+}
@@ -0,0 +1,296 @@
+package smr
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"sync"
+)
+
+// Outbound links.
+//
+// Every connection to a peer or to a client has its own queue and its
+// own writer goroutine, so that a slow connection only delays the
+// messages sent through it. Messages are serialized by the caller (it
+// is then free to reuse them) and the writer goroutine flushes only
+// once its queue is empty: under load many messages share a flush.
+// If the queue of a peer is full, the sender waits for the writer
+// goroutine, as the protocols do not send their messages again. If the
+// queue of a client is full, the connection is closed instead of
+// blocking the sender: the reader of the connection then treats the
+// client as disconnected. The messages sent to a client whose
+// connection is closed are dropped.
+
+var LinkQueueSize = 10000
+
+type marshaler interface {
+	Marshal(io.Writer)
+}
+
+type link struct {
+	w     *bufio.Writer
+	conn  io.Closer
+	z     *compressor
+	queue chan *bytes.Buffer
+	done  chan struct{}
+	once  sync.Once
+	stats linkStats
+	// the client reads with the RPC table of the protocol
+	table bool
+	// a full queue blocks the sender instead of closing the connection
+	block bool
+}
+
+type links struct {
+	m       sync.RWMutex
+	peers   []*link
+	clients map[*bufio.Writer]*link
+}
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// closedLink drops every message sent through it.
+var closedLink = func() *link {
+	l := &link{
+		done: make(chan struct{}),
+	}
+	l.close()
+	return l
+}()
+
+func newLink(w *bufio.Writer, conn io.Closer, compression uint8) *link {
+	l := &link{
+		w:     w,
+		conn:  conn,
+		queue: make(chan *bytes.Buffer, LinkQueueSize),
+		done:  make(chan struct{}),
+	}
+	if compression == COMPRESSION_FLATE {
+		l.z = newCompressor()
+	}
+	go l.run()
+	return l
+}
+
+func (l *link) run() {
+	for {
+		select {
+		case b := <-l.queue:
+			// once the connection is broken w
+			// drops everything written to it
+			if l.z == nil {
+				l.w.Write(b.Bytes())
+				l.stats.add(b.Len(), b.Len())
+			} else {
+				for b.Len() < MaxCompressBatch && len(l.queue) > 0 {
+					next := <-l.queue
+					b.Write(next.Bytes())
+					bufPool.Put(next)
+				}
+				l.stats.add(b.Len(), l.z.write(l.w, b.Bytes()))
+			}
+			bufPool.Put(b)
+			if len(l.queue) == 0 {
+				l.w.Flush()
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *link) push(b *bytes.Buffer) {
+	if l.block {
+		select {
+		case l.queue <- b:
+		case <-l.done:
+			bufPool.Put(b)
+		}
+		return
+	}
+	select {
+	case l.queue <- b:
+	case <-l.done:
+		bufPool.Put(b)
+	default:
+		bufPool.Put(b)
+		l.overflow()
+	}
+}
+
+// overflow closes the connection of l, a client whose queue is full.
+func (l *link) overflow() {
+	l.once.Do(func() {
+		log.Println("Client queue is full, closing the connection")
+		close(l.done)
+		if l.conn != nil {
+			l.conn.Close()
+		}
+	})
+}
+
+func (l *link) send(code uint8, msg marshaler) {
+	b := bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	b.WriteByte(code)
+	msg.Marshal(b)
+	l.push(b)
+}
+
+func (l *link) sendNoCode(msg marshaler) {
+	b := bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	msg.Marshal(b)
+	l.push(b)
+}
+
+// sendReply sends reply to a client, with the code PROPOSE_REPLY if
+// the client reads with the RPC table.
+func (l *link) sendReply(reply *ProposeReplyTS) {
+	if l.table {
+		l.send(PROPOSE_REPLY, reply)
+	} else {
+		l.sendNoCode(reply)
+	}
+}
+
+func (l *link) sendBytes(data []byte) {
+	b := bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	b.Write(data)
+	l.push(b)
+}
+
+func (l *link) close() {
+	l.once.Do(func() {
+		close(l.done)
+	})
+}
+
+func (l *link) depth() int {
+	return len(l.queue)
+}
+
+func newLinks(n int) *links {
+	return &links{
+		peers:   make([]*link, n),
+		clients: make(map[*bufio.Writer]*link),
+	}
+}
+
+func (ls *links) setPeer(id int32, w *bufio.Writer, conn io.Closer, compression uint8) {
+	ls.m.Lock()
+	defer ls.m.Unlock()
+
+	if old := ls.peers[id]; old != nil {
+		old.close()
+	}
+	l := newLink(w, conn, compression)
+	l.block = true
+	ls.peers[id] = l
+}
+
+func (ls *links) peer(id int32) *link {
+	ls.m.RLock()
+	defer ls.m.RUnlock()
+
+	return ls.peers[id]
+}
+
+// addClient creates the link of w, the writer of a client connection.
+// table is true if the client reads with the RPC table.
+func (ls *links) addClient(w *bufio.Writer, conn io.Closer, table bool) {
+	ls.m.Lock()
+	defer ls.m.Unlock()
+
+	l := newLink(w, conn, COMPRESSION_NONE)
+	l.table = table
+	ls.clients[w] = l
+}
+
+// AddClient makes the replica answer the proposals whose Reply is w,
+// for a client that is not connected through the listener of the
+// replica (e.g., a replicated master). conn is closed with the link.
+func (r *Replica) AddClient(w *bufio.Writer, conn io.Closer) {
+	r.links.addClient(w, conn, false)
+}
+
+// client returns the link of w, or a closed link if the
+// client is disconnected.
+func (ls *links) client(w *bufio.Writer) *link {
+	ls.m.RLock()
+	defer ls.m.RUnlock()
+
+	if l, exists := ls.clients[w]; exists {
+		return l
+	}
+	return closedLink
+}
+
+func (ls *links) forgetClient(w *bufio.Writer) {
+	ls.m.Lock()
+	defer ls.m.Unlock()
+
+	if l, exists := ls.clients[w]; exists {
+		l.close()
+		delete(ls.clients, w)
+	}
+}
+
+// QueueDepths returns the number of messages waiting to be written
+// to each peer (-1 if not connected) and to each client connection.
+func (r *Replica) QueueDepths() ([]int, []int) {
+	ls := r.links
+	ls.m.RLock()
+	defer ls.m.RUnlock()
+
+	peers := make([]int, len(ls.peers))
+	for i, l := range ls.peers {
+		if l == nil {
+			peers[i] = -1
+		} else {
+			peers[i] = l.depth()
+		}
+	}
+	clients := make([]int, 0, len(ls.clients))
+	for _, l := range ls.clients {
+		clients = append(clients, l.depth())
+	}
+	return peers, clients
+}
+
+// GetQueueDepths is the RPC version of QueueDepths.
+func (r *Replica) GetQueueDepths(args *QueueDepthsArgs, reply *QueueDepthsReply) error {
+	reply.Peers, reply.Clients = r.QueueDepths()
+	return nil
+}
+
+// PeerLinkStats returns the statistics of the link to each peer.
+func (r *Replica) PeerLinkStats() []LinkStats {
+	ls := r.links
+	ls.m.RLock()
+	defer ls.m.RUnlock()
+
+	stats := make([]LinkStats, len(ls.peers))
+	for i, l := range ls.peers {
+		if l == nil {
+			stats[i].Queue = -1
+			continue
+		}
+		stats[i] = l.stats.get()
+		stats[i].Queue = l.depth()
+		stats[i].Compressed = l.z != nil
+	}
+	return stats
+}
+
+// GetLinkStats is the RPC version of PeerLinkStats.
+func (r *Replica) GetLinkStats(args *LinkStatsArgs, reply *LinkStatsReply) error {
+	reply.Peers = r.PeerLinkStats()
+	return nil
+}
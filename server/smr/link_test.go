@@ -0,0 +1,100 @@
+package smr
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLinkForgottenClient(t *testing.T) {
+	ls := newLinks(3)
+	w := bufio.NewWriter(ioutil.Discard)
+	ls.addClient(w, nil, false)
+	if ls.client(w) == closedLink {
+		t.Fatal("no link for a connected client")
+	}
+
+	ls.forgetClient(w)
+	// a late reply neither blocks nor creates a new link
+	ls.client(w).sendBytes([]byte("late"))
+	if ls.client(w) != closedLink || len(ls.clients) != 0 {
+		t.Fatal("link of a forgotten client")
+	}
+}
+
+func TestLinkOverflow(t *testing.T) {
+	size := LinkQueueSize
+	LinkQueueSize = 2
+	defer func() {
+		LinkQueueSize = size
+	}()
+
+	// nobody reads from client, so that the writer of the link blocks
+	client, server := net.Pipe()
+	defer client.Close()
+	ls := newLinks(3)
+	w := bufio.NewWriterSize(server, 16)
+	ls.addClient(w, server, false)
+
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			ls.client(w).sendBytes(make([]byte, 32))
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("sender blocked by a full queue")
+	}
+
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Fatal("connection of a full link not closed")
+	}
+}
+
+func TestLinkPeerOverflow(t *testing.T) {
+	size := LinkQueueSize
+	LinkQueueSize = 2
+	defer func() {
+		LinkQueueSize = size
+	}()
+
+	// the peer does not read at first, so that the queue fills up
+	peer, conn := net.Pipe()
+	defer peer.Close()
+	ls := newLinks(3)
+	ls.setPeer(1, bufio.NewWriterSize(conn, 16), conn, COMPRESSION_NONE)
+
+	const n = 10
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			ls.peer(1).sendBytes(make([]byte, 32))
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("messages sent to a peer that does not read")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// every message reaches the peer once it reads again
+	buf := make([]byte, n*32)
+	if _, err := io.ReadFull(peer, buf); err != nil {
+		t.Fatal("connection of a full peer link closed:", err)
+	}
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("sender still blocked")
+	}
+	if ls.peer(1).depth() != 0 {
+		t.Errorf("%d messages left in the queue", ls.peer(1).depth())
+	}
+}
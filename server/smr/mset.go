@@ -6,6 +6,7 @@ type MsgSet struct {
 	q         QuorumI
 	msgs      map[int32]interface{}
 	leaderMsg interface{}
+	leaderId  int32
 	accept    func(interface{}, interface{}) bool
 	freeMsg   func(interface{})
 	handler   MsgSetHandler
@@ -18,6 +19,7 @@ func NewMsgSet(q QuorumI, accept func(interface{}, interface{}) bool,
 		q:         q,
 		msgs:      map[int32]interface{}{},
 		leaderMsg: nil,
+		leaderId:  -1,
 		accept:    accept,
 		freeMsg:   freeMsg,
 		handler:   handler,
@@ -34,6 +36,7 @@ func (ms *MsgSet) ReinitMsgSet(q QuorumI, accept func(interface{}, interface{})
 	ms.q = q
 	//ms.msgs = []interface{}{}
 	ms.leaderMsg = nil
+	ms.leaderId = -1
 	ms.accept = accept
 	ms.freeMsg = freeMsg
 	ms.handler = handler
@@ -57,6 +60,7 @@ func (ms *MsgSet) Add(repId int32, isLeader bool, msg interface{}) bool {
 
 	if isLeader {
 		ms.leaderMsg = msg
+		ms.leaderId = repId
 		newMsgs := map[int32]interface{}{}
 		for frepId, fmsg := range ms.msgs {
 			if _, seen := newMsgs[frepId];
@@ -77,8 +81,7 @@ func (ms *MsgSet) Add(repId int32, isLeader bool, msg interface{}) bool {
 		ms.freeMsg(msg)
 	}
 
-	if len(ms.msgs) >= ms.q.Size() ||
-		(len(ms.msgs) >= ms.q.Size()-1 && ms.leaderMsg != nil) {
+	if ms.handler != nil && ms.complete() {
 		m := make([]interface{}, len(ms.msgs))
 		i := 0
 		for _, v := range ms.msgs {
@@ -91,6 +94,29 @@ func (ms *MsgSet) Add(repId int32, isLeader bool, msg interface{}) bool {
 	return added
 }
 
+// Complete returns true if the messages of ms come from a quorum, so
+// that the protocols counting the replies themselves can do without a
+// handler.
+func (ms *MsgSet) Complete() bool {
+	return ms.complete()
+}
+
+func (ms *MsgSet) complete() bool {
+	if _, ok := ms.q.(QuorumChecker); !ok {
+		return len(ms.msgs) >= ms.q.Size() ||
+			(len(ms.msgs) >= ms.q.Size()-1 && ms.leaderMsg != nil)
+	}
+
+	ids := make([]int32, 0, len(ms.msgs)+1)
+	for rep := range ms.msgs {
+		ids = append(ids, rep)
+	}
+	if ms.leaderMsg != nil {
+		ids = append(ids, ms.leaderId)
+	}
+	return IsQuorum(ms.q, ids)
+}
+
 func (ms *MsgSet) Free() {
 	if ms == nil {
 		return
@@ -0,0 +1,53 @@
+package smr
+
+import "testing"
+
+func TestMsgSetComplete(t *testing.T) {
+	accept := func(_, _ interface{}) bool {
+		return true
+	}
+	free := func(interface{}) {}
+
+	for _, test := range []struct {
+		q        QuorumI
+		leader   int32
+		acks     []int32
+		complete []bool
+	}{
+		{NewMajorityOf(3), 0, []int32{1}, []bool{true}},
+		{NewMajorityOf(5), 0, []int32{1, 1, 2}, []bool{false, false, true}},
+		{NewMajorityOf(5), -1, []int32{1, 2, 3}, []bool{false, false, true}},
+		{NewWeighted(map[int32]int{0: 1, 1: 1, 2: 3}), 0, []int32{1, 2}, []bool{false, true}},
+		{NewWeighted(map[int32]int{0: 0, 1: 1, 2: 1}), 0, []int32{1, 2}, []bool{false, true}},
+	} {
+		handled := 0
+		ms := NewMsgSet(test.q, accept, free, nil)
+		if test.leader != -1 {
+			ms.Add(test.leader, true, test)
+		}
+		for i, id := range test.acks {
+			ms.Add(id, false, id)
+			if ms.Complete() != test.complete[i] {
+				t.Errorf("%v: complete is %t after the ack of %d",
+					test.q, !test.complete[i], id)
+			}
+		}
+
+		// the same acks with a handler
+		ms = ms.ReinitMsgSet(test.q, accept, free, func(interface{}, []interface{}) {
+			handled++
+		})
+		if ms.Complete() {
+			t.Errorf("%v: complete once reinitialized", test.q)
+		}
+		if test.leader != -1 {
+			ms.Add(test.leader, true, test)
+		}
+		for _, id := range test.acks {
+			ms.Add(id, false, id)
+		}
+		if (handled != 0) != test.complete[len(test.complete)-1] {
+			t.Errorf("%v: the handler is called %d times", test.q, handled)
+		}
+	}
+}
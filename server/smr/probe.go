@@ -0,0 +1,135 @@
+package smr
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// Latency probing.
+//
+// ComputeClosestPeers measures the latencies to the peers with a few
+// beacons and then keeps sending them one every ProbeInterval. The
+// round-trip time of each beacon reply is averaged in Ewma (the last
+// one having weight EwmaWeight), and every OrderInterval the peers are
+// sorted by latency in PeerOrder, the unreachable ones last, unless the
+// protocol sets FixedPeerOrder.
+// Each replica gives its own row of the latency matrix of the cluster
+// through GetLatencies, and the master gathers the rows.
+
+var (
+	ProbeInterval = 500 * time.Millisecond
+	OrderInterval = 5 * time.Second
+	EwmaWeight    = 0.1
+	// ProbeWarmup is the number of beacons sent to each
+	// peer by ComputeClosestPeers, every WarmupInterval
+	ProbeWarmup    = 5
+	WarmupInterval = 100 * time.Millisecond
+)
+
+// observeRTT must be called with r.M locked.
+func (r *Replica) observeRTT(rid int32, rtt int64) {
+	r.Latencies[rid] += rtt
+	r.replies[rid]++
+	if r.replies[rid] == 1 {
+		r.Ewma[rid] = float64(rtt)
+	} else {
+		r.Ewma[rid] = (1-EwmaWeight)*r.Ewma[rid] + EwmaWeight*float64(rtt)
+	}
+}
+
+func (r *Replica) probe() {
+	for rid := int32(0); rid < int32(r.N); rid++ {
+		if rid == r.Id {
+			continue
+		}
+		r.M.Lock()
+		alive := r.Alive[rid]
+		r.M.Unlock()
+		if alive {
+			r.SendBeacon(rid)
+		}
+	}
+}
+
+// ComputeClosestPeers must be called once the replica is connected to
+// its peers. It returns the latencies in milliseconds of the peers in
+// the preferred order (-1 for an unreachable peer).
+func (r *Replica) ComputeClosestPeers() []float64 {
+	for j := 0; j < ProbeWarmup; j++ {
+		r.probe()
+		time.Sleep(WarmupInterval)
+	}
+	r.orderPeers()
+	r.probing.Do(func() {
+		go r.probeLatencies()
+	})
+
+	rtts := r.RTTs()
+	order := r.PeerOrder()
+	latencies := make([]float64, r.N-1)
+	for i := 0; i < r.N-1; i++ {
+		node := order[i]
+		latencies[i] = rtts[node]
+		log.Println(node, "->", latencies[i], "ms")
+	}
+	return latencies
+}
+
+func (r *Replica) probeLatencies() {
+	lastOrder := time.Now()
+	for !r.Shutdown {
+		time.Sleep(ProbeInterval)
+		r.probe()
+		if !r.FixedPeerOrder && time.Since(lastOrder) >= OrderInterval {
+			r.orderPeers()
+			lastOrder = time.Now()
+		}
+	}
+}
+
+// orderPeers sorts the peers by latency in PeerOrder.
+func (r *Replica) orderPeers() {
+	rtts := r.RTTs()
+	peers := make([]int32, 0, r.N)
+	for rid := int32(0); rid < int32(r.N); rid++ {
+		if rid != r.Id {
+			peers = append(peers, rid)
+		}
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		li, lj := rtts[peers[i]], rtts[peers[j]]
+		if li < 0 || lj < 0 {
+			return lj < 0 && li >= 0
+		}
+		return li < lj
+	})
+	r.UpdatePreferredPeerOrder(peers)
+}
+
+// RTTs returns the average round-trip time in milliseconds to each
+// peer, 0 for the replica itself and -1 if a peer is unreachable.
+func (r *Replica) RTTs() []float64 {
+	r.M.Lock()
+	defer r.M.Unlock()
+
+	rtts := make([]float64, r.N)
+	for rid := range rtts {
+		if int32(rid) == r.Id {
+			continue
+		}
+		if r.replies[rid] == 0 || !r.Alive[rid] {
+			rtts[rid] = -1
+		} else {
+			rtts[rid] = math.Round(r.Ewma[rid]/1e3) / 1e3
+		}
+	}
+	return rtts
+}
+
+// GetLatencies is the RPC version of RTTs.
+func (r *Replica) GetLatencies(args *LatenciesArgs, reply *LatenciesReply) error {
+	reply.RTTs = r.RTTs()
+	return nil
+}
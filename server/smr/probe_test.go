@@ -0,0 +1,63 @@
+package smr
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestOrderPeers(t *testing.T) {
+	r := newTestReplica(t, 0, 5)
+	if order := r.PeerOrder(); !reflect.DeepEqual(order, []int32{1, 2, 3, 4, 0}) {
+		t.Fatalf("initial order %v", order)
+	}
+
+	r.M.Lock()
+	for rid, rtt := range map[int32]int64{1: 3e6, 2: 1e6, 4: 2e6} {
+		r.Alive[rid] = true
+		r.observeRTT(rid, rtt)
+	}
+	r.M.Unlock()
+	r.orderPeers()
+
+	// peer 3 never replied
+	if order := r.PeerOrder(); !reflect.DeepEqual(order[:4], []int32{2, 4, 1, 3}) {
+		t.Errorf("order %v, want [2 4 1 3]", order[:4])
+	}
+}
+
+func TestPeerOrderConcurrent(t *testing.T) {
+	r := newTestReplica(t, 0, 3)
+	r.M.Lock()
+	r.Alive[1], r.Alive[2] = true, true
+	r.observeRTT(1, 2e6)
+	r.observeRTT(2, 1e6)
+	r.M.Unlock()
+
+	// the order read by a send loop never changes under its feet
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				order := r.PeerOrder()
+				first := append([]int32(nil), order...)
+				for q := 0; q < r.N-1; q++ {
+					if order[q] != first[q] || order[q] == r.Id {
+						t.Errorf("order %v changed from %v", order, first)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for j := 0; j < 1000; j++ {
+		if j%2 == 0 {
+			r.orderPeers()
+		} else {
+			r.UpdatePreferredPeerOrder([]int32{1})
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,153 @@
+package smr
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Protocol registry.
+//
+// Each replication protocol registers itself from the init function of
+// its package, and shr-server and shr-client choose one by its name
+// (-proto). Therefore, adding a protocol only requires importing its
+// package in both binaries.
+
+type Protocol struct {
+	Name string
+	// Flags are the options of shr-server that the protocol supports
+	// among those that are not supported by every protocol
+	// (e.g. "thrifty" or "qfile")
+	Flags []string
+	// NewReplica starts a replica and returns the receiver of the RPCs
+	// sent by the master
+	NewReplica func(o *Options) interface{}
+	// NewClient is nil if the protocol can be used with the simple
+	// client. It returns nil if the client cannot be started.
+	NewClient func(o *ClientOptions) ProtocolClient
+}
+
+// Options are the options given to a new replica.
+type Options struct {
+	Id       int
+	Addrs    []string
+	IsLeader bool
+	F        int
+	Proxies  map[string]struct{}
+
+	Thrifty   bool
+	Exec      bool
+	OptExec   bool
+	LRead     bool
+	Dreply    bool
+	Beacon    bool
+	Durable   bool
+	TConf     bool
+	AQReconf  bool
+	BatchWait int
+	Desc      int
+	Pool      int
+	QFile     string
+	Args      string
+}
+
+// ClientOptions are the options given to a new client.
+type ClientOptions struct {
+	MAddr      string
+	Collocated string
+	MPort      int
+	Requests   int
+	Writes     int
+	PSize      int
+	Conflicts  int
+	Fast       bool
+	LRead      bool
+	Leaderless bool
+	Verbose    bool
+	Logger     *log.Logger
+	Args       string
+	// N is the number of replicas, 0 if unknown
+	N int
+}
+
+// ClientArgs returns the arguments of a client with "-N n"
+// added if n is known and not already given.
+func ClientArgs(args string, n int) string {
+	if n <= 0 {
+		return args
+	}
+	for _, a := range strings.Fields(args) {
+		if a == "-N" || strings.HasPrefix(a, "-N=") {
+			return args
+		}
+	}
+	return strings.TrimSpace(fmt.Sprintf("-N %d %s", n, args))
+}
+
+type ProtocolClient interface {
+	Run() error
+}
+
+var registry = struct {
+	sync.Mutex
+	protocols map[string]*Protocol
+}{
+	protocols: make(map[string]*Protocol),
+}
+
+// RegisterProtocol panics if a protocol with the same name is
+// already registered.
+func RegisterProtocol(p *Protocol) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, exists := registry.protocols[p.Name]; exists {
+		panic(fmt.Sprintf("protocol %s is registered twice", p.Name))
+	}
+	registry.protocols[p.Name] = p
+}
+
+func GetProtocol(name string) (*Protocol, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	p, exists := registry.protocols[name]
+	return p, exists
+}
+
+// Protocols returns the names of the registered protocols.
+func Protocols() []string {
+	registry.Lock()
+	defer registry.Unlock()
+
+	names := make([]string, 0, len(registry.protocols))
+	for name := range registry.protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Supports returns true if p supports option name of shr-server.
+func (p *Protocol) Supports(name string) bool {
+	specific := false
+	registry.Lock()
+	for _, q := range registry.protocols {
+		for _, f := range q.Flags {
+			specific = specific || f == name
+		}
+	}
+	registry.Unlock()
+
+	if !specific {
+		return true
+	}
+	for _, f := range p.Flags {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
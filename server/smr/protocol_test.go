@@ -0,0 +1,92 @@
+package smr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func registerTestProtocols(t *testing.T, ps ...*Protocol) {
+	for _, p := range ps {
+		RegisterProtocol(p)
+	}
+	t.Cleanup(func() {
+		registry.Lock()
+		defer registry.Unlock()
+		for _, p := range ps {
+			delete(registry.protocols, p.Name)
+		}
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	a := &Protocol{Name: "test-a"}
+	b := &Protocol{Name: "test-b"}
+	registerTestProtocols(t, b, a)
+
+	if p, exists := GetProtocol("test-a"); !exists || p != a {
+		t.Errorf("GetProtocol(test-a) = %v, %v", p, exists)
+	}
+	if _, exists := GetProtocol("test-c"); exists {
+		t.Error("unknown protocol found")
+	}
+	if names := Protocols(); !reflect.DeepEqual(names, []string{"test-a", "test-b"}) {
+		t.Errorf("protocols %v, want [test-a test-b]", names)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("protocol registered twice")
+		}
+		if p, _ := GetProtocol("test-a"); p != a {
+			t.Error("protocol replaced by a second registration")
+		}
+	}()
+	RegisterProtocol(&Protocol{Name: "test-a"})
+}
+
+func TestSupports(t *testing.T) {
+	a := &Protocol{Name: "test-a", Flags: []string{"thrifty", "qfile"}}
+	b := &Protocol{Name: "test-b", Flags: []string{"qfile"}}
+	c := &Protocol{Name: "test-c"}
+	registerTestProtocols(t, a, b, c)
+
+	for _, test := range []struct {
+		p    *Protocol
+		name string
+		want bool
+	}{
+		// supported by every protocol
+		{a, "exec", true},
+		{c, "exec", true},
+		// specific to some protocols
+		{a, "thrifty", true},
+		{b, "thrifty", false},
+		{c, "thrifty", false},
+		{a, "qfile", true},
+		{b, "qfile", true},
+		{c, "qfile", false},
+	} {
+		if got := test.p.Supports(test.name); got != test.want {
+			t.Errorf("%s supports %s: %v, want %v", test.p.Name, test.name, got, test.want)
+		}
+	}
+}
+
+func TestClientArgs(t *testing.T) {
+	for _, test := range []struct {
+		args string
+		n    int
+		want string
+	}{
+		{"", 0, ""},
+		{"-pclients 0", 0, "-pclients 0"},
+		{"", 3, "-N 3"},
+		{"-pclients 0", 3, "-N 3 -pclients 0"},
+		{"-N 5 -pclients 0", 3, "-N 5 -pclients 0"},
+		{"-pclients 0 -N=5", 3, "-pclients 0 -N=5"},
+	} {
+		if got := ClientArgs(test.args, test.n); got != test.want {
+			t.Errorf("ClientArgs(%q, %d) = %q, want %q", test.args, test.n, got, test.want)
+		}
+	}
+}
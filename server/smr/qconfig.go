@@ -0,0 +1,319 @@
+package smr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// JSON quorum configuration.
+//
+// Besides the line-based format, a quorum file can be a JSON document:
+//
+//	{
+//		"f": 1,
+//		"replicas": [
+//			{"id": 0, "addr": "10.0.0.1"},
+//			{"id": 1, "addr": "10.0.0.2"},
+//			{"id": 2, "addr": "10.0.0.3"}
+//		],
+//		"weights": {"0": 2, "1": 2, "2": 1},
+//		"active_quorums": [
+//			{"leader": 0, "replicas": [0, 1]},
+//			{"leader": 1, "replicas": [1, 2]}
+//		]
+//	}
+//
+// Only "replicas" is required. "weights" and "groups" describe a
+// weighted or a hierarchical quorum system (see QuorumFromFile), the
+// active quorums are given in order of preference (the leader of the
+// first one is the initial leader) and "fast_quorum_size" replaces the
+// "3/4" line, but cannot be combined with "weights" or "groups".
+// Contrary to the line-based format, the whole configuration is checked
+// and every problem found is reported.
+
+type QuorumConfig struct {
+	F              *int            `json:"f,omitempty"`
+	Replicas       []ReplicaConfig `json:"replicas"`
+	Weights        map[int32]int   `json:"weights,omitempty"`
+	Groups         [][]int32       `json:"groups,omitempty"`
+	ActiveQuorums  []ActiveQuorum  `json:"active_quorums,omitempty"`
+	FastQuorumSize int             `json:"fast_quorum_size,omitempty"`
+}
+
+type ReplicaConfig struct {
+	Id   int32  `json:"id"`
+	Addr string `json:"addr"`
+}
+
+type ActiveQuorum struct {
+	Leader   int32   `json:"leader"`
+	Replicas []int32 `json:"replicas"`
+}
+
+// IsQuorumConfig returns true if content is a JSON quorum configuration.
+func IsQuorumConfig(content []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(content), []byte("{"))
+}
+
+func ParseQuorumConfig(content []byte) (*QuorumConfig, error) {
+	c := &QuorumConfig{}
+	d := json.NewDecoder(bytes.NewReader(content))
+	d.DisallowUnknownFields()
+	if err := d.Decode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func LoadQuorumConfig(qfile string) (*QuorumConfig, error) {
+	content, err := ioutil.ReadFile(qfile)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseQuorumConfig(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", qfile, err)
+	}
+	return c, nil
+}
+
+// Validate checks c against the addresses of the replicas (ignored
+// if nil) and the number of tolerated failures (the one of c or the
+// largest possible if negative).
+func (c *QuorumConfig) Validate(addrs []string, f int) error {
+	var errs []string
+	errorf := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, a...))
+	}
+
+	n := len(c.Replicas)
+	if n == 0 {
+		return errors.New("no replica is given")
+	}
+	known := func(id int32) bool {
+		return id >= 0 && id < int32(n)
+	}
+
+	seenIds := make(map[int32]struct{})
+	seenAddrs := make(map[string]struct{})
+	for _, rep := range c.Replicas {
+		if !known(rep.Id) {
+			errorf("replica %d: ids must range from 0 to %d", rep.Id, n-1)
+		} else if _, exists := seenIds[rep.Id]; exists {
+			errorf("replica %d is given twice", rep.Id)
+		}
+		seenIds[rep.Id] = struct{}{}
+		if rep.Addr == "" {
+			errorf("replica %d has no address", rep.Id)
+		} else if _, exists := seenAddrs[rep.Addr]; exists {
+			errorf("address %s is given twice", rep.Addr)
+		}
+		seenAddrs[rep.Addr] = struct{}{}
+	}
+	if addrs != nil {
+		if len(addrs) != n {
+			errorf("%d replicas are given, the cluster has %d", n, len(addrs))
+		} else {
+			for _, rep := range c.Replicas {
+				if known(rep.Id) && rep.Addr != addrs[rep.Id] &&
+					rep.Addr != strings.Split(addrs[rep.Id], ":")[0] {
+					errorf("replica %d: address is %s, not %s",
+						rep.Id, addrs[rep.Id], rep.Addr)
+				}
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+
+	if c.F != nil {
+		if f >= 0 && *c.F != f {
+			errorf("f is %d, but replicas tolerate %d failures", *c.F, f)
+		}
+		f = *c.F
+	} else if f < 0 {
+		f = (n - 1) / 2
+	}
+	if f < 0 || n < 2*f+1 {
+		errorf("%d replicas cannot tolerate %d failures, %d are required",
+			n, f, 2*f+1)
+	}
+
+	switch {
+	case len(c.Weights) != 0 && len(c.Groups) != 0:
+		errorf("weights and groups cannot be combined")
+	case len(c.Weights) != 0:
+		total := 0
+		ws := []int{}
+		for id, w := range c.Weights {
+			if !known(id) {
+				errorf("weights: unknown replica %d", id)
+			}
+			if w < 0 {
+				errorf("weights: replica %d has a negative weight", id)
+			}
+			total += w
+			ws = append(ws, w)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(ws)))
+		left := total
+		for i := 0; i < f && i < len(ws); i++ {
+			left -= ws[i]
+		}
+		if total <= 0 {
+			errorf("weights: total weight must be positive")
+		} else if left < total/2+1 {
+			errorf("weights: %d failures can leave no quorum", f)
+		}
+	case len(c.Groups) != 0:
+		grouped := make(map[int32]struct{})
+		kills := []int{}
+		for i, g := range c.Groups {
+			if len(g) == 0 {
+				errorf("group %d is empty", i)
+			}
+			for _, id := range g {
+				if !known(id) {
+					errorf("group %d: unknown replica %d", i, id)
+				} else if _, exists := grouped[id]; exists {
+					errorf("groups overlap: replica %d belongs to several groups", id)
+				}
+				grouped[id] = struct{}{}
+			}
+			// failures that leave no majority in g
+			kills = append(kills, len(g)-len(g)/2)
+		}
+		for id := int32(0); id < int32(n); id++ {
+			if _, exists := grouped[id]; !exists {
+				errorf("replica %d belongs to no group", id)
+			}
+		}
+		sort.Ints(kills)
+		failures := 0
+		for _, k := range kills[:len(kills)-len(kills)/2] {
+			failures += k
+		}
+		if failures <= f {
+			errorf("groups: %d failures can leave no quorum", failures)
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+
+	q := c.Quorum()
+	for i, aq := range c.ActiveQuorums {
+		if !known(aq.Leader) {
+			errorf("active quorum %d: unknown leader %d", i, aq.Leader)
+		}
+		ids := make(map[int32]struct{})
+		for _, id := range aq.Replicas {
+			if !known(id) {
+				errorf("active quorum %d: unknown replica %d", i, id)
+			} else if _, exists := ids[id]; exists {
+				errorf("active quorum %d: replica %d is given twice", i, id)
+			}
+			ids[id] = struct{}{}
+		}
+		if _, exists := ids[aq.Leader]; !exists {
+			errorf("active quorum %d does not contain its leader", i)
+		}
+		if _, ok := q.(Majority); ok && len(ids) != q.Size() {
+			errorf("active quorum %d has %d replicas, %d are required",
+				i, len(ids), q.Size())
+		} else if !IsQuorum(q, aq.Replicas) {
+			errorf("active quorum %d is not a quorum of %v", i, q)
+		}
+		for j, aq2 := range c.ActiveQuorums[:i] {
+			if !intersect(aq.Replicas, aq2.Replicas) {
+				errorf("active quorums %d and %d do not overlap", j, i)
+			}
+		}
+	}
+
+	if c.FastQuorumSize != 0 {
+		slow := NewMajorityOf(n).Size()
+		if len(c.Weights) != 0 || len(c.Groups) != 0 {
+			errorf("fast quorums cannot be combined with weights or groups")
+		} else if c.FastQuorumSize < slow || c.FastQuorumSize > n {
+			errorf("fast quorums must have between %d and %d replicas", slow, n)
+		} else if 2*c.FastQuorumSize+slow <= 2*n {
+			errorf("fast quorums of %d replicas do not overlap "+
+				"with the slow quorums", c.FastQuorumSize)
+		} else if c.FastQuorumSize != NewThreeQuartersOf(n).Size() {
+			errorf("only fast quorums of %d replicas (3/4) are supported",
+				NewThreeQuartersOf(n).Size())
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// Quorum returns the quorum system of a valid configuration.
+func (c *QuorumConfig) Quorum() QuorumI {
+	switch {
+	case len(c.Weights) != 0:
+		return NewWeighted(c.Weights)
+	case len(c.Groups) != 0:
+		return NewMajorityOfMajorities(c.Groups)
+	}
+	return NewMajorityOf(len(c.Replicas))
+}
+
+// activeQuorums returns the active quorums of a valid configuration
+// and their leaders as NewQuorumsFromFile does.
+func (c *QuorumConfig) activeQuorums() ([]Quorum, []int32, error) {
+	var err error
+	if c.FastQuorumSize != 0 {
+		err = THREE_QUARTERS
+	}
+	if len(c.ActiveQuorums) == 0 {
+		return nil, nil, err
+	}
+
+	AQs := make([]Quorum, len(c.ActiveQuorums))
+	leaders := make([]int32, len(c.ActiveQuorums))
+	for i, aq := range c.ActiveQuorums {
+		AQs[i] = NewQuorum(len(aq.Replicas))
+		for _, id := range aq.Replicas {
+			AQs[i][id] = struct{}{}
+		}
+		leaders[i] = aq.Leader
+	}
+	return AQs, leaders, err
+}
+
+func (r *Replica) quorumConfig(qfile string, content []byte) (*QuorumConfig, error) {
+	return parseQuorumConfig(r.PeerAddrList, r.F, qfile, content)
+}
+
+func parseQuorumConfig(addrs []string, f int, qfile string, content []byte) (*QuorumConfig, error) {
+	c, err := ParseQuorumConfig(content)
+	if err == nil {
+		err = c.Validate(addrs, f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", qfile, err)
+	}
+	return c, nil
+}
+
+func intersect(ids1, ids2 []int32) bool {
+	for _, id1 := range ids1 {
+		for _, id2 := range ids2 {
+			if id1 == id2 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package smr
+
+import (
+	"strings"
+	"testing"
+)
+
+const testReplicas = `"replicas": [{"id": 0, "addr": "10.0.0.1"},
+	{"id": 1, "addr": "10.0.0.2"}, {"id": 2, "addr": "10.0.0.3"}]`
+
+func TestQuorumConfigValidate(t *testing.T) {
+	addrs := []string{"10.0.0.1:7070", "10.0.0.2:7070", "10.0.0.3:7070"}
+
+	for _, test := range []struct {
+		config string
+		// the errors expected, none if empty
+		errs []string
+	}{
+		{config: `{` + testReplicas + `}`},
+		{config: `{"f": 1, ` + testReplicas + `,
+			"weights": {"0": 2, "1": 2, "2": 1},
+			"active_quorums": [{"leader": 0, "replicas": [0, 1]},
+			{"leader": 1, "replicas": [1, 2]}]}`},
+		{config: `{` + testReplicas + `,
+			"groups": [[0], [1], [2]]}`},
+		{
+			config: `{"replicas": []}`,
+			errs:   []string{"no replica is given"},
+		},
+		{
+			config: `{"replicas": [{"id": 0, "addr": "10.0.0.1"},
+			{"id": 0, "addr": "10.0.0.1"}, {"id": 3}]}`,
+			errs: []string{"replica 0 is given twice",
+				"address 10.0.0.1 is given twice",
+				"replica 3: ids must range from 0 to 2",
+				"replica 3 has no address"},
+		},
+		{
+			config: `{"replicas": [{"id": 0, "addr": "10.0.0.1"},
+			{"id": 1, "addr": "10.0.0.4"}, {"id": 2, "addr": "10.0.0.3"}]}`,
+			errs: []string{"replica 1: address is 10.0.0.2:7070, not 10.0.0.4"},
+		},
+		{
+			config: `{"f": 2, ` + testReplicas + `}`,
+			errs:   []string{"3 replicas cannot tolerate 2 failures, 5 are required"},
+		},
+		{
+			config: `{` + testReplicas + `,
+			"weights": {"0": 2, "1": 1, "2": 1}}`,
+			errs: []string{"weights: 1 failures can leave no quorum"},
+		},
+		{
+			config: `{` + testReplicas + `,
+			"weights": {"0": 1, "3": -1}}`,
+			errs: []string{"weights: unknown replica 3",
+				"weights: replica 3 has a negative weight"},
+		},
+		{
+			config: `{` + testReplicas + `,
+			"weights": {"0": 1}, "groups": [[0, 1, 2]]}`,
+			errs: []string{"weights and groups cannot be combined"},
+		},
+		{
+			config: `{` + testReplicas + `,
+			"groups": [[0, 1], [1], []]}`,
+			errs: []string{"groups overlap: replica 1 belongs to several groups",
+				"group 2 is empty", "replica 2 belongs to no group"},
+		},
+		{
+			config: `{` + testReplicas + `,
+			"groups": [[0, 1], [2]]}`,
+			errs: []string{"groups: 1 failures can leave no quorum"},
+		},
+		{
+			config: `{` + testReplicas + `,
+			"active_quorums": [{"leader": 0, "replicas": [1, 2]},
+			{"leader": 3, "replicas": [0]}]}`,
+			errs: []string{"active quorum 0 does not contain its leader",
+				"active quorum 1: unknown leader 3",
+				"active quorum 1 has 1 replicas, 2 are required"},
+		},
+		{
+			config: `{` + testReplicas + `,
+			"weights": {"0": 2, "1": 2, "2": 1},
+			"active_quorums": [{"leader": 2, "replicas": [1, 2]},
+			{"leader": 0, "replicas": [0]}]}`,
+			errs: []string{"active quorum 1 is not a quorum",
+				"active quorums 0 and 1 do not overlap"},
+		},
+		{
+			config: `{` + testReplicas + `,
+			"weights": {"0": 2, "1": 2, "2": 1}, "fast_quorum_size": 3}`,
+			errs: []string{"fast quorums cannot be combined with weights or groups"},
+		},
+		{
+			config: `{` + testReplicas + `, "fast_quorum_size": 4}`,
+			errs:   []string{"fast quorums must have between 2 and 3 replicas"},
+		},
+	} {
+		c, err := ParseQuorumConfig([]byte(test.config))
+		if err != nil {
+			t.Errorf("%s: %v", test.config, err)
+			continue
+		}
+		err = c.Validate(addrs, -1)
+		if len(test.errs) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.config, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: no error, want %v", test.config, test.errs)
+			continue
+		}
+		for _, e := range test.errs {
+			if !strings.Contains(err.Error(), e) {
+				t.Errorf("%s: error %q does not report %q", test.config, err, e)
+			}
+		}
+	}
+}
+
+func TestQuorumConfigFailures(t *testing.T) {
+	c, err := ParseQuorumConfig([]byte(`{"f": 1, ` + testReplicas + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(nil, 1); err != nil {
+		t.Error(err)
+	}
+	err = c.Validate(nil, 0)
+	if err == nil || err.Error() != "f is 1, but replicas tolerate 0 failures" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestQuorumConfigUnknownField(t *testing.T) {
+	_, err := ParseQuorumConfig([]byte(`{` + testReplicas + `, "leader": 0}`))
+	if err == nil {
+		t.Error("unknown field accepted")
+	}
+}
@@ -2,8 +2,11 @@ package smr
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
-	"os"
+	"fmt"
+	"io/ioutil"
 	"sort"
 	"strings"
 )
@@ -27,6 +30,10 @@ func (m Majority) Contains(int32) bool {
 	return true
 }
 
+func (m Majority) String() string {
+	return fmt.Sprintf("any %d replicas", int(m))
+}
+
 type ThreeQuarters int
 
 func NewThreeQuartersOf(N int) ThreeQuarters {
@@ -135,6 +142,22 @@ func NewQuorumSystem(quorumSize int, r *Replica, qfile string) (*QuorumSystem, e
 	return sys, err
 }
 
+// WithActiveQuorums returns the quorum system sys in which the
+// preferred active quorums and their leaders are AQs and leaders.
+func (sys *QuorumSystem) WithActiveQuorums(AQs []Quorum, leaders []int32) (*QuorumSystem, error) {
+	newSys := &QuorumSystem{
+		qs:      sys.qs,
+		ballots: make([]int32, len(AQs)),
+	}
+	for i, AQ := range AQs {
+		newSys.ballots[i] = sys.qs.BallotOf(leaders[i], AQ)
+		if newSys.ballots[i] == -1 {
+			return nil, fmt.Errorf("%v is not an active quorum of %d", AQ, leaders[i])
+		}
+	}
+	return newSys, nil
+}
+
 func (sys *QuorumSystem) SameHigher(sameAs, higherThan int32) int32 {
 	l := Leader(sameAs, len(sys.qs))
 	k := higherThan / int32(len(sys.qs[l]))
@@ -161,22 +184,45 @@ func NewQuorumsFromFile(qfile string, r *Replica) ([]Quorum, []int32, error) {
 		return nil, nil, NO_QUORUM_FILE
 	}
 
-	f, err := os.Open(qfile)
+	content, err := readQuorumFile(qfile)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer f.Close()
+	// replicas must agree on the quorums
+	r.qconfig = quorumSystemOf(content)
+	return r.ParseQuorums(qfile, content)
+}
+
+// ParseQuorums returns the active quorums and their leaders given in
+// content, name being the name of the file content comes from.
+func (r *Replica) ParseQuorums(name string, content []byte) ([]Quorum, []int32, error) {
+	var err error
+	if IsQuorumConfig(content) {
+		c, err := r.quorumConfig(name, content)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.activeQuorums()
+	}
 
-	i := 0
+	i, n := 0, 0
 	leaders := []int32{0}
 	AQs := []Quorum{NewQuorum(r.N/2 + 1)}
-	s := bufio.NewScanner(f)
+	s := bufio.NewScanner(bytes.NewReader(content))
 	for s.Scan() {
+		n++
 		id := int32(-1)
 		isLeader := false
 		addr := ""
 
 		data := strings.Split(s.Text(), " ")
+		if data[0] == "" {
+			continue
+		}
+		if data[0] == "weight" || data[0] == "group" {
+			// see QuorumFromFile
+			continue
+		}
 		if len(data) == 1 {
 			if data[0] == "---" {
 				i++
@@ -201,11 +247,12 @@ func NewQuorumsFromFile(qfile string, r *Replica) ([]Quorum, []int32, error) {
 			}
 		}
 
-		if id != -1 {
-			AQs[i][id] = struct{}{}
-			if isLeader {
-				leaders[i] = id
-			}
+		if id == -1 {
+			return nil, nil, fmt.Errorf("%s:%d: unknown replica %s", name, n, addr)
+		}
+		AQs[i][id] = struct{}{}
+		if isLeader {
+			leaders[i] = id
 		}
 	}
 
@@ -215,6 +262,40 @@ func NewQuorumsFromFile(qfile string, r *Replica) ([]Quorum, []int32, error) {
 	return AQs, leaders, err
 }
 
+// quorumSystemOf returns the part of the content of a quorum file that
+// cannot change at runtime: the active quorums are left out, as they
+// can be replaced with InstallQuorums.
+func quorumSystemOf(content []byte) []byte {
+	if IsQuorumConfig(content) {
+		c, err := ParseQuorumConfig(content)
+		if err != nil {
+			return content
+		}
+		c.ActiveQuorums = nil
+		if data, err := json.Marshal(c); err == nil {
+			return data
+		}
+		return content
+	}
+
+	var system []byte
+	for _, line := range strings.Split(string(content), "\n") {
+		data := strings.Fields(line)
+		if len(data) != 0 &&
+			(data[0] == "weight" || data[0] == "group" || data[0] == "3/4") {
+			system = append(system, strings.Join(data, " ")+"\n"...)
+		}
+	}
+	return system
+}
+
+func readQuorumFile(qfile string) ([]byte, error) {
+	if qfile == "" {
+		return nil, NO_QUORUM_FILE
+	}
+	return ioutil.ReadFile(qfile)
+}
+
 func NewQuorumsOfLeader() QuorumsOfLeader {
 	return make(map[int32]Quorum)
 }
@@ -0,0 +1,423 @@
+package smr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vonaka/shreplic/tools/dlog"
+)
+
+// Read-index protocol.
+//
+// A replica that receives a read asks the leader for its current commit
+// index. The leader confirms that it is still the leader by collecting
+// acknowledgments from a read quorum and sends the index back. The
+// replica then waits until it has executed every command up to this
+// index before executing the read: the reads wait by index until the
+// execution loop of the protocol calls ExecutedUpTo. The confirmation round is skipped
+// when the leader holds a valid lease, and a single round confirms all
+// the requests received while the previous round was in progress.
+//
+// The functions of the ReadIndexer read the state of the protocol,
+// hence the messages of the read-index protocol are given to the run
+// loop of the protocol through ReadIndexChan, which must hand them to
+// HandleReadIndex.
+
+var ReadIndexTimeout = 3 * time.Second
+
+// ReadIndexer describes what a protocol must provide to serve reads
+// with the read-index protocol.
+type ReadIndexer struct {
+	// Leader returns the id of the current leader or -1 if unknown
+	Leader func() int32
+	// Ballot returns the ballot of the leader, it is called only
+	// when the replica is the leader
+	Ballot func() int32
+	// Ready returns true if the leader knows every command
+	// committed so far
+	Ready func() bool
+	// Index returns an index such that every command committed so
+	// far is placed at or before this index
+	Index func() int32
+	// Executed returns true if every command placed at or before
+	// the given index is executed, the protocol must call
+	// ExecutedUpTo each time this index grows
+	Executed func(int32) bool
+}
+
+// ReadIndexMsg is a message of the read-index protocol.
+type ReadIndexMsg struct {
+	rid int32
+	msg interface{}
+}
+
+type readIndex struct {
+	m sync.Mutex
+	ReadIndexer
+	enabled bool
+
+	nextId  int32
+	waiting map[int32]*GPropose
+	// reads waiting for the execution of their index
+	reads map[int32][]*GPropose
+
+	// as a leader
+	round   *leaderCheck
+	pending []readIndexReq
+}
+
+type readIndexReq struct {
+	rid  int32
+	id   int32
+	read *GPropose
+}
+
+type leaderCheck struct {
+	id     int32
+	ballot int32
+	index  int32
+	acks   map[int32]struct{}
+	reqs   []readIndexReq
+}
+
+func newReadIndex() *readIndex {
+	return &readIndex{
+		enabled: false,
+		nextId:  0,
+		waiting: make(map[int32]*GPropose),
+		reads:   make(map[int32][]*GPropose),
+	}
+}
+
+// EnableReadIndex allows the replica to serve the reads it
+// receives with ServeRead.
+func (r *Replica) EnableReadIndex(ri ReadIndexer) {
+	r.readIndex.m.Lock()
+	defer r.readIndex.m.Unlock()
+
+	r.readIndex.ReadIndexer = ri
+	r.readIndex.enabled = true
+	r.ReadIndexChan = make(chan ReadIndexMsg, CHAN_BUFFER_SIZE)
+}
+
+// HandleReadIndex handles a message received on ReadIndexChan.
+func (r *Replica) HandleReadIndex(m ReadIndexMsg) {
+	switch msg := m.msg.(type) {
+	case *ReadIndexArgs:
+		r.handleReadIndex(m.rid, msg)
+	case *ReadIndexReply:
+		r.handleReadIndexReply(m.rid, msg)
+	case *LeaderCheck:
+		r.handleLeaderCheck(m.rid, msg)
+	case *LeaderCheckReply:
+		r.handleLeaderCheckReply(m.rid, msg)
+	case *leaderCheck:
+		// the round has timed out
+		r.leaderCheckDone(msg, false)
+	}
+}
+
+// readIndexMsg passes msg, received from replica rid, to the run loop.
+func (r *Replica) readIndexMsg(rid int32, msg interface{}) {
+	if r.ReadIndexChan == nil {
+		// the read-index protocol is not enabled and its
+		// handlers do not access the state of the protocol
+		r.HandleReadIndex(ReadIndexMsg{rid, msg})
+		return
+	}
+	r.ReadIndexChan <- ReadIndexMsg{rid, msg}
+}
+
+// ServeRead executes read locally without breaking linearizability.
+// If this is not possible, read is forwarded to the ProposeChan.
+func (r *Replica) ServeRead(read *GPropose) {
+	ri := r.readIndex
+	if !ri.enabled || !r.Exec {
+		r.forwardRead(read)
+		return
+	}
+
+	leader := ri.Leader()
+	switch {
+	case leader == r.Id:
+		if !ri.Ready() {
+			r.forwardRead(read)
+		} else if r.HasLease(ri.Ballot()) {
+			r.readAt(read, ri.Index())
+		} else {
+			r.checkLeadership(readIndexReq{
+				rid:  r.Id,
+				read: read,
+			})
+		}
+
+	case leader >= 0:
+		ri.m.Lock()
+		id := ri.nextId
+		ri.nextId++
+		ri.waiting[id] = read
+		ri.m.Unlock()
+
+		time.AfterFunc(ReadIndexTimeout, func() {
+			ri.m.Lock()
+			read, exists := ri.waiting[id]
+			delete(ri.waiting, id)
+			ri.m.Unlock()
+			if exists {
+				r.forwardRead(read)
+			}
+		})
+		r.SendMsg(leader, GENERIC_SMR_READ_INDEX, &ReadIndexArgs{
+			Id: id,
+		})
+
+	default:
+		r.forwardRead(read)
+	}
+}
+
+func (r *Replica) forwardRead(read *GPropose) {
+	dlog.Println("forwarding read", read.CommandId)
+	go func() {
+		r.ProposeChan <- read
+	}()
+}
+
+// readAt serves read once every command up to index is executed,
+// or forwards it after ReadIndexTimeout.
+func (r *Replica) readAt(read *GPropose, index int32) {
+	ri := r.readIndex
+	ri.m.Lock()
+	if ri.Executed(index) {
+		ri.m.Unlock()
+		r.serveRead(read)
+		return
+	}
+	ri.reads[index] = append(ri.reads[index], read)
+	ri.m.Unlock()
+
+	time.AfterFunc(ReadIndexTimeout, func() {
+		ri.m.Lock()
+		reads := ri.reads[index]
+		for i, rd := range reads {
+			if rd == read {
+				reads = append(reads[:i], reads[i+1:]...)
+				if len(reads) == 0 {
+					delete(ri.reads, index)
+				} else {
+					ri.reads[index] = reads
+				}
+				ri.m.Unlock()
+				r.forwardRead(read)
+				return
+			}
+		}
+		ri.m.Unlock()
+	})
+}
+
+// ExecutedUpTo must be called by the protocol once every command
+// placed at or before index is executed. It serves the reads waiting
+// for this index.
+func (r *Replica) ExecutedUpTo(index int32) {
+	ri := r.readIndex
+	ri.m.Lock()
+	if len(ri.reads) == 0 {
+		ri.m.Unlock()
+		return
+	}
+	var ready []*GPropose
+	for i, reads := range ri.reads {
+		if i <= index {
+			ready = append(ready, reads...)
+			delete(ri.reads, i)
+		}
+	}
+	ri.m.Unlock()
+
+	for _, read := range ready {
+		r.serveRead(read)
+	}
+}
+
+func (r *Replica) serveRead(read *GPropose) {
+	r.ReplyProposeTS(&ProposeReplyTS{
+		OK:        TRUE,
+		CommandId: read.CommandId,
+		Value:     read.Command.Execute(r.State),
+		Timestamp: read.Timestamp,
+	}, read.Reply, read.Mutex)
+}
+
+func (r *Replica) checkLeadership(req readIndexReq) {
+	ri := r.readIndex
+	ri.m.Lock()
+	ri.pending = append(ri.pending, req)
+	if ri.round != nil {
+		ri.m.Unlock()
+		return
+	}
+	check := r.newLeaderCheck()
+	ri.m.Unlock()
+
+	r.sendLeaderCheck(check)
+}
+
+// must be called with readIndex.m locked
+func (r *Replica) newLeaderCheck() *leaderCheck {
+	ri := r.readIndex
+	check := &leaderCheck{
+		id:     ri.nextId,
+		ballot: ri.Ballot(),
+		index:  ri.Index(),
+		acks:   make(map[int32]struct{}),
+		reqs:   ri.pending,
+	}
+	ri.nextId++
+	ri.pending = nil
+	ri.round = check
+	return check
+}
+
+func (r *Replica) sendLeaderCheck(check *leaderCheck) {
+	if r.hasQuorum(nil, r.ReadQuorumSize()) {
+		r.leaderCheckDone(check, true)
+		return
+	}
+
+	time.AfterFunc(ReadIndexTimeout, func() {
+		r.ReadIndexChan <- ReadIndexMsg{r.Id, check}
+	})
+
+	lc := &LeaderCheck{
+		Ballot: check.ballot,
+		Id:     check.id,
+	}
+	for p := int32(0); p < int32(r.N); p++ {
+		if p == r.Id {
+			continue
+		}
+		r.M.Lock()
+		alive := r.Alive[p]
+		r.M.Unlock()
+		if alive {
+			r.SendMsg(p, GENERIC_SMR_LEADER_CHECK, lc)
+		}
+	}
+}
+
+func (r *Replica) leaderCheckDone(check *leaderCheck, confirmed bool) {
+	ri := r.readIndex
+	ri.m.Lock()
+	if ri.round != check {
+		ri.m.Unlock()
+		return
+	}
+	ri.round = nil
+	var next *leaderCheck
+	if len(ri.pending) > 0 {
+		next = r.newLeaderCheck()
+	}
+	ri.m.Unlock()
+
+	ok := FALSE
+	if confirmed {
+		ok = TRUE
+	}
+	for _, req := range check.reqs {
+		if req.read == nil {
+			r.SendMsg(req.rid, GENERIC_SMR_READ_INDEX_REPLY, &ReadIndexReply{
+				Id:    req.id,
+				Index: check.index,
+				OK:    ok,
+			})
+		} else if confirmed {
+			r.readAt(req.read, check.index)
+		} else {
+			r.forwardRead(req.read)
+		}
+	}
+
+	if next != nil {
+		r.sendLeaderCheck(next)
+	}
+}
+
+func (r *Replica) handleReadIndex(rid int32, args *ReadIndexArgs) {
+	ri := r.readIndex
+	if !ri.enabled || ri.Leader() != r.Id || !ri.Ready() {
+		r.SendMsg(rid, GENERIC_SMR_READ_INDEX_REPLY, &ReadIndexReply{
+			Id:    args.Id,
+			Index: -1,
+			OK:    FALSE,
+		})
+		return
+	}
+
+	if r.HasLease(ri.Ballot()) {
+		r.SendMsg(rid, GENERIC_SMR_READ_INDEX_REPLY, &ReadIndexReply{
+			Id:    args.Id,
+			Index: ri.Index(),
+			OK:    TRUE,
+		})
+		return
+	}
+
+	r.checkLeadership(readIndexReq{
+		rid: rid,
+		id:  args.Id,
+	})
+}
+
+func (r *Replica) handleReadIndexReply(rid int32, rep *ReadIndexReply) {
+	ri := r.readIndex
+	ri.m.Lock()
+	read, exists := ri.waiting[rep.Id]
+	delete(ri.waiting, rep.Id)
+	ri.m.Unlock()
+
+	if !exists {
+		return
+	}
+	if rep.OK == TRUE {
+		r.readAt(read, rep.Index)
+	} else {
+		r.forwardRead(read)
+	}
+}
+
+func (r *Replica) handleLeaderCheck(rid int32, lc *LeaderCheck) {
+	ballot := r.observedBallot()
+	ok := FALSE
+	if lc.Ballot >= ballot {
+		ok = TRUE
+	}
+	r.SendMsg(rid, GENERIC_SMR_LEADER_CHECK_REPLY, &LeaderCheckReply{
+		Ballot: ballot,
+		Id:     lc.Id,
+		OK:     ok,
+	})
+}
+
+func (r *Replica) handleLeaderCheckReply(rid int32, rep *LeaderCheckReply) {
+	ri := r.readIndex
+	ri.m.Lock()
+	check := ri.round
+	if check == nil || check.id != rep.Id {
+		ri.m.Unlock()
+		return
+	}
+	if rep.OK != TRUE {
+		ri.m.Unlock()
+		r.ObserveBallot(rep.Ballot)
+		r.leaderCheckDone(check, false)
+		return
+	}
+	check.acks[rid] = struct{}{}
+	confirmed := r.hasQuorum(check.acks, r.ReadQuorumSize())
+	ri.m.Unlock()
+
+	if confirmed {
+		r.leaderCheckDone(check, true)
+	}
+}
@@ -0,0 +1,185 @@
+package smr
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vonaka/shreplic/state"
+)
+
+// newTestLeader returns the leader of ballot 1 out of n replicas, whose
+// commands up to executed are executed.
+func newTestLeader(t *testing.T, n int, executed *int32) *Replica {
+	r := newTestReplica(t, 0, n)
+	r.EnableReadIndex(ReadIndexer{
+		Leader: func() int32 {
+			return 0
+		},
+		Ballot: func() int32 {
+			return 1
+		},
+		Ready: func() bool {
+			return true
+		},
+		Index: func() int32 {
+			return 10
+		},
+		Executed: func(i int32) bool {
+			return atomic.LoadInt32(executed) >= i
+		},
+	})
+	return r
+}
+
+func TestReadIndexRounds(t *testing.T) {
+	executed := int32(0)
+	r := newTestLeader(t, 3, &executed)
+	ri := r.readIndex
+
+	r.checkLeadership(readIndexReq{rid: 1, id: 1})
+	first := ri.round
+	if first == nil || len(first.reqs) != 1 {
+		t.Fatal("no round for the first request")
+	}
+	// requests received during a round wait for the next one
+	r.checkLeadership(readIndexReq{rid: 2, id: 2})
+	r.checkLeadership(readIndexReq{rid: 2, id: 3})
+	if ri.round != first || len(ri.pending) != 2 {
+		t.Fatal("a second round started before the end of the first")
+	}
+	// a late acknowledgment of another round is ignored
+	r.handleLeaderCheckReply(1, &LeaderCheckReply{Id: first.id + 1, OK: TRUE})
+	if ri.round != first {
+		t.Fatal("round ended by an acknowledgment of another round")
+	}
+
+	r.handleLeaderCheckReply(1, &LeaderCheckReply{Id: first.id, Ballot: 1, OK: TRUE})
+	second := ri.round
+	if second == nil || second == first || len(second.reqs) != 2 || len(ri.pending) != 0 {
+		t.Fatal("the pending requests are not confirmed by a single round")
+	}
+
+	r.handleLeaderCheckReply(2, &LeaderCheckReply{Id: second.id, Ballot: 3, OK: FALSE})
+	if ri.round != nil {
+		t.Fatal("round not ended by a refusal")
+	}
+	if b := r.observedBallot(); b != 3 {
+		t.Fatalf("observed ballot %d instead of 3", b)
+	}
+}
+
+func TestReadIndexTimeout(t *testing.T) {
+	timeout := ReadIndexTimeout
+	ReadIndexTimeout = 10 * time.Millisecond
+	defer func() {
+		ReadIndexTimeout = timeout
+	}()
+
+	executed := int32(0)
+	r := newTestLeader(t, 3, &executed)
+	r.Alive[1], r.Alive[2] = true, true
+
+	r.checkLeadership(readIndexReq{rid: 1, id: 1})
+	select {
+	case m := <-r.ReadIndexChan:
+		r.HandleReadIndex(m)
+	case <-time.After(time.Second):
+		t.Fatal("the round does not time out")
+	}
+	if r.readIndex.round != nil {
+		t.Fatal("round still running after its timeout")
+	}
+}
+
+func TestReadIndexLocalRead(t *testing.T) {
+	executed := int32(0)
+	r := newTestLeader(t, 3, &executed)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	w := bufio.NewWriter(server)
+	r.links.addClient(w, server, false)
+
+	put := state.Command{Op: state.PUT, K: state.Key(7), V: state.Value("v")}
+	put.Execute(r.State)
+	r.ServeRead(&GPropose{
+		Propose: &Propose{
+			CommandId: 42,
+			Command: state.Command{
+				Op: state.GET,
+				K:  state.Key(7),
+				V:  state.NIL(),
+			},
+		},
+		Reply: w,
+		Mutex: new(sync.Mutex),
+	})
+	r.handleLeaderCheckReply(1, &LeaderCheckReply{Id: r.readIndex.round.id, Ballot: 1, OK: TRUE})
+
+	replies := make(chan *ProposeReplyTS)
+	go func() {
+		rep := &ProposeReplyTS{}
+		if rep.Unmarshal(bufio.NewReader(client)) == nil {
+			replies <- rep
+		}
+	}()
+
+	select {
+	case <-replies:
+		t.Fatal("read served before the execution of the index")
+	case <-time.After(20 * time.Millisecond):
+	}
+	atomic.StoreInt32(&executed, 9)
+	r.ExecutedUpTo(9)
+	select {
+	case <-replies:
+		t.Fatal("read served before the execution of the index")
+	case <-time.After(20 * time.Millisecond):
+	}
+	atomic.StoreInt32(&executed, 10)
+	r.ExecutedUpTo(10)
+	select {
+	case rep := <-replies:
+		if rep.OK != TRUE || rep.CommandId != 42 || string(rep.Value) != "v" {
+			t.Fatalf("wrong reply %+v", rep)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read not served")
+	}
+}
+
+func TestReadIndexQueueTimeout(t *testing.T) {
+	timeout := ReadIndexTimeout
+	ReadIndexTimeout = 10 * time.Millisecond
+	defer func() {
+		ReadIndexTimeout = timeout
+	}()
+
+	executed := int32(0)
+	r := newTestLeader(t, 3, &executed)
+	r.ProposeChan = make(chan *GPropose, 1)
+
+	read := &GPropose{
+		Propose: &Propose{CommandId: 1},
+	}
+	r.readAt(read, 5)
+	select {
+	case p := <-r.ProposeChan:
+		if p != read {
+			t.Fatal("another read forwarded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read not forwarded after the timeout")
+	}
+	r.readIndex.m.Lock()
+	n := len(r.readIndex.reads)
+	r.readIndex.m.Unlock()
+	if n != 0 {
+		t.Fatalf("%d indexes still wait after the timeout", n)
+	}
+}
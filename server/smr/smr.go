@@ -2,16 +2,15 @@ package smr
 
 import (
 	"bufio"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"math"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vonaka/shreplic/state"
@@ -36,15 +35,20 @@ type Replica struct {
 	N  int
 	F  int
 	Id int32
-
-	PeerAddrList       []string
-	Peers              []net.Conn
-	PeerReaders        []*bufio.Reader
-	PeerWriters        []*bufio.Writer
-	ClientWriters      map[int32]*bufio.Writer
-	ProxyAddrs         map[string]struct{}
-	Alive              []bool
-	PreferredPeerOrder []int32
+	// Protocol is the name of the protocol run by the replica
+	Protocol string
+
+	PeerAddrList  []string
+	Peers         []net.Conn
+	PeerReaders   []*bufio.Reader
+	PeerWriters   []*bufio.Writer
+	ClientWriters map[int32]*bufio.Writer
+	ProxyAddrs    map[string]struct{}
+	Alive         []bool
+	// FixedPeerOrder is true if the protocol orders the peers itself;
+	// the latency probes then leave the order found by
+	// ComputeClosestPeers untouched
+	FixedPeerOrder bool
 
 	State       *state.State
 	RPC         *fastrpc.Table
@@ -54,6 +58,12 @@ type Replica struct {
 	Listener    net.Listener
 	ProposeChan chan *GPropose
 	BeaconChan  chan *GBeacon
+	// ReadChan is non-nil if the protocol is able to serve
+	// linearizable local reads
+	ReadChan chan *GPropose
+	// ReadIndexChan is non-nil once the read-index
+	// protocol is enabled (see EnableReadIndex)
+	ReadIndexChan chan ReadIndexMsg
 
 	Thrifty bool
 	Exec    bool
@@ -64,6 +74,21 @@ type Replica struct {
 
 	Ewma      []float64
 	Latencies []int64
+	// number of beacon replies summed up in Latencies
+	replies []int64
+	probing sync.Once
+	// peerOrder holds the []int32 returned by PeerOrder
+	peerOrder atomic.Value
+
+	config    []byte
+	qconfig   []byte
+	quorum    QuorumI
+	admission *admission
+	links     *links
+	fd        *failureDetector
+	lease     *lease
+	readIndex *readIndex
+	epoch     int32
 }
 
 const (
@@ -77,6 +102,14 @@ var (
 	StoreFilname = "stable_store"
 )
 
+// ErrFixedQuorums is returned by InstallQuorums if the protocol cannot
+// change its quorums at runtime.
+var ErrFixedQuorums = errors.New("the protocol cannot change its quorums at runtime")
+
+// ErrNoTransfer is returned by BeTheLeader if the protocol cannot
+// change its leader.
+var ErrNoTransfer = errors.New("the protocol cannot transfer its leadership")
+
 func NewReplica(id, f int, addrs []string, thrifty, exec, lread, drep bool, ps map[string]struct{}) *Replica {
 	n := len(addrs)
 	r := &Replica{
@@ -84,14 +117,15 @@ func NewReplica(id, f int, addrs []string, thrifty, exec, lread, drep bool, ps m
 		F:  f,
 		Id: int32(id),
 
-		PeerAddrList:       addrs,
-		Peers:              make([]net.Conn, n),
-		PeerReaders:        make([]*bufio.Reader, n),
-		PeerWriters:        make([]*bufio.Writer, n),
-		ClientWriters:      make(map[int32]*bufio.Writer),
-		ProxyAddrs:         ps,
-		Alive:              make([]bool, n),
-		PreferredPeerOrder: make([]int32, n),
+		Protocol: "",
+
+		PeerAddrList:  addrs,
+		Peers:         make([]net.Conn, n),
+		PeerReaders:   make([]*bufio.Reader, n),
+		PeerWriters:   make([]*bufio.Writer, n),
+		ClientWriters: make(map[int32]*bufio.Writer),
+		ProxyAddrs:    ps,
+		Alive:         make([]bool, n),
 
 		State:       state.InitState(),
 		RPC:         fastrpc.NewTableId(RPC_TABLE),
@@ -99,8 +133,11 @@ func NewReplica(id, f int, addrs []string, thrifty, exec, lread, drep bool, ps m
 		Stats:       &Stats{make(map[string]int)},
 		Shutdown:    false,
 		Listener:    nil,
-		ProposeChan: make(chan *GPropose, CHAN_BUFFER_SIZE),
+		ProposeChan: make(chan *GPropose, MaxPendingProposals),
 		BeaconChan:  make(chan *GBeacon, CHAN_BUFFER_SIZE),
+		ReadChan:    nil,
+
+		ReadIndexChan: nil,
 
 		Thrifty: thrifty,
 		Exec:    exec,
@@ -111,6 +148,15 @@ func NewReplica(id, f int, addrs []string, thrifty, exec, lread, drep bool, ps m
 
 		Ewma:      make([]float64, n),
 		Latencies: make([]int64, n),
+		replies:   make([]int64, n),
+
+		epoch:     InitialEpoch,
+		config:    nil,
+		admission: newAdmission(),
+		links:     newLinks(n),
+		fd:        newFailureDetector(n),
+		lease:     newLease(),
+		readIndex: newReadIndex(),
 	}
 
 	var err error
@@ -119,11 +165,13 @@ func NewReplica(id, f int, addrs []string, thrifty, exec, lread, drep bool, ps m
 		log.Fatal(err)
 	}
 
+	order := make([]int32, n)
 	for i := 0; i < r.N; i++ {
-		r.PreferredPeerOrder[i] = int32((int(r.Id) + 1 + i) % r.N)
+		order[i] = int32((int(r.Id) + 1 + i) % r.N)
 		r.Ewma[i] = 0.0
 		r.Latencies[i] = 0
 	}
+	r.peerOrder.Store(order)
 
 	return r
 }
@@ -132,10 +180,29 @@ func (r *Replica) Ping(args *PingArgs, reply *PingReply) error {
 	return nil
 }
 
+// BeTheLeader makes the replica the leader. Protocols that change their
+// leader with the ballot override it: CURP and n²Paxos never change
+// their ballot, so their leader is fixed.
 func (r *Replica) BeTheLeader(args *BeTheLeaderArgs, reply *BeTheLeaderReply) error {
+	return ErrNoTransfer
+}
+
+// GetLeader gives the leader known by the replica. It lets the
+// clients find the leader without the master (see Cluster).
+func (r *Replica) GetLeader(args *GetLeaderArgs, reply *GetLeaderReply) error {
+	reply.Leader = -1
 	return nil
 }
 
+// InstallQuorums replaces the quorum file of the replica. Protocols
+// supporting it start a new ballot at the leader of the first active
+// quorum, from which every replica uses this quorum. Only Paxoi does:
+// CURP and n²Paxos never change their ballot, so their quorums are
+// those of their quorum file.
+func (r *Replica) InstallQuorums(args *InstallQuorumsArgs, reply *InstallQuorumsReply) error {
+	return ErrFixedQuorums
+}
+
 func (r *Replica) FastQuorumSize() int {
 	return r.F + (r.F+1)/2
 }
@@ -152,9 +219,35 @@ func (r *Replica) ReadQuorumSize() int {
 	return r.N - r.F
 }
 
+// SetQuorum must be called by the protocols that do not rely on
+// majorities, leases and read indices then wait for a quorum of q
+// instead of WriteQuorumSize or ReadQuorumSize replicas.
+func (r *Replica) SetQuorum(q QuorumI) {
+	r.quorum = q
+}
+
+// hasQuorum returns true if ids and r form a quorum, or
+// at least size replicas if the quorum is not set.
+func (r *Replica) hasQuorum(ids map[int32]struct{}, size int) bool {
+	if r.quorum == nil {
+		return len(ids)+1 >= size
+	}
+	rs := []int32{r.Id}
+	for id := range ids {
+		rs = append(rs, id)
+	}
+	return IsQuorum(r.quorum, rs)
+}
+
 func (r *Replica) ConnectToPeers() {
-	var b [4]byte
-	bs := b[:4]
+	if Join {
+		r.joinPeers()
+		if FailureDetection {
+			go r.detectFailures()
+		}
+		return
+	}
+
 	done := make(chan bool)
 
 	go r.waitForPeerConnections(done)
@@ -167,14 +260,20 @@ func (r *Replica) ConnectToPeers() {
 			}
 			time.Sleep(1e9)
 		}
-		binary.LittleEndian.PutUint32(bs, uint32(r.Id))
-		if _, err := r.Peers[i].Write(bs); err != nil {
-			log.Println("Write id error:", err)
+		reader := bufio.NewReader(r.Peers[i])
+		writer := bufio.NewWriter(r.Peers[i])
+		reply, err := Handshake(reader, writer, r.hello())
+		if err != nil {
+			log.Println("Handshake error:", err)
 			continue
 		}
+		if reply.OK != TRUE {
+			log.Fatalf("Replica %d rejected the connection: %s", i, reply.Reason)
+		}
 		r.Alive[i] = true
-		r.PeerReaders[i] = bufio.NewReader(r.Peers[i])
-		r.PeerWriters[i] = bufio.NewWriter(r.Peers[i])
+		r.PeerReaders[i] = reader
+		r.PeerWriters[i] = writer
+		r.links.setPeer(int32(i), writer, r.Peers[i], reply.Compression)
 		log.Printf("OUT Connected to %d", i)
 	}
 	<-done
@@ -187,6 +286,10 @@ func (r *Replica) ConnectToPeers() {
 		}
 		go r.replicaListener(rid, reader)
 	}
+
+	if FailureDetection {
+		go r.detectFailures()
+	}
 }
 
 func (r *Replica) WaitForClientConnections() {
@@ -203,91 +306,74 @@ func (r *Replica) WaitForClientConnections() {
 }
 
 func (r *Replica) SendMsg(peerId int32, code uint8, msg fastrpc.Serializable) {
-	r.M.Lock()
-	defer r.M.Unlock()
+	r.sendMsg(peerId, code, msg)
+}
 
-	w := r.PeerWriters[peerId]
-	if w == nil {
+func (r *Replica) sendMsg(peerId int32, code uint8, msg marshaler) {
+	l := r.links.peer(peerId)
+	if l == nil {
 		log.Printf("Connection to %d lost!", peerId)
 		return
 	}
-	w.WriteByte(code)
-	msg.Marshal(w)
-	w.Flush()
+	l.send(code, msg)
 }
 
 func (r *Replica) SendClientMsg(id int32, code uint8, msg fastrpc.Serializable) {
 	r.M.Lock()
-	defer r.M.Unlock()
-
 	w := r.ClientWriters[id]
+	r.M.Unlock()
+
 	if w == nil {
 		log.Printf("Connection to client %d lost!", id)
 		return
 	}
-	w.WriteByte(code)
-	msg.Marshal(w)
-	w.Flush()
+	if a, ok := msg.(Answer); ok {
+		r.releaseInFlight(w, a.AnsweredCommand())
+	}
+	r.links.client(w).send(code, msg)
 }
 
+// SendMsgNoFlush is SendMsg, links are flushed once they are idle.
 func (r *Replica) SendMsgNoFlush(peerId int32, code uint8, msg fastrpc.Serializable) {
-	r.M.Lock()
-	defer r.M.Unlock()
-
-	w := r.PeerWriters[peerId]
-	if w == nil {
-		log.Printf("Connection to %d lost!", peerId)
-		return
-	}
-	w.WriteByte(code)
-	msg.Marshal(w)
+	r.SendMsg(peerId, code, msg)
 }
 
 func (r *Replica) ReplyProposeTS(reply *ProposeReplyTS, w *bufio.Writer, lock *sync.Mutex) {
-	r.M.Lock()
-	defer r.M.Unlock()
-
-	reply.Marshal(w)
-	w.Flush()
+	r.releaseInFlight(w, reply.CommandId)
+	reply.Epoch = r.Epoch()
+	r.links.client(w).sendNoCode(reply)
 }
 
 func (r *Replica) SendBeacon(peerId int32) {
-	r.M.Lock()
-	defer r.M.Unlock()
+	r.sendBeacon(peerId)
+}
 
-	w := r.PeerWriters[peerId]
-	if w == nil {
-		log.Printf("Connection to %d lost!", peerId)
-		return
-	}
-	w.WriteByte(GENERIC_SMR_BEACON)
+// sendBeacon returns the timestamp of the beacon.
+func (r *Replica) sendBeacon(peerId int32) int64 {
 	beacon := &Beacon{
 		Timestamp: time.Now().UnixNano(),
 	}
-	beacon.Marshal(w)
-	w.Flush()
+	r.sendMsg(peerId, GENERIC_SMR_BEACON, beacon)
 	dlog.Println("send beacon", beacon.Timestamp, "to", peerId)
+	return beacon.Timestamp
 }
 
 func (r *Replica) ReplyBeacon(beacon *GBeacon) {
 	dlog.Println("replying beacon to", beacon.Rid)
 
-	r.M.Lock()
-	defer r.M.Unlock()
-
-	w := r.PeerWriters[beacon.Rid]
-	if w == nil {
-		log.Printf("Connection to %d lost!", beacon.Rid)
-		return
-	}
-	w.WriteByte(GENERIC_SMR_BEACON_REPLY)
-	rb := &BeaconReply{
+	r.sendMsg(beacon.Rid, GENERIC_SMR_BEACON_REPLY, &BeaconReply{
 		Timestamp: beacon.Timestamp,
-	}
-	rb.Marshal(w)
-	w.Flush()
+	})
+}
+
+// PeerOrder returns the peers in the preferred order, the closest
+// first. The returned slice is shared and must not be modified.
+func (r *Replica) PeerOrder() []int32 {
+	return r.peerOrder.Load().([]int32)
 }
 
+// UpdatePreferredPeerOrder puts the peers of quorum first in the
+// preferred order, the other peers keeping their relative order.
 func (r *Replica) UpdatePreferredPeerOrder(quorum []int32) {
 	aux := make([]int32, r.N)
 	i := 0
@@ -299,7 +385,7 @@ func (r *Replica) UpdatePreferredPeerOrder(quorum []int32) {
 		i++
 	}
 
-	for _, p := range r.PreferredPeerOrder {
+	for _, p := range r.PeerOrder() {
 		found := false
 		for j := 0; j < i; j++ {
 			if aux[j] == p {
@@ -313,86 +399,49 @@ func (r *Replica) UpdatePreferredPeerOrder(quorum []int32) {
 		}
 	}
 
-	r.M.Lock()
-	r.PreferredPeerOrder = aux
-	r.M.Unlock()
-}
-
-func (r *Replica) ComputeClosestPeers() []float64 {
-	npings := 20
-
-	for j := 0; j < npings; j++ {
-		for i := int32(0); i < int32(r.N); i++ {
-			if i == r.Id {
-				continue
-			}
-			r.M.Lock()
-			if r.Alive[i] {
-				r.M.Unlock()
-				r.SendBeacon(i)
-			} else {
-				r.Latencies[i] = math.MaxInt64
-				r.M.Unlock()
-			}
-		}
-		time.Sleep(500 * time.Millisecond)
-	}
-
-	quorum := make([]int32, r.N)
-
-	r.M.Lock()
-	for i := int32(0); i < int32(r.N); i++ {
-		pos := 0
-		for j := int32(0); j < int32(r.N); j++ {
-			if (r.Latencies[j] < r.Latencies[i]) ||
-				((r.Latencies[j] == r.Latencies[i]) && (j < i)) {
-				pos++
-			}
-		}
-		quorum[pos] = int32(i)
-	}
-	r.M.Unlock()
-
-	r.UpdatePreferredPeerOrder(quorum)
-
-	latencies := make([]float64, r.N-1)
-
-	for i := 0; i < r.N-1; i++ {
-		node := r.PreferredPeerOrder[i]
-		lat := float64(r.Latencies[node]) / float64(npings*1000000)
-		log.Println(node, "->", lat, "ms")
-		latencies[i] = lat
-	}
-
-	return latencies
+	r.peerOrder.Store(aux)
 }
 
 func (r *Replica) waitForPeerConnections(done chan bool) {
-	var b [4]byte
-	bs := b[:4]
-
 	port := strings.Split(r.PeerAddrList[r.Id], ":")[1]
 	l, err := net.Listen("tcp", "0.0.0.0:"+port)
 	if err != nil {
 		log.Fatal(r.PeerAddrList[r.Id], err)
 	}
 	r.Listener = l
-	for i := r.Id + 1; i < int32(r.N); i++ {
+	for i := r.Id + 1; i < int32(r.N); {
 		conn, err := r.Listener.Accept()
 		if err != nil {
 			log.Println("Accept error:", err)
 			continue
 		}
-		if _, err := io.ReadFull(conn, bs); err != nil {
-			log.Println("Connection establish error:", err)
+		reader := bufio.NewReader(conn)
+		writer := bufio.NewWriter(conn)
+		hello, err := r.acceptHello(reader, writer)
+		if err != nil {
+			if err != errProbe {
+				log.Println("Connection rejected:", err)
+			}
+			conn.Close()
+			continue
+		}
+		if hello.Kind == HELLO_CLIENT {
+			go r.serveClient(conn, reader, writer, hello)
+			continue
+		}
+		id := hello.Id
+		if id < r.Id || r.Peers[id] != nil {
+			log.Println("Unexpected connection from", id)
+			conn.Close()
 			continue
 		}
-		id := int32(binary.LittleEndian.Uint32(bs))
 		r.Peers[id] = conn
-		r.PeerReaders[id] = bufio.NewReader(conn)
-		r.PeerWriters[id] = bufio.NewWriter(conn)
+		r.PeerReaders[id] = reader
+		r.PeerWriters[id] = writer
+		r.links.setPeer(id, writer, conn, compression(hello.Compression))
 		r.Alive[id] = true
 		log.Printf("IN Connected to %d", id)
+		i++
 	}
 
 	done <- true
@@ -404,17 +453,29 @@ func (r *Replica) replicaListener(rid int, reader *bufio.Reader) {
 		err          error = nil
 		gbeacon      Beacon
 		gbeaconReply BeaconReply
+		leaseReq     LeaseRequest
+		leaseReply   LeaseReply
+		z            = newDecompressor()
 	)
 
 	for err == nil && !r.Shutdown {
-		if msgType, err = reader.ReadByte(); err != nil {
+		// the messages of the last compressed batch come first
+		in := byteReader(reader)
+		if z.batch.Len() > 0 {
+			in = &z.batch
+		}
+		if msgType, err = in.ReadByte(); err != nil {
 			break
 		}
 
 		switch uint8(msgType) {
 
+		case GENERIC_SMR_COMPRESSED:
+			err = z.read(reader)
+			break
+
 		case GENERIC_SMR_BEACON:
-			if err = gbeacon.Unmarshal(reader); err != nil {
+			if err = gbeacon.Unmarshal(in); err != nil {
 				break
 			}
 			r.ReplyBeacon(&GBeacon{
@@ -424,22 +485,67 @@ func (r *Replica) replicaListener(rid int, reader *bufio.Reader) {
 			break
 
 		case GENERIC_SMR_BEACON_REPLY:
-			if err = gbeaconReply.Unmarshal(reader); err != nil {
+			if err = gbeaconReply.Unmarshal(in); err != nil {
 				break
 			}
 			dlog.Println("receive beacon", gbeaconReply.Timestamp, "reply from", rid)
+			r.fd.heartbeat(int32(rid), gbeaconReply.Timestamp)
 			r.M.Lock()
-			r.Latencies[rid] += time.Now().UnixNano() - gbeaconReply.Timestamp
+			r.observeRTT(int32(rid), time.Now().UnixNano()-gbeaconReply.Timestamp)
 			r.M.Unlock()
-			now := time.Now().UnixNano()
-			r.Ewma[rid] = 0.99*r.Ewma[rid] + 0.01*float64(now-gbeaconReply.Timestamp)
+			break
+
+		case GENERIC_SMR_LEASE:
+			if err = leaseReq.Unmarshal(in); err != nil {
+				break
+			}
+			r.handleLeaseRequest(int32(rid), &leaseReq)
+			break
+
+		case GENERIC_SMR_LEASE_REPLY:
+			if err = leaseReply.Unmarshal(in); err != nil {
+				break
+			}
+			r.handleLeaseReply(int32(rid), &leaseReply)
+			break
+
+		case GENERIC_SMR_READ_INDEX:
+			riArgs := &ReadIndexArgs{}
+			if err = riArgs.Unmarshal(in); err != nil {
+				break
+			}
+			r.readIndexMsg(int32(rid), riArgs)
+			break
+
+		case GENERIC_SMR_READ_INDEX_REPLY:
+			riReply := &ReadIndexReply{}
+			if err = riReply.Unmarshal(in); err != nil {
+				break
+			}
+			r.readIndexMsg(int32(rid), riReply)
+			break
+
+		case GENERIC_SMR_LEADER_CHECK:
+			lc := &LeaderCheck{}
+			if err = lc.Unmarshal(in); err != nil {
+				break
+			}
+			r.readIndexMsg(int32(rid), lc)
+			break
+
+		case GENERIC_SMR_LEADER_CHECK_REPLY:
+			lcReply := &LeaderCheckReply{}
+			if err = lcReply.Unmarshal(in); err != nil {
+				break
+			}
+			r.readIndexMsg(int32(rid), lcReply)
 			break
 
 		default:
 			p, exists := r.RPC.Get(msgType)
 			if exists {
 				obj := p.Obj.New()
-				if err = obj.Unmarshal(reader); err != nil {
+				if err = obj.Unmarshal(in); err != nil {
 					break
 				}
 				go func(obj fastrpc.Serializable) {
@@ -452,14 +558,42 @@ func (r *Replica) replicaListener(rid int, reader *bufio.Reader) {
 	}
 
 	r.M.Lock()
-	r.Alive[rid] = false
+	// the peer may have reconnected in the meantime
+	current := r.PeerReaders[rid] == reader
+	if current {
+		r.Alive[rid] = false
+	}
 	r.M.Unlock()
+	if current {
+		r.fd.lost(int32(rid))
+	}
 }
 
 func (r *Replica) clientListener(conn net.Conn) {
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 
+	hello, err := r.acceptHello(reader, writer)
+	if err == nil && hello.Kind == HELLO_PEER {
+		// a peer that joins the running replicas
+		err = r.addPeer(hello.Id, conn, reader, writer, compression(hello.Compression))
+		if err == nil {
+			log.Printf("IN Connected to %d", hello.Id)
+			return
+		}
+	}
+	if err != nil {
+		if err != errProbe {
+			log.Println("Client", conn.RemoteAddr(), "rejected:", err)
+		}
+		conn.Close()
+		return
+	}
+
+	r.serveClient(conn, reader, writer, hello)
+}
+
+func (r *Replica) serveClient(conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, hello *Hello) {
 	var (
 		msgType byte
 		err     error
@@ -473,6 +607,7 @@ func (r *Replica) clientListener(conn net.Conn) {
 	_, isProxy := r.ProxyAddrs[addr]
 
 	mutex := &sync.Mutex{}
+	r.links.addClient(writer, conn, hello.Fingerprint != 0)
 
 	for !r.Shutdown && err == nil {
 		if msgType, err = reader.ReadByte(); err != nil {
@@ -487,9 +622,25 @@ func (r *Replica) clientListener(conn net.Conn) {
 			}
 			r.M.Lock()
 			r.ClientWriters[propose.ClientId] = writer
+			r.Stats.M["proposals"]++
 			r.M.Unlock()
 			op := propose.Command.Op
-			if r.LRead && (op == state.GET || op == state.SCAN) {
+			if op > state.SCAN {
+				r.reject(&GPropose{
+					Propose: propose,
+					Reply:   writer,
+					Mutex:   mutex,
+				}, STATUS_INVALID, "rejected_invalid")
+			} else if r.LRead && (op == state.GET || op == state.SCAN) && r.ReadChan != nil {
+				r.admit(&GPropose{
+					Propose:    propose,
+					Reply:      writer,
+					Mutex:      mutex,
+					Collocated: isProxy,
+				}, r.ReadChan)
+			} else if r.LRead && (op == state.GET || op == state.SCAN) {
+				// not linearizable, kept for the protocols
+				// that do not implement ReadChan
 				r.ReplyProposeTS(&ProposeReplyTS{
 					OK:        TRUE,
 					CommandId: propose.CommandId,
@@ -497,14 +648,12 @@ func (r *Replica) clientListener(conn net.Conn) {
 					Timestamp: propose.Timestamp,
 				}, writer, mutex)
 			} else {
-				go func(propose *GPropose) {
-					r.ProposeChan <- propose
-				}(&GPropose{
+				r.admit(&GPropose{
 					Propose:    propose,
 					Reply:      writer,
 					Mutex:      mutex,
 					Collocated: isProxy,
-				})
+				}, r.ProposeChan)
 			}
 			break
 
@@ -525,11 +674,23 @@ func (r *Replica) clientListener(conn net.Conn) {
 			break
 
 		case STATS:
+			peers, clients := r.QueueDepths()
 			r.M.Lock()
+			r.Stats.M["pending"] = len(r.ProposeChan)
+			for id, d := range peers {
+				r.Stats.M[fmt.Sprintf("queue_%d", id)] = d
+			}
+			for id, s := range r.PeerLinkStats() {
+				r.Stats.M[fmt.Sprintf("bytes_%d", id)] = int(s.Bytes)
+				r.Stats.M[fmt.Sprintf("wire_bytes_%d", id)] = int(s.WireBytes)
+			}
+			r.Stats.M["queue_clients"] = 0
+			for _, d := range clients {
+				r.Stats.M["queue_clients"] += d
+			}
 			b, _ := json.Marshal(r.Stats)
 			r.M.Unlock()
-			writer.Write(b)
-			writer.Flush()
+			r.links.client(writer).sendBytes(b)
 
 		default:
 			p, exists := r.RPC.Get(msgType)
@@ -548,6 +709,8 @@ func (r *Replica) clientListener(conn net.Conn) {
 	}
 
 	conn.Close()
+	r.forgetClient(writer)
+	r.links.forgetClient(writer)
 	log.Println("Client down", conn.RemoteAddr())
 }
 
@@ -0,0 +1,36 @@
+package smr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// newTestReplica returns replica id out of n, which is not connected
+// to any peer: the messages it sends are dropped.
+func newTestReplica(t *testing.T, id, n int) *Replica {
+	dir, err := ioutil.TempDir("", "smr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	storage := Storage
+	Storage = dir
+	defer func() {
+		Storage = storage
+	}()
+
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("127.0.0.1:%d", 7070+i)
+	}
+	r := NewReplica(id, (n-1)/2, addrs, false, true, false, false, nil)
+	t.Cleanup(func() {
+		r.StableStore.Close()
+	})
+	return r
+}
@@ -1,10 +1,13 @@
 package smr
 
 import (
+	"bufio"
+	"encoding/binary"
 	"io"
 	"sync"
 
 	"github.com/vonaka/shreplic/state"
+	"github.com/vonaka/shreplic/tools/fastrpc"
 )
 
 const (
@@ -17,6 +20,13 @@ const (
 	GENERIC_SMR_BEACON
 	GENERIC_SMR_BEACON_REPLY
 	STATS
+	GENERIC_SMR_LEASE
+	GENERIC_SMR_LEASE_REPLY
+	GENERIC_SMR_READ_INDEX
+	GENERIC_SMR_READ_INDEX_REPLY
+	GENERIC_SMR_LEADER_CHECK
+	GENERIC_SMR_LEADER_CHECK_REPLY
+	GENERIC_SMR_COMPRESSED
 	RPC_TABLE
 )
 
@@ -37,8 +47,27 @@ type ProposeReplyTS struct {
 	CommandId int32
 	Value     state.Value
 	Timestamp int64
+	// Status explains why OK is FALSE
+	Status uint8
+	// Leader is the leader known by the replica if Status
+	// is STATUS_NOT_LEADER, -1 if unknown
+	Leader int32
+	// Epoch is the configuration epoch known by the replica
+	Epoch int32
 }
 
+const (
+	STATUS_OK uint8 = iota
+	// the replica cannot handle the command as it is not the leader
+	STATUS_NOT_LEADER
+	// the replica is overloaded, the command must be resubmitted later
+	STATUS_OVERLOADED
+	// the command was dropped and can be resubmitted
+	STATUS_ABORTED
+	// the command is malformed
+	STATUS_INVALID
+)
+
 type Read struct {
 	CommandId int32
 	Key       state.Key
@@ -69,6 +98,89 @@ type BeaconReply struct {
 	Timestamp int64
 }
 
+type LeaseRequest struct {
+	Ballot    int32
+	Timestamp int64
+}
+
+type LeaseReply struct {
+	Ballot    int32
+	Timestamp int64
+	OK        uint8
+}
+
+type ReadIndexArgs struct {
+	Id int32
+}
+
+type ReadIndexReply struct {
+	Id    int32
+	Index int32
+	OK    uint8
+}
+
+type LeaderCheck struct {
+	Ballot int32
+	Id     int32
+}
+
+type LeaderCheckReply struct {
+	Ballot int32
+	Id     int32
+	OK     uint8
+}
+
+type Hello struct {
+	Kind        uint8
+	Id          int32
+	Version     int32
+	Fingerprint uint64
+	ConfigHash  uint64
+	// Compression is the compression offered to the receiver
+	Compression uint8
+	Protocol    []byte
+}
+
+type HelloReply struct {
+	OK uint8
+	// Compression is the compression used on the connection
+	Compression uint8
+	Protocol    []byte
+	Reason      []byte
+}
+
+func (m *LeaseRequest) New() fastrpc.Serializable {
+	return new(LeaseRequest)
+}
+
+func (m *LeaseReply) New() fastrpc.Serializable {
+	return new(LeaseReply)
+}
+
+func (m *ReadIndexArgs) New() fastrpc.Serializable {
+	return new(ReadIndexArgs)
+}
+
+func (m *ReadIndexReply) New() fastrpc.Serializable {
+	return new(ReadIndexReply)
+}
+
+func (m *LeaderCheck) New() fastrpc.Serializable {
+	return new(LeaderCheck)
+}
+
+func (m *LeaderCheckReply) New() fastrpc.Serializable {
+	return new(LeaderCheckReply)
+}
+
+func (m *Hello) New() fastrpc.Serializable {
+	return new(Hello)
+}
+
+func (m *HelloReply) New() fastrpc.Serializable {
+	return new(HelloReply)
+}
+
 type PingArgs struct {
 	ActAsLeader uint8
 }
@@ -102,6 +214,106 @@ func (r *BeTheLeaderReply) IsDefault() bool {
 	return r.Leader == -1 && r.NextLeader == -1
 }
 
+type InstallQuorumsArgs struct {
+	// Content has the format of a quorum file
+	Content []byte
+}
+
+type InstallQuorumsReply struct {
+	Leader int32
+	// Ballot is the ballot at which the new active quorum is used,
+	// -1 if the replica is not the new leader
+	Ballot int32
+}
+
+type QueueDepthsArgs struct{}
+
+type QueueDepthsReply struct {
+	// Peers[i] is -1 if the replica is not connected to i
+	Peers   []int
+	Clients []int
+}
+
+type LinkStatsArgs struct{}
+
+type LinkStats struct {
+	Queue      int
+	Compressed bool
+	// Bytes is the number of bytes sent, and WireBytes
+	// the number of bytes once compressed
+	Bytes     int64
+	WireBytes int64
+}
+
+// Ratio returns the compression ratio of the link.
+func (s LinkStats) Ratio() float64 {
+	if s.WireBytes == 0 {
+		return 1
+	}
+	return float64(s.Bytes) / float64(s.WireBytes)
+}
+
+type LinkStatsReply struct {
+	Peers []LinkStats
+}
+
+type LatenciesArgs struct{}
+
+type LatenciesReply struct {
+	// RTTs[i] is the round-trip time in milliseconds to
+	// replica i, -1 if it is unreachable
+	RTTs []float64
+}
+
+type GetLeaderArgs struct{}
+
+type GetLeaderReply struct {
+	// Leader is -1 if the replica does not know the leader
+	Leader int32
+}
+
+type StepDownArgs struct{}
+
+type StepDownReply struct{}
+
+type SnapshotArgs struct{}
+
+type SnapshotReply struct {
+	File string
+	Keys int
+	// Position is the position of the snapshot in the log of the
+	// protocol, -1 if the protocol does not give it
+	Position int32
+}
+
+type SetEpochArgs struct {
+	Epoch int32
+}
+
+type SetEpochReply struct{}
+
+type StatsArgs struct{}
+
+type StatsReply struct {
+	Stats map[string]int
+}
+
+type SetPeersArgs struct {
+	Addrs []string
+}
+
+type SetPeersReply struct{}
+
+type StateArgs struct{}
+
+type StateReply struct {
+	State []byte
+	Keys  int
+	// Position is the position of the state in the log of the
+	// protocol (e.g., the last instance executed)
+	Position int32
+}
+
 type Stats struct {
 	M map[string]int `json:"stats"`
 }
@@ -112,6 +324,11 @@ type Stats struct {
 //                                                                           //
 ///////////////////////////////////////////////////////////////////////////////
 
+type byteReader interface {
+	io.Reader
+	ReadByte() (c byte, err error)
+}
+
 func (t *PingArgs) BinarySize() (nbytes int, sizeKnown bool) {
 	return 1, true
 }
@@ -378,7 +595,7 @@ func (p *ProposeReplyTSCache) Put(t *ProposeReplyTS) {
 	p.mu.Unlock()
 }
 func (t *ProposeReplyTS) Marshal(wire io.Writer) {
-	var b [8]byte
+	var b [17]byte
 	var bs []byte
 	bs = b[:5]
 	bs[0] = byte(t.OK)
@@ -389,7 +606,7 @@ func (t *ProposeReplyTS) Marshal(wire io.Writer) {
 	bs[4] = byte(tmp32 >> 24)
 	wire.Write(bs)
 	t.Value.Marshal(wire)
-	bs = b[:8]
+	bs = b[:17]
 	tmp64 := t.Timestamp
 	bs[0] = byte(tmp64)
 	bs[1] = byte(tmp64 >> 8)
@@ -399,11 +616,22 @@ func (t *ProposeReplyTS) Marshal(wire io.Writer) {
 	bs[5] = byte(tmp64 >> 40)
 	bs[6] = byte(tmp64 >> 48)
 	bs[7] = byte(tmp64 >> 56)
+	bs[8] = byte(t.Status)
+	tmp32 = t.Leader
+	bs[9] = byte(tmp32)
+	bs[10] = byte(tmp32 >> 8)
+	bs[11] = byte(tmp32 >> 16)
+	bs[12] = byte(tmp32 >> 24)
+	tmp32 = t.Epoch
+	bs[13] = byte(tmp32)
+	bs[14] = byte(tmp32 >> 8)
+	bs[15] = byte(tmp32 >> 16)
+	bs[16] = byte(tmp32 >> 24)
 	wire.Write(bs)
 }
 
 func (t *ProposeReplyTS) Unmarshal(wire io.Reader) error {
-	var b [8]byte
+	var b [17]byte
 	var bs []byte
 	bs = b[:5]
 	if _, err := io.ReadAtLeast(wire, bs, 5); err != nil {
@@ -412,11 +640,14 @@ func (t *ProposeReplyTS) Unmarshal(wire io.Reader) error {
 	t.OK = uint8(bs[0])
 	t.CommandId = int32((uint32(bs[1]) | (uint32(bs[2]) << 8) | (uint32(bs[3]) << 16) | (uint32(bs[4]) << 24)))
 	t.Value.Unmarshal(wire)
-	bs = b[:8]
-	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
+	bs = b[:17]
+	if _, err := io.ReadAtLeast(wire, bs, 17); err != nil {
 		return err
 	}
 	t.Timestamp = int64((uint64(bs[0]) | (uint64(bs[1]) << 8) | (uint64(bs[2]) << 16) | (uint64(bs[3]) << 24) | (uint64(bs[4]) << 32) | (uint64(bs[5]) << 40) | (uint64(bs[6]) << 48) | (uint64(bs[7]) << 56)))
+	t.Status = uint8(bs[8])
+	t.Leader = int32((uint32(bs[9]) | (uint32(bs[10]) << 8) | (uint32(bs[11]) << 16) | (uint32(bs[12]) << 24)))
+	t.Epoch = int32((uint32(bs[13]) | (uint32(bs[14]) << 8) | (uint32(bs[15]) << 16) | (uint32(bs[16]) << 24)))
 	return nil
 }
 
@@ -873,3 +1104,598 @@ func (t *Beacon) Unmarshal(wire io.Reader) error {
 	t.Timestamp = int64((uint64(bs[0]) | (uint64(bs[1]) << 8) | (uint64(bs[2]) << 16) | (uint64(bs[3]) << 24) | (uint64(bs[4]) << 32) | (uint64(bs[5]) << 40) | (uint64(bs[6]) << 48) | (uint64(bs[7]) << 56)))
 	return nil
 }
+
+func (t *LeaseRequest) BinarySize() (nbytes int, sizeKnown bool) {
+	return 12, true
+}
+
+type LeaseRequestCache struct {
+	mu    sync.Mutex
+	cache []*LeaseRequest
+}
+
+func NewLeaseRequestCache() *LeaseRequestCache {
+	c := &LeaseRequestCache{}
+	c.cache = make([]*LeaseRequest, 0)
+	return c
+}
+
+func (p *LeaseRequestCache) Get() *LeaseRequest {
+	var t *LeaseRequest
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &LeaseRequest{}
+	}
+	return t
+}
+func (p *LeaseRequestCache) Put(t *LeaseRequest) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+func (t *LeaseRequest) Marshal(wire io.Writer) {
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	tmp32 := t.Ballot
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp64 := t.Timestamp
+	bs[4] = byte(tmp64)
+	bs[5] = byte(tmp64 >> 8)
+	bs[6] = byte(tmp64 >> 16)
+	bs[7] = byte(tmp64 >> 24)
+	bs[8] = byte(tmp64 >> 32)
+	bs[9] = byte(tmp64 >> 40)
+	bs[10] = byte(tmp64 >> 48)
+	bs[11] = byte(tmp64 >> 56)
+	wire.Write(bs)
+}
+
+func (t *LeaseRequest) Unmarshal(wire io.Reader) error {
+	var b [12]byte
+	var bs []byte
+	bs = b[:12]
+	if _, err := io.ReadAtLeast(wire, bs, 12); err != nil {
+		return err
+	}
+	t.Ballot = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Timestamp = int64((uint64(bs[4]) | (uint64(bs[5]) << 8) | (uint64(bs[6]) << 16) | (uint64(bs[7]) << 24) | (uint64(bs[8]) << 32) | (uint64(bs[9]) << 40) | (uint64(bs[10]) << 48) | (uint64(bs[11]) << 56)))
+	return nil
+}
+
+func (t *LeaseReply) BinarySize() (nbytes int, sizeKnown bool) {
+	return 13, true
+}
+
+type LeaseReplyCache struct {
+	mu    sync.Mutex
+	cache []*LeaseReply
+}
+
+func NewLeaseReplyCache() *LeaseReplyCache {
+	c := &LeaseReplyCache{}
+	c.cache = make([]*LeaseReply, 0)
+	return c
+}
+
+func (p *LeaseReplyCache) Get() *LeaseReply {
+	var t *LeaseReply
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &LeaseReply{}
+	}
+	return t
+}
+func (p *LeaseReplyCache) Put(t *LeaseReply) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+func (t *LeaseReply) Marshal(wire io.Writer) {
+	var b [13]byte
+	var bs []byte
+	bs = b[:13]
+	tmp32 := t.Ballot
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp64 := t.Timestamp
+	bs[4] = byte(tmp64)
+	bs[5] = byte(tmp64 >> 8)
+	bs[6] = byte(tmp64 >> 16)
+	bs[7] = byte(tmp64 >> 24)
+	bs[8] = byte(tmp64 >> 32)
+	bs[9] = byte(tmp64 >> 40)
+	bs[10] = byte(tmp64 >> 48)
+	bs[11] = byte(tmp64 >> 56)
+	bs[12] = byte(t.OK)
+	wire.Write(bs)
+}
+
+func (t *LeaseReply) Unmarshal(wire io.Reader) error {
+	var b [13]byte
+	var bs []byte
+	bs = b[:13]
+	if _, err := io.ReadAtLeast(wire, bs, 13); err != nil {
+		return err
+	}
+	t.Ballot = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Timestamp = int64((uint64(bs[4]) | (uint64(bs[5]) << 8) | (uint64(bs[6]) << 16) | (uint64(bs[7]) << 24) | (uint64(bs[8]) << 32) | (uint64(bs[9]) << 40) | (uint64(bs[10]) << 48) | (uint64(bs[11]) << 56)))
+	t.OK = uint8(bs[12])
+	return nil
+}
+
+func (t *LeaderCheckReply) BinarySize() (nbytes int, sizeKnown bool) {
+	return 9, true
+}
+
+type LeaderCheckReplyCache struct {
+	mu    sync.Mutex
+	cache []*LeaderCheckReply
+}
+
+func NewLeaderCheckReplyCache() *LeaderCheckReplyCache {
+	c := &LeaderCheckReplyCache{}
+	c.cache = make([]*LeaderCheckReply, 0)
+	return c
+}
+
+func (p *LeaderCheckReplyCache) Get() *LeaderCheckReply {
+	var t *LeaderCheckReply
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &LeaderCheckReply{}
+	}
+	return t
+}
+func (p *LeaderCheckReplyCache) Put(t *LeaderCheckReply) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+func (t *LeaderCheckReply) Marshal(wire io.Writer) {
+	var b [9]byte
+	var bs []byte
+	bs = b[:9]
+	tmp32 := t.Ballot
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp32 = t.Id
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	bs[8] = byte(t.OK)
+	wire.Write(bs)
+}
+
+func (t *LeaderCheckReply) Unmarshal(wire io.Reader) error {
+	var b [9]byte
+	var bs []byte
+	bs = b[:9]
+	if _, err := io.ReadAtLeast(wire, bs, 9); err != nil {
+		return err
+	}
+	t.Ballot = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Id = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	t.OK = uint8(bs[8])
+	return nil
+}
+
+func (t *ReadIndexArgs) BinarySize() (nbytes int, sizeKnown bool) {
+	return 4, true
+}
+
+type ReadIndexArgsCache struct {
+	mu    sync.Mutex
+	cache []*ReadIndexArgs
+}
+
+func NewReadIndexArgsCache() *ReadIndexArgsCache {
+	c := &ReadIndexArgsCache{}
+	c.cache = make([]*ReadIndexArgs, 0)
+	return c
+}
+
+func (p *ReadIndexArgsCache) Get() *ReadIndexArgs {
+	var t *ReadIndexArgs
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &ReadIndexArgs{}
+	}
+	return t
+}
+func (p *ReadIndexArgsCache) Put(t *ReadIndexArgs) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+func (t *ReadIndexArgs) Marshal(wire io.Writer) {
+	var b [4]byte
+	var bs []byte
+	bs = b[:4]
+	tmp32 := t.Id
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	wire.Write(bs)
+}
+
+func (t *ReadIndexArgs) Unmarshal(wire io.Reader) error {
+	var b [4]byte
+	var bs []byte
+	bs = b[:4]
+	if _, err := io.ReadAtLeast(wire, bs, 4); err != nil {
+		return err
+	}
+	t.Id = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	return nil
+}
+
+func (t *ReadIndexReply) BinarySize() (nbytes int, sizeKnown bool) {
+	return 9, true
+}
+
+type ReadIndexReplyCache struct {
+	mu    sync.Mutex
+	cache []*ReadIndexReply
+}
+
+func NewReadIndexReplyCache() *ReadIndexReplyCache {
+	c := &ReadIndexReplyCache{}
+	c.cache = make([]*ReadIndexReply, 0)
+	return c
+}
+
+func (p *ReadIndexReplyCache) Get() *ReadIndexReply {
+	var t *ReadIndexReply
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &ReadIndexReply{}
+	}
+	return t
+}
+func (p *ReadIndexReplyCache) Put(t *ReadIndexReply) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+func (t *ReadIndexReply) Marshal(wire io.Writer) {
+	var b [9]byte
+	var bs []byte
+	bs = b[:9]
+	tmp32 := t.Id
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp32 = t.Index
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	bs[8] = byte(t.OK)
+	wire.Write(bs)
+}
+
+func (t *ReadIndexReply) Unmarshal(wire io.Reader) error {
+	var b [9]byte
+	var bs []byte
+	bs = b[:9]
+	if _, err := io.ReadAtLeast(wire, bs, 9); err != nil {
+		return err
+	}
+	t.Id = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Index = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	t.OK = uint8(bs[8])
+	return nil
+}
+
+func (t *LeaderCheck) BinarySize() (nbytes int, sizeKnown bool) {
+	return 8, true
+}
+
+type LeaderCheckCache struct {
+	mu    sync.Mutex
+	cache []*LeaderCheck
+}
+
+func NewLeaderCheckCache() *LeaderCheckCache {
+	c := &LeaderCheckCache{}
+	c.cache = make([]*LeaderCheck, 0)
+	return c
+}
+
+func (p *LeaderCheckCache) Get() *LeaderCheck {
+	var t *LeaderCheck
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &LeaderCheck{}
+	}
+	return t
+}
+func (p *LeaderCheckCache) Put(t *LeaderCheck) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+func (t *LeaderCheck) Marshal(wire io.Writer) {
+	var b [8]byte
+	var bs []byte
+	bs = b[:8]
+	tmp32 := t.Ballot
+	bs[0] = byte(tmp32)
+	bs[1] = byte(tmp32 >> 8)
+	bs[2] = byte(tmp32 >> 16)
+	bs[3] = byte(tmp32 >> 24)
+	tmp32 = t.Id
+	bs[4] = byte(tmp32)
+	bs[5] = byte(tmp32 >> 8)
+	bs[6] = byte(tmp32 >> 16)
+	bs[7] = byte(tmp32 >> 24)
+	wire.Write(bs)
+}
+
+func (t *LeaderCheck) Unmarshal(wire io.Reader) error {
+	var b [8]byte
+	var bs []byte
+	bs = b[:8]
+	if _, err := io.ReadAtLeast(wire, bs, 8); err != nil {
+		return err
+	}
+	t.Ballot = int32((uint32(bs[0]) | (uint32(bs[1]) << 8) | (uint32(bs[2]) << 16) | (uint32(bs[3]) << 24)))
+	t.Id = int32((uint32(bs[4]) | (uint32(bs[5]) << 8) | (uint32(bs[6]) << 16) | (uint32(bs[7]) << 24)))
+	return nil
+}
+
+func (t *Hello) BinarySize() (nbytes int, sizeKnown bool) {
+	return 0, false
+}
+
+type HelloCache struct {
+	mu    sync.Mutex
+	cache []*Hello
+}
+
+func NewHelloCache() *HelloCache {
+	c := &HelloCache{}
+	c.cache = make([]*Hello, 0)
+	return c
+}
+
+func (p *HelloCache) Get() *Hello {
+	var t *Hello
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &Hello{}
+	}
+	return t
+}
+func (p *HelloCache) Put(t *Hello) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+func (t *Hello) Marshal(wire io.Writer) {
+	var b [26]byte
+	var bs []byte
+	bs = b[:26]
+	bs[0] = byte(t.Kind)
+	tmp32 := t.Id
+	bs[1] = byte(tmp32)
+	bs[2] = byte(tmp32 >> 8)
+	bs[3] = byte(tmp32 >> 16)
+	bs[4] = byte(tmp32 >> 24)
+	tmp32 = t.Version
+	bs[5] = byte(tmp32)
+	bs[6] = byte(tmp32 >> 8)
+	bs[7] = byte(tmp32 >> 16)
+	bs[8] = byte(tmp32 >> 24)
+	tmp64 := t.Fingerprint
+	bs[9] = byte(tmp64)
+	bs[10] = byte(tmp64 >> 8)
+	bs[11] = byte(tmp64 >> 16)
+	bs[12] = byte(tmp64 >> 24)
+	bs[13] = byte(tmp64 >> 32)
+	bs[14] = byte(tmp64 >> 40)
+	bs[15] = byte(tmp64 >> 48)
+	bs[16] = byte(tmp64 >> 56)
+	tmp64 = t.ConfigHash
+	bs[17] = byte(tmp64)
+	bs[18] = byte(tmp64 >> 8)
+	bs[19] = byte(tmp64 >> 16)
+	bs[20] = byte(tmp64 >> 24)
+	bs[21] = byte(tmp64 >> 32)
+	bs[22] = byte(tmp64 >> 40)
+	bs[23] = byte(tmp64 >> 48)
+	bs[24] = byte(tmp64 >> 56)
+	bs[25] = byte(t.Compression)
+	wire.Write(bs)
+	bs = b[:]
+	alen1 := int64(len(t.Protocol))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:1]
+		bs[0] = byte(t.Protocol[i])
+		wire.Write(bs)
+	}
+}
+
+func (t *Hello) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [26]byte
+	var bs []byte
+	bs = b[:26]
+	if _, err := io.ReadAtLeast(wire, bs, 26); err != nil {
+		return err
+	}
+	t.Kind = uint8(bs[0])
+	t.Id = int32((uint32(bs[1]) | (uint32(bs[2]) << 8) | (uint32(bs[3]) << 16) | (uint32(bs[4]) << 24)))
+	t.Version = int32((uint32(bs[5]) | (uint32(bs[6]) << 8) | (uint32(bs[7]) << 16) | (uint32(bs[8]) << 24)))
+	t.Fingerprint = uint64((uint64(bs[9]) | (uint64(bs[10]) << 8) | (uint64(bs[11]) << 16) | (uint64(bs[12]) << 24) | (uint64(bs[13]) << 32) | (uint64(bs[14]) << 40) | (uint64(bs[15]) << 48) | (uint64(bs[16]) << 56)))
+	t.ConfigHash = uint64((uint64(bs[17]) | (uint64(bs[18]) << 8) | (uint64(bs[19]) << 16) | (uint64(bs[20]) << 24) | (uint64(bs[21]) << 32) | (uint64(bs[22]) << 40) | (uint64(bs[23]) << 48) | (uint64(bs[24]) << 56)))
+	t.Compression = uint8(bs[25])
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Protocol = make([]byte, alen1)
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:1]
+		if _, err := io.ReadAtLeast(wire, bs, 1); err != nil {
+			return err
+		}
+		t.Protocol[i] = byte(bs[0])
+	}
+	return nil
+}
+
+func (t *HelloReply) BinarySize() (nbytes int, sizeKnown bool) {
+	return 0, false
+}
+
+type HelloReplyCache struct {
+	mu    sync.Mutex
+	cache []*HelloReply
+}
+
+func NewHelloReplyCache() *HelloReplyCache {
+	c := &HelloReplyCache{}
+	c.cache = make([]*HelloReply, 0)
+	return c
+}
+
+func (p *HelloReplyCache) Get() *HelloReply {
+	var t *HelloReply
+	p.mu.Lock()
+	if len(p.cache) > 0 {
+		t = p.cache[len(p.cache)-1]
+		p.cache = p.cache[0:(len(p.cache) - 1)]
+	}
+	p.mu.Unlock()
+	if t == nil {
+		t = &HelloReply{}
+	}
+	return t
+}
+func (p *HelloReplyCache) Put(t *HelloReply) {
+	p.mu.Lock()
+	p.cache = append(p.cache, t)
+	p.mu.Unlock()
+}
+func (t *HelloReply) Marshal(wire io.Writer) {
+	var b [10]byte
+	var bs []byte
+	bs = b[:2]
+	bs[0] = byte(t.OK)
+	bs[1] = byte(t.Compression)
+	wire.Write(bs)
+	bs = b[:]
+	alen1 := int64(len(t.Protocol))
+	if wlen := binary.PutVarint(bs, alen1); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:1]
+		bs[0] = byte(t.Protocol[i])
+		wire.Write(bs)
+	}
+	bs = b[:]
+	alen2 := int64(len(t.Reason))
+	if wlen := binary.PutVarint(bs, alen2); wlen >= 0 {
+		wire.Write(b[0:wlen])
+	}
+	for i := int64(0); i < alen2; i++ {
+		bs = b[:1]
+		bs[0] = byte(t.Reason[i])
+		wire.Write(bs)
+	}
+}
+
+func (t *HelloReply) Unmarshal(rr io.Reader) error {
+	var wire byteReader
+	var ok bool
+	if wire, ok = rr.(byteReader); !ok {
+		wire = bufio.NewReader(rr)
+	}
+	var b [10]byte
+	var bs []byte
+	bs = b[:2]
+	if _, err := io.ReadAtLeast(wire, bs, 2); err != nil {
+		return err
+	}
+	t.OK = uint8(bs[0])
+	t.Compression = uint8(bs[1])
+	alen1, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Protocol = make([]byte, alen1)
+	for i := int64(0); i < alen1; i++ {
+		bs = b[:1]
+		if _, err := io.ReadAtLeast(wire, bs, 1); err != nil {
+			return err
+		}
+		t.Protocol[i] = byte(bs[0])
+	}
+	alen2, err := binary.ReadVarint(wire)
+	if err != nil {
+		return err
+	}
+	t.Reason = make([]byte, alen2)
+	for i := int64(0); i < alen2; i++ {
+		if _, err := io.ReadAtLeast(wire, bs, 1); err != nil {
+			return err
+		}
+		t.Reason[i] = byte(bs[0])
+	}
+	return nil
+}
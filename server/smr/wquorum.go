@@ -0,0 +1,358 @@
+package smr
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// QuorumChecker is implemented by the quorum systems in which being a
+// quorum depends on the replicas and not only on their number. For
+// such systems Size returns the smallest number of replicas that can
+// form a quorum.
+type QuorumChecker interface {
+	QuorumI
+	IsQuorum(ids []int32) bool
+}
+
+// IsQuorum returns true if ids contains a quorum of q.
+func IsQuorum(q QuorumI, ids []int32) bool {
+	if c, ok := q.(QuorumChecker); ok {
+		return c.IsQuorum(ids)
+	}
+	n := 0
+	for _, id := range ids {
+		if q.Contains(id) {
+			n++
+		}
+	}
+	return n >= q.Size()
+}
+
+// Weighted is a weighted-vote quorum system: a set of replicas is a
+// quorum if the sum of their weights is greater than half of the total
+// weight.
+type Weighted struct {
+	weights   map[int32]int
+	threshold int
+	size      int
+}
+
+func NewWeighted(weights map[int32]int) Weighted {
+	return newWeighted(weights, totalWeight(weights)/2+1)
+}
+
+func newWeighted(weights map[int32]int, threshold int) Weighted {
+	ws := make([]int, 0, len(weights))
+	for _, w := range weights {
+		ws = append(ws, w)
+	}
+
+	size, sum := 0, 0
+	sort.Sort(sort.Reverse(sort.IntSlice(ws)))
+	for _, w := range ws {
+		if sum >= threshold {
+			break
+		}
+		sum += w
+		size++
+	}
+
+	return Weighted{
+		weights:   weights,
+		threshold: threshold,
+		size:      size,
+	}
+}
+
+func totalWeight(weights map[int32]int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	return total
+}
+
+func (q Weighted) Size() int {
+	return q.size
+}
+
+func (q Weighted) Contains(repId int32) bool {
+	return q.weights[repId] > 0
+}
+
+func (q Weighted) IsQuorum(ids []int32) bool {
+	sum := 0
+	seen := make(map[int32]struct{}, len(ids))
+	for _, id := range ids {
+		if _, exists := seen[id]; !exists {
+			seen[id] = struct{}{}
+			sum += q.weights[id]
+		}
+	}
+	return sum >= q.threshold
+}
+
+func (q Weighted) String() string {
+	return fmt.Sprintf("weighted %v (threshold %d)", q.weights, q.threshold)
+}
+
+// Hierarchical is a quorum system made of disjoint groups of replicas
+// (e.g. datacenters): a set of replicas is a quorum if it contains a
+// quorum of a majority of the groups.
+type Hierarchical struct {
+	groups []QuorumI
+	need   int
+	size   int
+}
+
+func NewHierarchical(groups []QuorumI) Hierarchical {
+	return newHierarchical(groups, len(groups)/2+1)
+}
+
+func newHierarchical(groups []QuorumI, need int) Hierarchical {
+	sizes := make([]int, len(groups))
+	for i, g := range groups {
+		sizes[i] = g.Size()
+	}
+
+	size := 0
+	sort.Ints(sizes)
+	for _, s := range sizes[:need] {
+		size += s
+	}
+
+	return Hierarchical{
+		groups: groups,
+		need:   need,
+		size:   size,
+	}
+}
+
+// NewMajorityOfMajorities returns the hierarchical quorum system in
+// which each group is a set of replicas of which a majority is required.
+func NewMajorityOfMajorities(groups [][]int32) Hierarchical {
+	qs := make([]QuorumI, len(groups))
+	for i, g := range groups {
+		q := NewQuorum(len(g))
+		for _, id := range g {
+			q[id] = struct{}{}
+		}
+		qs[i] = majorityOf(q)
+	}
+	return NewHierarchical(qs)
+}
+
+func (q Hierarchical) Size() int {
+	return q.size
+}
+
+func (q Hierarchical) Contains(repId int32) bool {
+	for _, g := range q.groups {
+		if g.Contains(repId) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q Hierarchical) IsQuorum(ids []int32) bool {
+	n := 0
+	for _, g := range q.groups {
+		if IsQuorum(g, ids) {
+			n++
+		}
+	}
+	return n >= q.need
+}
+
+func (q Hierarchical) String() string {
+	gs := make([]string, len(q.groups))
+	for i, g := range q.groups {
+		gs[i] = fmt.Sprint(g)
+	}
+	return fmt.Sprintf("%d of [%s]", q.need, strings.Join(gs, " "))
+}
+
+// FastQuorumOf returns the fast quorums of the quorum system q of n
+// replicas: any two fast quorums and any quorum of q intersect, as
+// three quarters and a majority of the replicas do. The quorums of the
+// systems that are none of the above are their own fast quorums.
+func FastQuorumOf(q QuorumI, n int) QuorumI {
+	switch q := q.(type) {
+	case Majority:
+		return NewThreeQuartersOf(n)
+	case Weighted:
+		return newWeighted(q.weights, 3*totalWeight(q.weights)/4+1)
+	case Hierarchical:
+		groups := make([]QuorumI, len(q.groups))
+		for i, g := range q.groups {
+			groups[i] = FastQuorumOf(g, n)
+		}
+		return newHierarchical(groups, 3*len(groups)/4+1)
+	case majorityOf:
+		return threeQuartersOf(q)
+	}
+	return q
+}
+
+// majorityOf is a majority of the replicas of a Quorum
+type majorityOf Quorum
+
+func (q majorityOf) Size() int {
+	return len(q)/2 + 1
+}
+
+func (q majorityOf) Contains(repId int32) bool {
+	return Quorum(q).Contains(repId)
+}
+
+func (q majorityOf) String() string {
+	ids := make([]int, 0, len(q))
+	for id := range q {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	return fmt.Sprintf("majority of %v", ids)
+}
+
+// threeQuartersOf is three quarters of the replicas of a Quorum
+type threeQuartersOf Quorum
+
+func (q threeQuartersOf) Size() int {
+	return 3*len(q)/4 + 1
+}
+
+func (q threeQuartersOf) Contains(repId int32) bool {
+	return Quorum(q).Contains(repId)
+}
+
+func (q threeQuartersOf) String() string {
+	ids := make([]int, 0, len(q))
+	for id := range q {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	return fmt.Sprintf("three quarters of %v", ids)
+}
+
+// QuorumFromFile returns the weighted or hierarchical quorum system
+// described in qfile, or nil if qfile describes none of them. These
+// lines are ignored by NewQuorumsFromFile, so that the same file can
+// also give the leader and its quorums.
+//
+// A weighted quorum system is given by lines of the form
+//
+//	weight <replica address> <weight>
+//
+// (replicas without such a line have weight 0), and a majority of
+// majorities by lines of the form
+//
+//	group <replica address> <replica address> ...
+func QuorumFromFile(qfile string, r *Replica) (QuorumI, error) {
+	content, err := readQuorumFile(qfile)
+	if err != nil {
+		return nil, err
+	}
+	r.qconfig = quorumSystemOf(content)
+	return r.ParseQuorum(qfile, content)
+}
+
+// ParseQuorum is QuorumFromFile for the content of a quorum file.
+func (r *Replica) ParseQuorum(name string, content []byte) (QuorumI, error) {
+	return ParseQuorumOf(r.PeerAddrList, r.F, name, content)
+}
+
+// ParseQuorumOf is ParseQuorum for the replicas with the given addresses
+// tolerating f failures (the largest possible number if negative), so
+// that the clients can parse the quorum files of the replicas.
+func ParseQuorumOf(addrs []string, f int, name string, content []byte) (QuorumI, error) {
+	if IsQuorumConfig(content) {
+		c, err := parseQuorumConfig(addrs, f, name, content)
+		if err != nil || (len(c.Weights) == 0 && len(c.Groups) == 0) {
+			return nil, err
+		}
+		return c.Quorum(), nil
+	}
+
+	weights := make(map[int32]int)
+	groups := [][]int32{}
+	grouped := make(map[int32]struct{})
+	for n, line := range strings.Split(string(content), "\n") {
+		data := strings.Fields(line)
+		if len(data) == 0 || (data[0] != "weight" && data[0] != "group") {
+			continue
+		}
+		errorf := func(format string, a ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", name, n+1, fmt.Sprintf(format, a...))
+		}
+
+		switch data[0] {
+		case "weight":
+			if len(data) != 3 {
+				return nil, errorf("expected: weight <address> <weight>")
+			}
+			id := replicaOfAddr(addrs, data[1])
+			if id == -1 {
+				return nil, errorf("unknown replica %s", data[1])
+			}
+			if _, exists := weights[id]; exists {
+				return nil, errorf("weight of %s is given twice", data[1])
+			}
+			w, err := strconv.Atoi(data[2])
+			if err != nil || w < 0 {
+				return nil, errorf("invalid weight %s", data[2])
+			}
+			weights[id] = w
+
+		case "group":
+			if len(data) < 2 {
+				return nil, errorf("empty group")
+			}
+			g := []int32{}
+			for _, addr := range data[1:] {
+				id := replicaOfAddr(addrs, addr)
+				if id == -1 {
+					return nil, errorf("unknown replica %s", addr)
+				}
+				if _, exists := grouped[id]; exists {
+					return nil, errorf("%s belongs to several groups", addr)
+				}
+				grouped[id] = struct{}{}
+				g = append(g, id)
+			}
+			groups = append(groups, g)
+		}
+	}
+
+	switch {
+	case len(weights) != 0 && len(groups) != 0:
+		return nil, errors.New(name + ": weights and groups cannot be combined")
+	case len(weights) != 0:
+		if totalWeight(weights) == 0 {
+			return nil, errors.New(name + ": total weight must be positive")
+		}
+		return NewWeighted(weights), nil
+	case len(groups) != 0:
+		return NewMajorityOfMajorities(groups), nil
+	}
+	return nil, nil
+}
+
+// replicaOfAddr returns the id of the replica with the given
+// address or -1 if there is no such replica.
+func replicaOfAddr(addrs []string, addr string) int32 {
+	for rid, a := range addrs {
+		if addr == a {
+			return int32(rid)
+		}
+	}
+	for rid, a := range addrs {
+		if addr == strings.Split(a, ":")[0] {
+			return int32(rid)
+		}
+	}
+	return -1
+}
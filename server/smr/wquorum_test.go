@@ -0,0 +1,168 @@
+package smr
+
+import "testing"
+
+func TestWeighted(t *testing.T) {
+	q := NewWeighted(map[int32]int{0: 3, 1: 1, 2: 1, 3: 1, 4: 0})
+	if q.Size() != 2 {
+		t.Errorf("size is %d, want 2", q.Size())
+	}
+	if q.Contains(4) || !q.Contains(1) {
+		t.Error("only the replicas with a positive weight belong to a quorum")
+	}
+
+	for _, test := range []struct {
+		ids    []int32
+		quorum bool
+	}{
+		{nil, false},
+		{[]int32{0}, false},
+		{[]int32{0, 1}, true},
+		{[]int32{1, 2, 3}, false},
+		{[]int32{1, 2, 3, 4}, false},
+		{[]int32{0, 4}, false},
+		{[]int32{0, 0}, false},
+		{[]int32{0, 1, 2, 3, 4}, true},
+	} {
+		if IsQuorum(q, test.ids) != test.quorum {
+			t.Errorf("IsQuorum(%v) is %t", test.ids, !test.quorum)
+		}
+	}
+}
+
+func TestHierarchical(t *testing.T) {
+	q := NewMajorityOfMajorities([][]int32{{0, 1, 2}, {3, 4, 5}, {6}})
+	if q.Size() != 3 {
+		t.Errorf("size is %d, want 3", q.Size())
+	}
+
+	for _, test := range []struct {
+		ids    []int32
+		quorum bool
+	}{
+		{nil, false},
+		{[]int32{0, 1}, false},
+		{[]int32{0, 1, 6}, true},
+		{[]int32{0, 3, 6}, false},
+		{[]int32{0, 1, 3, 4}, true},
+		{[]int32{0, 1, 2, 3}, false},
+		{[]int32{6, 6, 6}, false},
+	} {
+		if IsQuorum(q, test.ids) != test.quorum {
+			t.Errorf("IsQuorum(%v) is %t", test.ids, !test.quorum)
+		}
+	}
+}
+
+func TestParseQuorum(t *testing.T) {
+	addrs := []string{"10.0.0.1:7070", "10.0.0.2:7070", "10.0.0.3:7070"}
+
+	for _, test := range []struct {
+		content string
+		quorum  []int32
+		other   []int32
+		err     bool
+	}{
+		{content: "10.0.0.1:7070\n"},
+		{
+			content: "weight 10.0.0.1 2\nweight 10.0.0.2:7070 1\nweight 10.0.0.3 1\n",
+			quorum:  []int32{0, 1},
+			other:   []int32{1, 2},
+		},
+		{
+			content: "group 10.0.0.1 10.0.0.2\ngroup 10.0.0.3\n",
+			quorum:  []int32{0, 1, 2},
+			other:   []int32{0, 2},
+		},
+		{
+			content: `{"replicas": [{"id": 0, "addr": "10.0.0.1"},
+			{"id": 1, "addr": "10.0.0.2"}, {"id": 2, "addr": "10.0.0.3"}],
+			"weights": {"0": 2, "1": 2, "2": 1}}`,
+			quorum: []int32{0, 2},
+			other:  []int32{0},
+		},
+		{content: "weight 10.0.0.4 1\n", err: true},
+		{content: "weight 10.0.0.1 -1\n", err: true},
+		{content: "weight 10.0.0.1 0\n", err: true},
+		{content: "weight 10.0.0.1 1\nweight 10.0.0.1 1\n", err: true},
+		{content: "weight 10.0.0.1 1\ngroup 10.0.0.2\n", err: true},
+		{content: "group 10.0.0.1 10.0.0.2\ngroup 10.0.0.2\n", err: true},
+	} {
+		q, err := ParseQuorumOf(addrs, 1, "quorums", []byte(test.content))
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.content, err)
+			continue
+		}
+		if test.quorum == nil {
+			if q != nil {
+				t.Errorf("%q: unexpected quorum system %v", test.content, q)
+			}
+			continue
+		}
+		if !IsQuorum(q, test.quorum) || IsQuorum(q, test.other) {
+			t.Errorf("%q: wrong quorum system %v", test.content, q)
+		}
+	}
+}
+
+func TestFastQuorumOf(t *testing.T) {
+	for _, test := range []struct {
+		n int
+		q QuorumI
+	}{
+		{3, NewMajorityOf(3)},
+		{4, NewMajorityOf(4)},
+		{5, NewMajorityOf(5)},
+		{5, NewWeighted(map[int32]int{0: 2, 1: 2, 2: 1, 3: 1, 4: 1})},
+		{4, NewWeighted(map[int32]int{0: 3, 1: 1, 2: 1, 3: 0})},
+		{7, NewMajorityOfMajorities([][]int32{{0, 1, 2}, {3, 4, 5}, {6}})},
+		{8, NewMajorityOfMajorities([][]int32{{0, 1}, {2, 3}, {4, 5}, {6, 7}})},
+	} {
+		fq := FastQuorumOf(test.q, test.n)
+		var slow, fast [][]int32
+		for set := 0; set < 1<<uint(test.n); set++ {
+			ids := []int32{}
+			for id := 0; id < test.n; id++ {
+				if set&(1<<uint(id)) != 0 {
+					ids = append(ids, int32(id))
+				}
+			}
+			if IsQuorum(test.q, ids) {
+				slow = append(slow, ids)
+			}
+			if IsQuorum(fq, ids) {
+				fast = append(fast, ids)
+			}
+		}
+
+		if len(fast) == 0 {
+			t.Errorf("%v: no fast quorum", test.q)
+			continue
+		}
+		for _, f1 := range fast {
+			for _, f2 := range fast {
+				for _, s := range slow {
+					if !intersect3(f1, f2, s) {
+						t.Fatalf("%v: fast quorums %v and %v and quorum %v "+
+							"do not intersect", test.q, f1, f2, s)
+					}
+				}
+			}
+		}
+	}
+}
+
+func intersect3(a, b, c []int32) bool {
+	in := make(map[int32]int)
+	for _, ids := range [][]int32{a, b, c} {
+		for _, id := range ids {
+			in[id]++
+		}
+	}
+	for _, n := range in {
+		if n == 3 {
+			return true
+		}
+	}
+	return false
+}
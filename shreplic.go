@@ -43,7 +43,7 @@ func main() {
 		return
 	}
 
-	if _, err := os.Stat(*msgFlow); !*install && os.IsNotExist(err) {
+	if _, err := os.Stat(*msgFlow); !*install && !*remove && os.IsNotExist(err) {
 		fmt.Printf("%s: no such file\n", *msgFlow)
 		return
 	}
@@ -53,11 +53,12 @@ func main() {
 	}
 
 	var (
-		buf         bytes.Buffer
-		protocolDir = *shpath + "/user/" + *pname
-		defsFile    = *shpath + "/user/" + *pname + "/defs.go"
-		protoFile   = *shpath + "/user/" + *pname + "/proto.go"
-		replicaFile = *shpath + "/user/" + *pname + "/" + *pname + ".go"
+		buf          bytes.Buffer
+		protocolDir  = *shpath + "/user/" + *pname
+		defsFile     = *shpath + "/user/" + *pname + "/defs.go"
+		protoFile    = *shpath + "/user/" + *pname + "/proto.go"
+		replicaFile  = *shpath + "/user/" + *pname + "/" + *pname + ".go"
+		registerFile = *shpath + "/user/" + *pname + "/register.go"
 	)
 
 	if *install {
@@ -72,8 +73,7 @@ func main() {
 	}
 
 	if *remove {
-		err := removeProtocol(protocolDir,
-			*shpath+"/server/server.go", *pname)
+		err := removeProtocol(protocolDir, *shpath, *pname)
 		if err != nil {
 			fmt.Println(err)
 		} else {
@@ -139,11 +139,19 @@ func main() {
 		return
 	}
 
-	err = updateServer(*shpath+"/server/server.go", *pname, false)
+	err = ioutil.WriteFile(registerFile, []byte(register(*pname)), 0644)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	for _, bin := range []string{"server", "client"} {
+		err = ioutil.WriteFile(importFile(*shpath, bin, *pname),
+			[]byte(importProtocol(*pname)), 0644)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
 
 	err = os.Symlink(protocolDir, "./"+*pname)
 	if err != nil {
@@ -194,6 +202,7 @@ func protocol(name string, msgs []string) string {
 	}
 	p = p + "},\n"
 	p = p + "}\n\n"
+	p = p + "r.Protocol = \"" + name + "\"\n\n"
 
 	for _, msg := range msgs {
 		p = p + "r.cs." + msg + "RPC = r.RPC.Register(new(" + msg + "), r.cs." + msg + "Chan)\n"
@@ -245,49 +254,34 @@ func protocol(name string, msgs []string) string {
 	return p
 }
 
-func updateServer(filename, pname string, remove bool) error {
-	s, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return err
-	}
-	server := string(s)
-
-	imp := "//user imports\n"
-	impm := "\t\"github.com/vonaka/shreplic/user/" + pname + "\""
-	imp = imp + impm
-	if !remove {
-		server = strings.Replace(server, "//user imports", imp, 1)
-	} else {
-		server = strings.Replace(server, impm+"\n", "", 1)
-	}
-
-	flg := "//user flags\n"
-	flgn := "\tdo" + pname + " = flag.Bool(\"" + pname +
-		"\", false, \"Use " + pname + " as the replication protocol\")"
-	flg = flg + flgn
-	if !remove {
-		server = strings.Replace(server, "//user flags", flg, 1)
-	} else {
-		server = strings.Replace(server, flgn+"\n", "", 1)
-	}
-
-	calln := "\t} else if *do" + pname + " {\n"
-	calln = calln + "\t\tlog.Println(\"Starting " + pname + " replica...\")\n"
-	calln = calln + "\t\trep := " + pname +
-		".NewReplica(replicaId, *maxfailures, nodeList, " +
-		"*thrifty, *exec, *lread, *dreply, *args, ps)\n"
-	calln = calln + "\t\trpc.Register(rep)\n"
-	call := calln + "\t} else if *doPaxoi {"
-	if !remove {
-		server = strings.Replace(server, "\t} else if *doPaxoi {", call, 1)
-	} else {
-		server = strings.Replace(server, calln, "", 1)
-	}
-
-	return ioutil.WriteFile(filename, []byte(server), 0644)
+// register returns the file registering the protocol name.
+func register(name string) string {
+	p := "package " + name + "\n\n"
+	p = p + "import \"github.com/vonaka/shreplic/server/smr\"\n\n"
+	p = p + "func init() {\n"
+	p = p + "\tsmr.RegisterProtocol(&smr.Protocol{\n"
+	p = p + "\t\tName:  \"" + name + "\",\n"
+	p = p + "\t\tFlags: []string{\"thrifty\", \"lread\"},\n"
+	p = p + "\t\tNewReplica: func(o *smr.Options) interface{} {\n"
+	p = p + "\t\t\treturn NewReplica(o.Id, o.F, o.Addrs, o.Thrifty, o.Exec,\n"
+	p = p + "\t\t\t\to.LRead, o.Dreply, o.Args, o.Proxies)\n"
+	p = p + "\t\t},\n"
+	p = p + "\t})\n"
+	p = p + "}\n"
+	return p
+}
+
+// importFile returns the file of bin (server or client)
+// importing the protocol name.
+func importFile(shpath, bin, name string) string {
+	return shpath + "/" + bin + "/user_" + name + ".go"
 }
 
-func removeProtocol(dir, serverf, pname string) error {
+func importProtocol(name string) string {
+	return "package main\n\nimport _ \"github.com/vonaka/shreplic/user/" + name + "\"\n"
+}
+
+func removeProtocol(dir, shpath, pname string) error {
 	err := os.RemoveAll(dir)
 	if err != nil {
 		return err
@@ -296,7 +290,13 @@ func removeProtocol(dir, serverf, pname string) error {
 	if err != nil {
 		return err
 	}
-	return updateServer(serverf, pname, true)
+	for _, bin := range []string{"server", "client"} {
+		err = os.Remove(importFile(shpath, bin, pname))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 func getDefs(filename string) (string, []string, error) {
@@ -97,6 +97,47 @@ func Conflict(gamma *Command, delta *Command) bool {
 	return false
 }
 
+// Snapshot writes every key followed by its value to w, and returns
+// the number of keys.
+func (st *State) Snapshot(w io.Writer) int {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	it := st.Store.Iterator()
+	for it.Next() {
+		k, v := it.Key().(Key), it.Value().(Value)
+		k.Marshal(w)
+		v.Marshal(w)
+	}
+	return st.Store.Size()
+}
+
+// Restore replaces the state by the keys and values written by
+// Snapshot, and returns the number of keys read.
+func (st *State) Restore(r io.Reader) (int, error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.Store.Clear()
+	n := 0
+	for {
+		var (
+			k Key
+			v Value
+		)
+		if err := k.Unmarshal(r); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		if err := v.Unmarshal(r); err != nil {
+			return n, err
+		}
+		st.Store.Put(k, v)
+		n++
+	}
+}
+
 func ConflictBatch(batch1 []Command, batch2 []Command) bool {
 	for i := 0; i < len(batch1); i++ {
 		for j := 0; j < len(batch2); j++ {
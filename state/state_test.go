@@ -0,0 +1,106 @@
+package state
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	st := InitState()
+	values := map[Key]Value{
+		-1: Value("negative"),
+		0:  NIL(),
+		1:  Value("one"),
+		42: Value(bytes.Repeat([]byte("x"), 1000)),
+	}
+	for k, v := range values {
+		put := Command{PUT, k, v}
+		put.Execute(st)
+	}
+
+	buf := &bytes.Buffer{}
+	if n := st.Snapshot(buf); n != len(values) {
+		t.Fatalf("%d keys written, want %d", n, len(values))
+	}
+	snapshot := buf.Bytes()
+
+	restored := InitState()
+	n, err := restored.Restore(bytes.NewReader(snapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(values) {
+		t.Fatalf("%d keys read, want %d", n, len(values))
+	}
+	for k, v := range values {
+		get := Command{GET, k, NIL()}
+		if got := get.Execute(restored); !bytes.Equal(got, v) {
+			t.Errorf("key %d: restored %q, want %q", k, got, v)
+		}
+	}
+
+	// the snapshot of the restored state is the same
+	again := &bytes.Buffer{}
+	restored.Snapshot(again)
+	if !bytes.Equal(again.Bytes(), snapshot) {
+		t.Error("snapshots differ after a restore")
+	}
+}
+
+func TestRestoreEmpty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if n := InitState().Snapshot(buf); n != 0 || buf.Len() != 0 {
+		t.Fatalf("%d keys and %d bytes written for an empty state", n, buf.Len())
+	}
+	n, err := InitState().Restore(buf)
+	if n != 0 || err != nil {
+		t.Errorf("%d keys read from an empty snapshot (error %v)", n, err)
+	}
+}
+
+func TestRestoreTruncated(t *testing.T) {
+	st := InitState()
+	for k := Key(0); k < 3; k++ {
+		put := Command{PUT, k, Value("value")}
+		put.Execute(st)
+	}
+	buf := &bytes.Buffer{}
+	st.Snapshot(buf)
+	snapshot := buf.Bytes()
+
+	// cut in the value, in the length and in the key of the last entry
+	for _, cut := range []int{3, 10, 15} {
+		restored := InitState()
+		n, err := restored.Restore(bytes.NewReader(snapshot[:len(snapshot)-cut]))
+		if err == nil {
+			t.Errorf("cut of %d bytes: no error", cut)
+		}
+		if n != 2 {
+			t.Errorf("cut of %d bytes: %d keys read, want 2", cut, n)
+		}
+	}
+}
+
+func TestRestoreReplaces(t *testing.T) {
+	st := InitState()
+	put := Command{PUT, 1, Value("one")}
+	put.Execute(st)
+	buf := &bytes.Buffer{}
+	st.Snapshot(buf)
+
+	restored := InitState()
+	for k := Key(1); k <= 3; k++ {
+		put := Command{PUT, k, Value("old")}
+		put.Execute(restored)
+	}
+	if _, err := restored.Restore(buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := restored.Store.Size(); n != 1 {
+		t.Fatalf("%d keys after the restore, want 1", n)
+	}
+	get := Command{GET, 1, NIL()}
+	if v := get.Execute(restored); string(v) != "one" {
+		t.Errorf("key 1: %q, want %q", v, "one")
+	}
+}
@@ -1,6 +1,11 @@
 package fastrpc
 
-import "io"
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+)
 
 type Serializable interface {
 	Marshal(io.Writer)
@@ -46,3 +51,15 @@ func (t *Table) Get(id uint8) (Pair, bool) {
 	p, exists := t.pairs[id]
 	return p, exists
 }
+
+// Fingerprint identifies the set of registered messages. Two tables
+// with the same fingerprint assign the same codes to the same types.
+func (t *Table) Fingerprint() uint64 {
+	h := fnv.New64a()
+	for id := uint8(0); id < t.id; id++ {
+		if p, exists := t.pairs[id]; exists {
+			fmt.Fprintf(h, "%d:%s;", id, reflect.TypeOf(p.Obj))
+		}
+	}
+	return h.Sum64()
+}
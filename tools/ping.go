@@ -0,0 +1,41 @@
+package tools
+
+import (
+	"errors"
+	"math"
+	"net"
+	"syscall"
+	"time"
+)
+
+var PingTimeout = 3 * time.Second
+
+// Ping returns the average round-trip time in milliseconds of count
+// TCP handshakes with addr, which must accept the connections. They are
+// closed before anything is sent, so that the replicas recognize them
+// as probes.
+func Ping(addr string, count int) (float64, error) {
+	return ping(addr, count, false)
+}
+
+// PingHost is like Ping, but addr does not need to accept connections
+// yet, as a refused connection also takes a round trip to its host.
+func PingHost(addr string, count int) (float64, error) {
+	return ping(addr, count, true)
+}
+
+func ping(addr string, count int, refused bool) (float64, error) {
+	total := time.Duration(0)
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", addr, PingTimeout)
+		rtt := time.Since(start)
+		if err == nil {
+			conn.Close()
+		} else if !refused || !errors.Is(err, syscall.ECONNREFUSED) {
+			return 0, err
+		}
+		total += rtt
+	}
+	return math.Round(float64(total.Microseconds())/float64(count)) / 1e3, nil
+}
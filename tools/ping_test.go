@@ -0,0 +1,39 @@
+package tools
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPing(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	if rtt, err := Ping(addr, 3); err != nil || rtt < 0 {
+		t.Errorf("Ping: %v ms, %v", rtt, err)
+	}
+	if rtt, err := PingHost(addr, 3); err != nil || rtt < 0 {
+		t.Errorf("PingHost: %v ms, %v", rtt, err)
+	}
+
+	// nothing listens on addr anymore
+	l.Close()
+	if _, err := Ping(addr, 1); err == nil {
+		t.Error("refused connection counted as a ping")
+	}
+	if _, err := PingHost(addr, 1); err != nil {
+		t.Errorf("PingHost of a refused connection: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/vonaka/shreplic/client/base"
 	"github.com/vonaka/shreplic/curp"
 	"github.com/vonaka/shreplic/paxoi"
+	"github.com/vonaka/shreplic/server/smr"
 )
 
 type ShreplicClient interface {
@@ -14,21 +15,38 @@ type ShreplicClient interface {
 	Write(int64, []byte)
 }
 
+// NewShreplicClient returns nil if the client cannot be started. If
+// protocol is empty, the client of the protocol run by the replicas
+// is used.
 func NewShreplicClient(protocol, maddr, collocated string, mport int,
 	fast, lread, leaderless, verbose bool, args string) ShreplicClient {
 
 	var c ShreplicClient
 
+	// the protocol and the number of replicas are given by the master
+	if protocol == "" {
+		info, err := base.GetClusterInfo(maddr, mport, nil)
+		if err != nil {
+			return nil
+		}
+		protocol = info.Protocol
+		args = smr.ClientArgs(args, info.N)
+	}
+
 	switch protocol {
-	case "base":
+	case "base", "paxos", "epaxos", "n2paxos":
 		c = base.NewSimpleClient(maddr, collocated, mport,
 			0, 0, 0, 0, fast, lread, leaderless, verbose, nil)
 	case "paxoi":
-		c = paxoi.NewClient(maddr, collocated, mport,
-			0, 0, 0, 0, fast, lread, leaderless, verbose, nil, args)
-	case "curp":
-		c = curp.NewClient(maddr, collocated, mport,
-			0, 0, 0, 0, fast, lread, leaderless, verbose, nil, args)
+		if pc := paxoi.NewClient(maddr, collocated, mport,
+			0, 0, 0, 0, fast, lread, leaderless, verbose, nil, args); pc != nil {
+			c = pc
+		}
+	case "curp", "curpOpt":
+		if cc := curp.NewClient(maddr, collocated, mport,
+			0, 0, 0, 0, fast, lread, leaderless, verbose, nil, args); cc != nil {
+			c = cc
+		}
 	}
 
 	return c